@@ -0,0 +1,546 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jeremyt135/tictactoe/pkg/tournament"
+)
+
+// Config is the configuration of the tictactoe binary. It is read from a
+// JSON file given with -config, and any flags given override the file.
+type Config struct {
+	// Listeners are the addresses to accept players on.
+	Listeners []ListenerConfig `json:"listeners"`
+
+	// MaxLineLength is the longest message a client may send, 0 for the default.
+	MaxLineLength int `json:"max_line_length,omitempty"`
+
+	Lobbies          int      `json:"lobbies"`
+	SeriesLength     int      `json:"series_length"`
+	HandshakeTimeout Duration `json:"handshake_timeout"`
+	TurnTimeout      Duration `json:"turn_timeout"`
+	MaxTurnAttempts  int      `json:"max_turn_attempts"`
+
+	Limits LimitsConfig `json:"limits"`
+
+	Log     LogConfig     `json:"log"`
+	TLS     TLSConfig     `json:"tls"`
+	Storage StorageConfig `json:"storage"`
+
+	// AccessFile is a list of CIDR ranges to allow or deny, in the format
+	// read by server.LoadAccessList. It is read again on reload.
+	AccessFile string `json:"access_file,omitempty"`
+
+	// AdminAddress and StatusAddress enable the admin console and the status API.
+	AdminAddress  string `json:"admin_address,omitempty"`
+	StatusAddress string `json:"status_address,omitempty"`
+
+	Tournament *TournamentConfig `json:"tournament,omitempty"`
+	Webhooks   *WebhooksConfig   `json:"webhooks,omitempty"`
+
+	Transcripts *TranscriptsConfig `json:"transcripts,omitempty"`
+	Chat        *ChatConfig        `json:"chat,omitempty"`
+
+	// Sync sends players the board as a STATE line when a game starts and when they send SYNC.
+	Sync bool `json:"sync,omitempty"`
+
+	Heartbeat *HeartbeatConfig `json:"heartbeat,omitempty"`
+}
+
+// ListenerConfig describes one listener. Mode is tcp, tls, ws or wss, and
+// Address is accepted by server.ListenTcp, such as ":42000" or "unix:/run/tictactoe.sock".
+type ListenerConfig struct {
+	Mode    string `json:"mode"`
+	Address string `json:"address"`
+}
+
+func (l ListenerConfig) String() string {
+	return l.Mode + "=" + l.Address
+}
+
+// LimitsConfig caps connections and message rates. Zero disables a limit.
+type LimitsConfig struct {
+	MaxConnections      int     `json:"max_connections"`
+	MaxConnectionsPerIP int     `json:"max_connections_per_ip"`
+	HandshakesPerSecond float64 `json:"handshakes_per_second"`
+	HandshakeBurst      int     `json:"handshake_burst"`
+	MessagesPerSecond   float64 `json:"messages_per_second"`
+	MessageBurst        int     `json:"message_burst"`
+
+	// SendQueueSize and SendTimeout bound the messages waiting for a slow
+	// client. Zero uses the server defaults.
+	SendQueueSize int      `json:"send_queue_size,omitempty"`
+	SendTimeout   Duration `json:"send_timeout,omitempty"`
+}
+
+// LogConfig selects the log level (debug, info, warn or error) and format (console or json).
+type LogConfig struct {
+	Level  string `json:"level"`
+	Format string `json:"format"`
+}
+
+// TLSConfig holds the certificate used by tls and wss listeners.
+type TLSConfig struct {
+	CertFile          string `json:"cert_file,omitempty"`
+	KeyFile           string `json:"key_file,omitempty"`
+	ClientCAFile      string `json:"client_ca_file,omitempty"`
+	RequireClientCert bool   `json:"require_client_cert,omitempty"`
+
+	// SelfSigned generates a development certificate if CertFile doesn't exist.
+	SelfSigned bool `json:"self_signed,omitempty"`
+}
+
+// Enabled returns true if a certificate is configured.
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != ""
+}
+
+// StorageConfig holds the paths of files written by the server. Relative
+// paths are resolved against Dir.
+type StorageConfig struct {
+	Dir string `json:"dir"`
+}
+
+// Path resolves name against the storage directory.
+func (s StorageConfig) Path(name string) string {
+	if name == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(s.Dir, name)
+}
+
+// TournamentConfig enables a tournament with the given format, number of players and rounds.
+type TournamentConfig struct {
+	Format  string `json:"format"`
+	Players int    `json:"players"`
+	Rounds  int    `json:"rounds,omitempty"`
+}
+
+// WebhooksConfig posts the result of every game to URLs, signed with Secret
+// if it is set. Deliveries that keep failing are written to DeadLetterFile,
+// which is resolved against the storage directory.
+type WebhooksConfig struct {
+	URLs           []string `json:"urls"`
+	Secret         string   `json:"secret,omitempty"`
+	MaxAttempts    int      `json:"max_attempts,omitempty"`
+	Backoff        Duration `json:"backoff,omitempty"`
+	DeadLetterFile string   `json:"dead_letter_file,omitempty"`
+}
+
+// TranscriptsConfig writes a transcript of every game to Dir, which is
+// resolved against the storage directory. Zero limits use the server defaults.
+type TranscriptsConfig struct {
+	Dir      string `json:"dir"`
+	MaxBytes int64  `json:"max_bytes,omitempty"`
+	MaxFiles int    `json:"max_files,omitempty"`
+}
+
+// ChatConfig lets players chat with their opponent. Zero values use the server defaults.
+type ChatConfig struct {
+	MaxLength         int     `json:"max_length,omitempty"`
+	MessagesPerSecond float64 `json:"messages_per_second,omitempty"`
+	Burst             int     `json:"burst,omitempty"`
+}
+
+// HeartbeatConfig sends players a PING every Interval and disconnects those
+// that leave MaxMissed in a row unanswered. Zero values use the server defaults.
+type HeartbeatConfig struct {
+	Interval  Duration `json:"interval,omitempty"`
+	MaxMissed int      `json:"max_missed,omitempty"`
+}
+
+// Duration is a time.Duration written in JSON as a string such as "30s".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// DefaultConfig returns the configuration used for settings not in the file or flags.
+func DefaultConfig() *Config {
+	return &Config{
+		Listeners:        []ListenerConfig{{Mode: "tcp", Address: ":42000"}},
+		Lobbies:          2,
+		SeriesLength:     1,
+		HandshakeTimeout: Duration(time.Minute),
+		MaxTurnAttempts:  3,
+		Log:              LogConfig{Level: "info", Format: "console"},
+		Storage:          StorageConfig{Dir: "."},
+	}
+}
+
+// LoadConfig reads a JSON config file on top of the defaults.
+func LoadConfig(path string) (*Config, error) {
+	cfg := DefaultConfig()
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read config: %w", err)
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Validate checks every setting, returning an error that names the first invalid one.
+func (c *Config) Validate() error {
+	if len(c.Listeners) == 0 {
+		return errors.New("listeners: at least one listener is required")
+	}
+	for i, l := range c.Listeners {
+		switch l.Mode {
+		case "tcp", "ws":
+		case "tls", "wss":
+			if !c.TLS.Enabled() {
+				return fmt.Errorf("listeners[%d]: mode %s requires tls.cert_file and tls.key_file", i, l.Mode)
+			}
+		default:
+			return fmt.Errorf("listeners[%d]: unknown mode %q, expected tcp, tls, ws or wss", i, l.Mode)
+		}
+		if l.Address == "" {
+			return fmt.Errorf("listeners[%d]: address is required", i)
+		}
+	}
+	if c.MaxLineLength < 0 {
+		return fmt.Errorf("max_line_length: must not be negative, got %d", c.MaxLineLength)
+	}
+	if c.Lobbies <= 0 {
+		return fmt.Errorf("lobbies: must be positive, got %d", c.Lobbies)
+	}
+	if c.SeriesLength < 0 {
+		return fmt.Errorf("series_length: must not be negative, got %d", c.SeriesLength)
+	}
+	if c.HandshakeTimeout <= 0 {
+		return fmt.Errorf("handshake_timeout: must be positive, got %s", time.Duration(c.HandshakeTimeout))
+	}
+	if c.TurnTimeout < 0 {
+		return fmt.Errorf("turn_timeout: must not be negative, got %s", time.Duration(c.TurnTimeout))
+	}
+	if c.MaxTurnAttempts <= 0 {
+		return fmt.Errorf("max_turn_attempts: must be positive, got %d", c.MaxTurnAttempts)
+	}
+	if c.Limits.MaxConnections < 0 || c.Limits.MaxConnectionsPerIP < 0 {
+		return errors.New("limits: connection limits must not be negative")
+	}
+	if c.Limits.HandshakesPerSecond < 0 || c.Limits.HandshakeBurst < 0 {
+		return errors.New("limits: handshake rate must not be negative")
+	}
+	if c.Limits.MessagesPerSecond < 0 || c.Limits.MessageBurst < 0 {
+		return errors.New("limits: message rate must not be negative")
+	}
+	if c.Limits.SendQueueSize < 0 || c.Limits.SendTimeout < 0 {
+		return errors.New("limits: send queue must not be negative")
+	}
+	switch c.Log.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		return fmt.Errorf("log.level: unknown level %q, expected debug, info, warn or error", c.Log.Level)
+	}
+	switch c.Log.Format {
+	case "console", "json":
+	default:
+		return fmt.Errorf("log.format: unknown format %q, expected console or json", c.Log.Format)
+	}
+	if c.TLS.Enabled() && (c.TLS.CertFile == "" || c.TLS.KeyFile == "") {
+		return errors.New("tls: cert_file and key_file must be given together")
+	}
+	if c.TLS.RequireClientCert && c.TLS.ClientCAFile == "" {
+		return errors.New("tls: require_client_cert needs client_ca_file")
+	}
+	if c.Storage.Dir == "" {
+		return errors.New("storage.dir: must not be empty")
+	}
+	if c.Tournament != nil {
+		if _, err := tournament.ParseFormat(c.Tournament.Format); err != nil {
+			return fmt.Errorf("tournament.format: %w", err)
+		}
+		if c.Tournament.Players < 2 {
+			return fmt.Errorf("tournament.players: need at least 2, got %d", c.Tournament.Players)
+		}
+	}
+	if c.Webhooks != nil {
+		if len(c.Webhooks.URLs) == 0 {
+			return errors.New("webhooks.urls: at least one URL is required")
+		}
+		for i, u := range c.Webhooks.URLs {
+			if parsed, err := url.Parse(u); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+				return fmt.Errorf("webhooks.urls[%d]: %q is not an http or https URL", i, u)
+			}
+		}
+		if c.Webhooks.MaxAttempts < 0 || c.Webhooks.Backoff < 0 {
+			return errors.New("webhooks: max_attempts and backoff must not be negative")
+		}
+	}
+	if c.Transcripts != nil {
+		if c.Transcripts.Dir == "" {
+			return errors.New("transcripts.dir: must not be empty")
+		}
+		if c.Transcripts.MaxBytes < 0 || c.Transcripts.MaxFiles < 0 {
+			return errors.New("transcripts: max_bytes and max_files must not be negative")
+		}
+	}
+	if c.Chat != nil && (c.Chat.MaxLength < 0 || c.Chat.MessagesPerSecond < 0 || c.Chat.Burst < 0) {
+		return errors.New("chat: max_length, messages_per_second and burst must not be negative")
+	}
+	if c.Heartbeat != nil && (c.Heartbeat.Interval < 0 || c.Heartbeat.MaxMissed < 0) {
+		return errors.New("heartbeat: interval and max_missed must not be negative")
+	}
+	return nil
+}
+
+// listenerFlag collects repeated -listen flags.
+type listenerFlag []ListenerConfig
+
+func (f *listenerFlag) String() string {
+	specs := make([]string, len(*f))
+	for i, l := range *f {
+		specs[i] = l.String()
+	}
+	return strings.Join(specs, ",")
+}
+
+func (f *listenerFlag) Set(spec string) error {
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("expected mode=address, got %q", spec)
+	}
+	*f = append(*f, ListenerConfig{Mode: parts[0], Address: parts[1]})
+	return nil
+}
+
+// stringsFlag collects a repeated flag.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+// durationFlag is a flag.Value setting a Duration.
+type durationFlag struct{ d *Duration }
+
+func (f durationFlag) String() string {
+	if f.d == nil {
+		return ""
+	}
+	return time.Duration(*f.d).String()
+}
+
+func (f durationFlag) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*f.d = Duration(d)
+	return nil
+}
+
+// ParseConfig builds the Config from command-line arguments: the file given
+// with -config is loaded first, then every flag that was set overrides it.
+// It returns true if the effective configuration should be printed.
+func ParseConfig(args []string) (cfg *Config, printConfig bool, err error) {
+	fs := flag.NewFlagSet("tictactoe", flag.ContinueOnError)
+	path := fs.String("config", "", "path to a JSON config file")
+	fs.BoolVar(&printConfig, "print-config", false, "print the effective configuration as JSON and exit")
+
+	// Flags are parsed into a separate Config, then copied over the
+	// loaded one only if they were given.
+	flags := DefaultConfig()
+	var listeners listenerFlag
+	var tournamentFormat string
+	var tournamentPlayers, tournamentRounds int
+	var webhooks stringsFlag
+	var transcripts string
+	var chat bool
+	var heartbeat Duration
+	fs.Var(&listeners, "listen", "listener as mode=address, may be repeated (modes: tcp, tls, ws, wss)")
+	fs.IntVar(&flags.MaxLineLength, "max-line-length", 0, "longest message a client may send, 0 for the default")
+	fs.IntVar(&flags.Lobbies, "lobbies", flags.Lobbies, "number of lobbies in the pool")
+	fs.IntVar(&flags.SeriesLength, "series", flags.SeriesLength, "games played between the same players")
+	fs.Var(durationFlag{&flags.HandshakeTimeout}, "handshake-timeout", "time a client has to answer the greeting")
+	fs.Var(durationFlag{&flags.TurnTimeout}, "turn-timeout", "time a player has to move, 0 for no limit")
+	fs.IntVar(&flags.MaxTurnAttempts, "max-turn-attempts", flags.MaxTurnAttempts, "invalid moves allowed per turn")
+	fs.IntVar(&flags.Limits.MaxConnections, "max-conns", 0, "maximum connections, 0 for no limit")
+	fs.IntVar(&flags.Limits.MaxConnectionsPerIP, "max-conns-per-ip", 0, "maximum connections from one IP address, 0 for no limit")
+	fs.Float64Var(&flags.Limits.HandshakesPerSecond, "handshake-rate", 0, "connections per second allowed from one IP address, 0 for no limit")
+	fs.IntVar(&flags.Limits.HandshakeBurst, "handshake-burst", 0, "connections allowed at once from one IP address above the rate")
+	fs.Float64Var(&flags.Limits.MessagesPerSecond, "message-rate", 0, "messages per second allowed from a connection, 0 for no limit")
+	fs.IntVar(&flags.Limits.MessageBurst, "message-burst", 0, "messages allowed at once from a connection above the rate")
+	fs.IntVar(&flags.Limits.SendQueueSize, "send-queue", 0, "messages that can wait for a slow client before it is disconnected, 0 for the default")
+	fs.Var(durationFlag{&flags.Limits.SendTimeout}, "send-timeout", "time a client has to accept each message, 0 for the default")
+	fs.StringVar(&flags.Log.Level, "log-level", flags.Log.Level, "log level: debug, info, warn or error")
+	fs.StringVar(&flags.Log.Format, "log-format", flags.Log.Format, "log format: console or json")
+	fs.StringVar(&flags.TLS.CertFile, "tls-cert", "", "TLS certificate file")
+	fs.StringVar(&flags.TLS.KeyFile, "tls-key", "", "TLS private key file")
+	fs.StringVar(&flags.TLS.ClientCAFile, "tls-client-ca", "", "CA certificates for verifying client certificates")
+	fs.BoolVar(&flags.TLS.RequireClientCert, "tls-require-client-cert", false, "reject clients without a verified certificate")
+	fs.BoolVar(&flags.TLS.SelfSigned, "tls-self-signed", false, "generate a self-signed certificate if the certificate file doesn't exist")
+	fs.StringVar(&flags.Storage.Dir, "data-dir", flags.Storage.Dir, "directory for files written by the server")
+	fs.StringVar(&flags.AccessFile, "access-file", "", "file of CIDR ranges to allow or deny")
+	fs.StringVar(&flags.AdminAddress, "admin", "", "admin console address, loopback host:port or unix:path")
+	fs.StringVar(&flags.StatusAddress, "status", "", "status API address")
+	fs.StringVar(&tournamentFormat, "tournament", "", "run a tournament: roundrobin, swiss or knockout")
+	fs.IntVar(&tournamentPlayers, "tournament-players", 0, "number of tournament players")
+	fs.IntVar(&tournamentRounds, "tournament-rounds", 0, "number of Swiss rounds")
+	fs.Var(&webhooks, "webhook", "URL to post game results to, may be repeated")
+	fs.StringVar(&transcripts, "transcripts", "", "directory to write game transcripts to")
+	fs.BoolVar(&chat, "chat", false, "let players chat with their opponent")
+	fs.BoolVar(&flags.Sync, "sync", false, "send players the board on joining and when they send SYNC")
+	fs.Var(durationFlag{&heartbeat}, "heartbeat", "interval between PINGs to players, 0 for the default")
+	if err := fs.Parse(args); err != nil {
+		return nil, false, err
+	}
+
+	cfg = DefaultConfig()
+	if *path != "" {
+		if cfg, err = LoadConfig(*path); err != nil {
+			return nil, false, err
+		}
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "listen":
+			cfg.Listeners = listeners
+		case "max-line-length":
+			cfg.MaxLineLength = flags.MaxLineLength
+		case "lobbies":
+			cfg.Lobbies = flags.Lobbies
+		case "series":
+			cfg.SeriesLength = flags.SeriesLength
+		case "handshake-timeout":
+			cfg.HandshakeTimeout = flags.HandshakeTimeout
+		case "turn-timeout":
+			cfg.TurnTimeout = flags.TurnTimeout
+		case "max-turn-attempts":
+			cfg.MaxTurnAttempts = flags.MaxTurnAttempts
+		case "max-conns":
+			cfg.Limits.MaxConnections = flags.Limits.MaxConnections
+		case "max-conns-per-ip":
+			cfg.Limits.MaxConnectionsPerIP = flags.Limits.MaxConnectionsPerIP
+		case "handshake-rate":
+			cfg.Limits.HandshakesPerSecond = flags.Limits.HandshakesPerSecond
+		case "handshake-burst":
+			cfg.Limits.HandshakeBurst = flags.Limits.HandshakeBurst
+		case "message-rate":
+			cfg.Limits.MessagesPerSecond = flags.Limits.MessagesPerSecond
+		case "message-burst":
+			cfg.Limits.MessageBurst = flags.Limits.MessageBurst
+		case "send-queue":
+			cfg.Limits.SendQueueSize = flags.Limits.SendQueueSize
+		case "send-timeout":
+			cfg.Limits.SendTimeout = flags.Limits.SendTimeout
+		case "log-level":
+			cfg.Log.Level = flags.Log.Level
+		case "log-format":
+			cfg.Log.Format = flags.Log.Format
+		case "tls-cert":
+			cfg.TLS.CertFile = flags.TLS.CertFile
+		case "tls-key":
+			cfg.TLS.KeyFile = flags.TLS.KeyFile
+		case "tls-client-ca":
+			cfg.TLS.ClientCAFile = flags.TLS.ClientCAFile
+		case "tls-require-client-cert":
+			cfg.TLS.RequireClientCert = flags.TLS.RequireClientCert
+		case "tls-self-signed":
+			cfg.TLS.SelfSigned = flags.TLS.SelfSigned
+		case "data-dir":
+			cfg.Storage.Dir = flags.Storage.Dir
+		case "access-file":
+			cfg.AccessFile = flags.AccessFile
+		case "admin":
+			cfg.AdminAddress = flags.AdminAddress
+		case "status":
+			cfg.StatusAddress = flags.StatusAddress
+		case "tournament":
+			if cfg.Tournament == nil {
+				cfg.Tournament = &TournamentConfig{}
+			}
+			cfg.Tournament.Format = tournamentFormat
+		case "webhook":
+			if cfg.Webhooks == nil {
+				cfg.Webhooks = &WebhooksConfig{}
+			}
+			cfg.Webhooks.URLs = webhooks
+		case "transcripts":
+			if cfg.Transcripts == nil {
+				cfg.Transcripts = &TranscriptsConfig{}
+			}
+			cfg.Transcripts.Dir = transcripts
+		case "chat":
+			if !chat {
+				cfg.Chat = nil
+			} else if cfg.Chat == nil {
+				cfg.Chat = &ChatConfig{}
+			}
+		case "sync":
+			cfg.Sync = flags.Sync
+		case "heartbeat":
+			if cfg.Heartbeat == nil {
+				cfg.Heartbeat = &HeartbeatConfig{}
+			}
+			cfg.Heartbeat.Interval = heartbeat
+		}
+	})
+	if cfg.Tournament != nil {
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "tournament-players":
+				cfg.Tournament.Players = tournamentPlayers
+			case "tournament-rounds":
+				cfg.Tournament.Rounds = tournamentRounds
+			}
+		})
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, false, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return cfg, printConfig, nil
+}
+
+// Print writes the configuration as indented JSON, with secrets redacted.
+func (c *Config) Print() error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(c.redacted())
+}
+
+// redacted returns a copy of c with its secrets hidden.
+func (c *Config) redacted() *Config {
+	out := *c
+	if c.Webhooks != nil && c.Webhooks.Secret != "" {
+		webhooks := *c.Webhooks
+		webhooks.Secret = "REDACTED"
+		out.Webhooks = &webhooks
+	}
+	return &out
+}
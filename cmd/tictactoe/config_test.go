@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConfigFlagsOverrideFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "tictactoe-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"lobbies": 4, "turn_timeout": "20s", "listeners": [{"mode": "ws", "address": ":8080"}]}`)
+	f.Close()
+
+	cfg, _, err := ParseConfig([]string{"-config", f.Name(), "-lobbies", "6"})
+	if err != nil {
+		t.Fatalf("ParseConfig returned error: %v", err)
+	}
+	if cfg.Lobbies != 6 {
+		t.Errorf("lobbies = %d, expected the flag value 6", cfg.Lobbies)
+	}
+	if time.Duration(cfg.TurnTimeout) != 20*time.Second {
+		t.Errorf("turn_timeout = %s, expected the file value 20s", time.Duration(cfg.TurnTimeout))
+	}
+	if len(cfg.Listeners) != 1 || cfg.Listeners[0].Mode != "ws" {
+		t.Errorf("listeners = %v, expected the file value", cfg.Listeners)
+	}
+	if cfg.MaxTurnAttempts != 3 {
+		t.Errorf("max_turn_attempts = %d, expected the default 3", cfg.MaxTurnAttempts)
+	}
+}
+
+func TestParseConfigTournamentFlagKeepsFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "tictactoe-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"tournament": {"format": "swiss", "players": 8, "rounds": 3}}`)
+	f.Close()
+
+	cfg, _, err := ParseConfig([]string{"-config", f.Name(), "-tournament", "knockout"})
+	if err != nil {
+		t.Fatalf("ParseConfig returned error: %v", err)
+	}
+	want := TournamentConfig{Format: "knockout", Players: 8, Rounds: 3}
+	if cfg.Tournament == nil || *cfg.Tournament != want {
+		t.Errorf("tournament = %+v, expected %+v", cfg.Tournament, want)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	cases := map[string]func(*Config){
+		"listeners[0]":       func(c *Config) { c.Listeners[0].Mode = "udp" },
+		"tls":                func(c *Config) { c.TLS.CertFile = "cert.pem" },
+		"log.level":          func(c *Config) { c.Log.Level = "verbose" },
+		"max_turn_attempts":  func(c *Config) { c.MaxTurnAttempts = 0 },
+		"max_line_length":    func(c *Config) { c.MaxLineLength = -1 },
+		"tournament.players": func(c *Config) { c.Tournament = &TournamentConfig{Format: "swiss", Players: 1} },
+		"webhooks.urls[0]":   func(c *Config) { c.Webhooks = &WebhooksConfig{URLs: []string{"league.example.com"}} },
+		"transcripts.dir":    func(c *Config) { c.Transcripts = &TranscriptsConfig{MaxFiles: 10} },
+		"chat":               func(c *Config) { c.Chat = &ChatConfig{MaxLength: -1} },
+		"heartbeat":          func(c *Config) { c.Heartbeat = &HeartbeatConfig{MaxMissed: -1} },
+	}
+	for setting, change := range cases {
+		cfg := DefaultConfig()
+		change(cfg)
+		err := cfg.Validate()
+		if err == nil || !strings.HasPrefix(err.Error(), setting) {
+			t.Errorf("Validate returned %v, expected an error about %s", err, setting)
+		}
+	}
+}
+
+func TestConfigRedacted(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Webhooks = &WebhooksConfig{URLs: []string{"https://league.example.com"}, Secret: "hunter2"}
+
+	out := cfg.redacted()
+	if out.Webhooks.Secret != "REDACTED" {
+		t.Errorf("printed secret %q, expected REDACTED", out.Webhooks.Secret)
+	}
+	if cfg.Webhooks.Secret != "hunter2" {
+		t.Errorf("redacting changed the secret in use to %q", cfg.Webhooks.Secret)
+	}
+}
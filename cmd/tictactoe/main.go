@@ -1,65 +1,239 @@
 package main
 
 import (
+	"fmt"
 	"log"
+	"net"
 	"os"
-	"strconv"
+	"time"
 
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 
+	"github.com/jeremyt135/tictactoe/pkg/logger"
 	"github.com/jeremyt135/tictactoe/pkg/server"
+	"github.com/jeremyt135/tictactoe/pkg/tournament"
 )
 
-func setupLogger() *zap.SugaredLogger {
-	logger, err := zap.NewDevelopment()
+func setupLogger(cfg LogConfig) (*zap.Logger, zap.AtomicLevel) {
+	var level zapcore.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		log.Fatalln("Could not create logger:", err)
+	}
+	zapConfig := zap.NewDevelopmentConfig()
+	if cfg.Format == "json" {
+		zapConfig = zap.NewProductionConfig()
+	}
+	zapConfig.Level = zap.NewAtomicLevelAt(level)
+	logger, err := zapConfig.Build()
 	if err != nil {
 		log.Fatalln("Could not create logger:", err)
 	}
-	sugar := logger.Sugar()
-	return sugar
+	return logger, zapConfig.Level
+}
+
+// tlsOptions returns the TLS settings for listeners, or nil if TLS is disabled.
+// With SelfSigned set, a development certificate is generated if the files don't exist.
+func tlsOptions(cfg *Config) *server.TLSOptions {
+	if !cfg.TLS.Enabled() {
+		return nil
+	}
+	opt := &server.TLSOptions{
+		CertFile:          cfg.Storage.Path(cfg.TLS.CertFile),
+		KeyFile:           cfg.Storage.Path(cfg.TLS.KeyFile),
+		ClientCAFile:      cfg.Storage.Path(cfg.TLS.ClientCAFile),
+		RequireClientCert: cfg.TLS.RequireClientCert,
+	}
+	if cfg.TLS.SelfSigned {
+		if _, err := os.Stat(opt.CertFile); os.IsNotExist(err) {
+			if err := server.GenerateSelfSigned(opt.CertFile, opt.KeyFile, []string{"localhost", "127.0.0.1", "::1"}); err != nil {
+				log.Fatalln(err)
+			}
+		}
+	}
+	return opt
+}
+
+// serverOptions converts the configuration into server.Options.
+func serverOptions(cfg *Config, logger logger.Logger) *server.Options {
+	opt := &server.Options{
+		NumLobbies:       cfg.Lobbies,
+		Logger:           logger,
+		SeriesLength:     cfg.SeriesLength,
+		HandshakeTimeout: time.Duration(cfg.HandshakeTimeout),
+		TurnTimeout:      time.Duration(cfg.TurnTimeout),
+		MaxTurnAttempts:  cfg.MaxTurnAttempts,
+		Sync:             cfg.Sync,
+		Limits: server.LimitOptions{
+			MaxConnections:      cfg.Limits.MaxConnections,
+			MaxConnectionsPerIP: cfg.Limits.MaxConnectionsPerIP,
+			HandshakesPerSecond: cfg.Limits.HandshakesPerSecond,
+			HandshakeBurst:      cfg.Limits.HandshakeBurst,
+			MessagesPerSecond:   cfg.Limits.MessagesPerSecond,
+			MessageBurst:        cfg.Limits.MessageBurst,
+			SendQueueSize:       cfg.Limits.SendQueueSize,
+			SendTimeout:         time.Duration(cfg.Limits.SendTimeout),
+		},
+	}
+	if cfg.Tournament != nil {
+		// The format was checked by Validate
+		format, _ := tournament.ParseFormat(cfg.Tournament.Format)
+		opt.Tournament = &server.TournamentOptions{
+			Format:       format,
+			Participants: cfg.Tournament.Players,
+			Rounds:       cfg.Tournament.Rounds,
+		}
+	}
+	if cfg.Webhooks != nil {
+		opt.Webhooks = &server.WebhookOptions{
+			URLs:           cfg.Webhooks.URLs,
+			Secret:         cfg.Webhooks.Secret,
+			MaxAttempts:    cfg.Webhooks.MaxAttempts,
+			Backoff:        time.Duration(cfg.Webhooks.Backoff),
+			DeadLetterFile: cfg.Storage.Path(cfg.Webhooks.DeadLetterFile),
+		}
+	}
+	if cfg.Chat != nil {
+		opt.Chat = &server.ChatOptions{
+			MaxLength:         cfg.Chat.MaxLength,
+			MessagesPerSecond: cfg.Chat.MessagesPerSecond,
+			Burst:             cfg.Chat.Burst,
+		}
+	}
+	if cfg.Heartbeat != nil {
+		opt.Heartbeat = &server.HeartbeatOptions{
+			Interval:  time.Duration(cfg.Heartbeat.Interval),
+			MaxMissed: cfg.Heartbeat.MaxMissed,
+		}
+	}
+	if cfg.Transcripts != nil {
+		opt.Transcripts = &server.TranscriptOptions{
+			Dir:      cfg.Storage.Path(cfg.Transcripts.Dir),
+			MaxBytes: cfg.Transcripts.MaxBytes,
+			MaxFiles: cfg.Transcripts.MaxFiles,
+		}
+	}
+	return opt
+}
+
+// closingListener is a server.Listener that can be closed.
+type closingListener interface {
+	server.Listener
+	Close() error
+}
+
+func listen(l ListenerConfig, cfg *Config, tlsOpt *server.TLSOptions, access *server.AccessList, logger logger.Logger) (closingListener, error) {
+	switch l.Mode {
+	case "tcp", "tls":
+		var tcp *server.TcpListener
+		var err error
+		if l.Mode == "tls" {
+			tcp, err = server.ListenTcpTLS(l.Address, tlsOpt, logger)
+		} else {
+			tcp, err = server.ListenTcp(l.Address, logger)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return tcp.UseAccessList(access).UseMaxLineLength(cfg.MaxLineLength), nil
+	case "ws", "wss":
+		var ws *server.WSListener
+		var err error
+		if l.Mode == "wss" {
+			ws, err = server.ListenWSTLS(l.Address, tlsOpt, logger)
+		} else {
+			ws, err = server.ListenWS(l.Address, logger)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return ws.UseAccessList(access).UseMaxLineLength(cfg.MaxLineLength), nil
+	default:
+		return nil, fmt.Errorf("invalid listener %s, unknown mode %s", l, l.Mode)
+	}
+}
+
+// accessList loads the access list file, or returns an empty list if there is none.
+func accessList(cfg *Config) (*server.AccessList, error) {
+	if cfg.AccessFile == "" {
+		return server.NewAccessList(), nil
+	}
+	return server.LoadAccessList(cfg.Storage.Path(cfg.AccessFile))
 }
 
 func main() {
-	logger := setupLogger()
-	defer logger.Sync()
+	cfg, printConfig, err := ParseConfig(os.Args[1:])
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if printConfig {
+		if err := cfg.Print(); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
 
-	srv, err := server.NewServer(&server.Options{
-		NumLobbies: 2,
-		Logger:     logger,
-	})
+	zapLogger, level := setupLogger(cfg.Log)
+	defer zapLogger.Sync()
+	logger := logger.Zap(zapLogger)
+
+	if err := os.MkdirAll(cfg.Storage.Dir, 0755); err != nil {
+		log.Fatalln("Could not create storage directory:", err)
+	}
+	tlsOpt := tlsOptions(cfg)
+	access, err := accessList(cfg)
 	if err != nil {
 		log.Fatalln(err)
 	}
 
-	port := 42000
-	if p, err := strconv.Atoi(os.Getenv("PORT")); err == nil && p != 0 {
-		port = p
+	r := &reloader{args: os.Args[1:], level: level, access: access, logger: logger}
+	opt := serverOptions(cfg, logger)
+	opt.Reload = r.reload
+	opt.AccessList = access
+	srv, err := server.NewServer(opt)
+	if err != nil {
+		log.Fatalln(err)
 	}
+	r.cfg, r.srv = cfg, srv
+	go r.watchSignals()
 
-	mode := "tcp"
-	if m := os.Getenv("MODE"); m == "ws" {
-		mode = m
+	var listeners []server.Listener
+	for _, lc := range cfg.Listeners {
+		l, err := listen(lc, cfg, tlsOpt, access, logger)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer l.Close()
+		listeners = append(listeners, l)
 	}
 
-	var l server.Listener
-	switch mode {
-	case "tcp":
-		tcp, err := server.ListenTcp(port, logger)
+	if addr := cfg.AdminAddress; addr != "" {
+		admin, err := server.ListenAdmin(addr)
 		if err != nil {
 			log.Fatalln(err)
 		}
-		l = tcp
-		defer tcp.Close()
-	case "ws":
-		ws, err := server.ListenWS(port, logger)
+		defer admin.Close()
+		go func() {
+			if err := srv.ServeAdmin(admin); err != nil {
+				logger.Error("admin console stopped", "error", err)
+			}
+		}()
+	}
+
+	if addr := cfg.StatusAddress; addr != "" {
+		status, err := net.Listen("tcp", addr)
 		if err != nil {
 			log.Fatalln(err)
 		}
-		l = ws
-		defer ws.Close()
+		defer status.Close()
+		go func() {
+			if err := srv.ServeStatus(status); err != nil {
+				logger.Error("status API stopped", "error", err)
+			}
+		}()
 	}
 
-	if err := srv.Serve(l); err != nil {
+	if err := srv.Serve(listeners...); err != nil {
 		log.Fatalln(err)
 	} else {
 		defer srv.Close()
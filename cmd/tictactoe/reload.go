@@ -0,0 +1,127 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+
+	"go.uber.org/zap"
+
+	"github.com/jeremyt135/tictactoe/pkg/logger"
+	"github.com/jeremyt135/tictactoe/pkg/server"
+)
+
+// reloader reads the configuration again on SIGHUP or the admin RELOAD
+// command, and applies what can be changed without a restart.
+type reloader struct {
+	mux    sync.Mutex
+	args   []string
+	cfg    *Config // the configuration in effect
+	srv    *server.Server
+	level  zap.AtomicLevel
+	access *server.AccessList
+	logger logger.Logger
+}
+
+// reload parses the configuration from the command-line arguments again
+// and applies it, returning the settings that changed.
+func (r *reloader) reload() (server.ReloadReport, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	var report server.ReloadReport
+	next, _, err := ParseConfig(r.args)
+	if err != nil {
+		return report, err
+	}
+
+	var access *server.AccessList
+	if next.AccessFile != "" || r.cfg.AccessFile != "" {
+		// Without a file the list is emptied
+		if access, err = accessList(next); err != nil {
+			return report, err
+		}
+	}
+
+	applied, err := r.srv.Reconfigure(serverOptions(next, r.logger))
+	if err != nil {
+		return report, err
+	}
+	report.Merge(applied)
+	r.cfg.Lobbies = next.Lobbies
+	r.cfg.SeriesLength = next.SeriesLength
+	r.cfg.HandshakeTimeout = next.HandshakeTimeout
+	r.cfg.TurnTimeout = next.TurnTimeout
+	r.cfg.MaxTurnAttempts = next.MaxTurnAttempts
+	r.cfg.Limits = next.Limits
+
+	if next.Log.Level != r.cfg.Log.Level {
+		// The level was checked by Validate
+		r.level.UnmarshalText([]byte(next.Log.Level))
+		r.cfg.Log.Level = next.Log.Level
+		report.Applied = append(report.Applied, "log.level")
+	}
+
+	if access != nil {
+		// Rules added from the admin console are replaced by the file
+		r.access.Replace(access)
+		report.Applied = append(report.Applied, "access_file")
+	}
+	r.cfg.AccessFile = next.AccessFile
+
+	report.RestartRequired = append(report.RestartRequired, restartRequired(r.cfg, next)...)
+	return report, nil
+}
+
+// restartRequired lists the settings that differ between the configuration
+// in effect and next but can't be changed while running. TLS certificates
+// are reloaded by the listeners themselves, but their paths can't change.
+// The tournament is checked by server.Reconfigure.
+func restartRequired(cfg, next *Config) []string {
+	var settings []string
+	if !reflect.DeepEqual(cfg.Listeners, next.Listeners) {
+		settings = append(settings, "listeners")
+	}
+	if cfg.MaxLineLength != next.MaxLineLength {
+		settings = append(settings, "max_line_length")
+	}
+	if cfg.Log.Format != next.Log.Format {
+		settings = append(settings, "log.format")
+	}
+	if cfg.TLS != next.TLS {
+		settings = append(settings, "tls")
+	}
+	if cfg.Storage != next.Storage {
+		settings = append(settings, "storage")
+	}
+	if cfg.AdminAddress != next.AdminAddress {
+		settings = append(settings, "admin_address")
+	}
+	if cfg.StatusAddress != next.StatusAddress {
+		settings = append(settings, "status_address")
+	}
+	return settings
+}
+
+// logReload logs the result of a reload.
+func (r *reloader) logReload(report server.ReloadReport, err error) {
+	if err != nil {
+		r.logger.Error("could not reload configuration, keeping the old one", "error", err)
+		return
+	}
+	r.logger.Info("reloaded configuration", "applied", report.Applied)
+	if len(report.RestartRequired) > 0 {
+		r.logger.Warn("changed settings that need a restart", "settings", report.RestartRequired)
+	}
+}
+
+// watchSignals reloads the configuration whenever the process receives SIGHUP.
+func (r *reloader) watchSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		r.logReload(r.reload())
+	}
+}
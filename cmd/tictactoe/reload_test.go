@@ -0,0 +1,53 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jeremyt135/tictactoe/pkg/logger"
+	"github.com/jeremyt135/tictactoe/pkg/server"
+)
+
+func TestReloadRemovedAccessFileClearsRules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tictactoe-reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	accessFile := filepath.Join(dir, "access.txt")
+	if err := ioutil.WriteFile(accessFile, []byte("deny 10.0.0.0/8\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	args := []string{"-access-file", accessFile}
+	cfg, _, err := ParseConfig(args)
+	if err != nil {
+		t.Fatalf("ParseConfig returned error: %v", err)
+	}
+	access, err := accessList(cfg)
+	if err != nil {
+		t.Fatalf("could not load access list: %v", err)
+	}
+	srv, err := server.NewServer(serverOptions(cfg, logger.NoOpLogger()))
+	if err != nil {
+		t.Fatalf("NewServer returned error: %v", err)
+	}
+	defer srv.Close()
+	r := &reloader{args: args, cfg: cfg, srv: srv, access: access, logger: logger.NoOpLogger()}
+
+	// The access file is taken out of the configuration
+	r.args = nil
+	report, err := r.reload()
+	if err != nil {
+		t.Fatalf("reload returned error: %v", err)
+	}
+	if rules := access.Rules(); len(rules) != 0 {
+		t.Errorf("access list has rules %v after its file was removed, expected none", rules)
+	}
+	if applied := strings.Join(report.Applied, ","); !strings.Contains(applied, "access_file") {
+		t.Errorf("applied %q, expected access_file", applied)
+	}
+}
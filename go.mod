@@ -3,7 +3,8 @@ module github.com/jeremyt135/tictactoe
 go 1.14
 
 require (
+	github.com/gorilla/websocket v1.5.0
 	go.uber.org/atomic v1.9.0 // indirect
 	go.uber.org/multierr v1.8.0 // indirect
-	go.uber.org/zap v1.21.0 // indirect
+	go.uber.org/zap v1.21.0
 )
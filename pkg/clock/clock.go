@@ -0,0 +1,61 @@
+// Package clock lets code that waits on time be driven by a fake clock in tests.
+package clock
+
+import (
+	"time"
+)
+
+// Clock tells the time and creates timers.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// NewTimer creates a Timer that sends the time on its channel once d has passed.
+	NewTimer(d time.Duration) Timer
+
+	// AfterFunc creates a Timer that calls f once d has passed. The Timer has no channel.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a single event, like a time.Timer.
+type Timer interface {
+	// C returns the channel the time is sent on, or nil for a Timer from AfterFunc.
+	C() <-chan time.Time
+
+	// Stop prevents the Timer from firing. It returns false if the Timer
+	// already fired or was stopped.
+	Stop() bool
+
+	// Reset changes the Timer to fire once d has passed. It returns true if
+	// the Timer had been active.
+	Reset(d time.Duration) bool
+}
+
+type system struct{}
+
+var systemSingleton = system{}
+
+// Real returns a Clock that uses the system clock.
+func Real() Clock {
+	return systemSingleton
+}
+
+func (system) Now() time.Time {
+	return time.Now()
+}
+
+func (system) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+func (system) AfterFunc(d time.Duration, f func()) Timer {
+	return systemTimer{time.AfterFunc(d, f)}
+}
+
+type systemTimer struct {
+	*time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
@@ -0,0 +1,146 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Fake is a Clock whose time only moves when Advance is called.
+// It is safe to use from many goroutines.
+type Fake struct {
+	mux    sync.Mutex
+	cond   *sync.Cond // signalled when a timer is started
+	now    time.Time
+	timers []*fakeTimer // active timers
+}
+
+// NewFake creates a Fake set to now.
+func NewFake(now time.Time) *Fake {
+	f := &Fake{now: now}
+	f.cond = sync.NewCond(&f.mux)
+	return f
+}
+
+func (f *Fake) Now() time.Time {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return f.now
+}
+
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	t := &fakeTimer{clock: f, c: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+// AfterFunc creates a Timer that calls fn from the goroutine that calls
+// Advance, once d has passed.
+func (f *Fake) AfterFunc(d time.Duration, fn func()) Timer {
+	t := &fakeTimer{clock: f, fn: fn}
+	t.Reset(d)
+	return t
+}
+
+// Advance moves the time forward by d, firing the timers that become due
+// in the order they are due.
+func (f *Fake) Advance(d time.Duration) {
+	f.mux.Lock()
+	f.now = f.now.Add(d)
+	var due []*fakeTimer
+	active := f.timers[:0]
+	for _, t := range f.timers {
+		if t.when.After(f.now) {
+			active = append(active, t)
+		} else {
+			due = append(due, t)
+		}
+	}
+	f.timers = active
+	f.mux.Unlock()
+
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].when.Before(due[j].when)
+	})
+	for _, t := range due {
+		t.fire()
+	}
+}
+
+// BlockUntil waits until at least n timers are active, so a test can
+// Advance once the code under test has started waiting.
+func (f *Fake) BlockUntil(n int) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	for len(f.timers) < n {
+		f.cond.Wait()
+	}
+}
+
+// Timers returns the number of active timers.
+func (f *Fake) Timers() int {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return len(f.timers)
+}
+
+// removeLocked removes t from the active timers, returning false if it wasn't active.
+func (f *Fake) removeLocked(t *fakeTimer) bool {
+	for i, other := range f.timers {
+		if other == t {
+			f.timers = append(f.timers[:i], f.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTimer struct {
+	clock *Fake
+	when  time.Time // guarded by clock.mux
+	c     chan time.Time
+	fn    func()
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mux.Lock()
+	defer t.clock.mux.Unlock()
+	return t.clock.removeLocked(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	f := t.clock
+	f.mux.Lock()
+	active := f.removeLocked(t)
+	t.when = f.now.Add(d)
+	if d <= 0 {
+		f.mux.Unlock()
+		if t.fn != nil {
+			// fn may need locks held by the caller
+			go t.fn()
+		} else {
+			t.fire()
+		}
+		return active
+	}
+	f.timers = append(f.timers, t)
+	f.cond.Broadcast()
+	f.mux.Unlock()
+	return active
+}
+
+func (t *fakeTimer) fire() {
+	if t.fn != nil {
+		t.fn()
+		return
+	}
+	select {
+	case t.c <- t.when:
+	default:
+		// like a time.Timer, a value that hasn't been received is kept
+	}
+}
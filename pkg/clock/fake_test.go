@@ -0,0 +1,66 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeAdvance(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := NewFake(start)
+
+	var order []string
+	f.AfterFunc(2*time.Second, func() { order = append(order, "second") })
+	f.AfterFunc(time.Second, func() { order = append(order, "first") })
+	timer := f.NewTimer(3 * time.Second)
+	stopped := f.NewTimer(time.Second)
+	if !stopped.Stop() {
+		t.Error("Stop on active timer returned false, expected true")
+	}
+	if n := f.Timers(); n != 3 {
+		t.Errorf("clock has %d timers, expected 3", n)
+	}
+
+	f.Advance(2 * time.Second)
+	if len(order) != 2 || order[0] != "first" || order[1] != "second" {
+		t.Errorf("functions ran in order %v, expected [first second]", order)
+	}
+	select {
+	case <-timer.C():
+		t.Fatal("timer fired before it was due")
+	default:
+	}
+
+	f.Advance(time.Second)
+	select {
+	case now := <-timer.C():
+		if expected := start.Add(3 * time.Second); !now.Equal(expected) {
+			t.Errorf("timer sent %v, expected %v", now, expected)
+		}
+	default:
+		t.Fatal("timer did not fire when due")
+	}
+	if timer.Stop() {
+		t.Error("Stop on fired timer returned true, expected false")
+	}
+	if now := f.Now(); !now.Equal(start.Add(3 * time.Second)) {
+		t.Errorf("clock is at %v, expected 3s after start", now)
+	}
+}
+
+func TestFakeBlockUntil(t *testing.T) {
+	f := NewFake(time.Time{})
+	fired := make(chan struct{})
+	go func() {
+		<-f.NewTimer(time.Minute).C()
+		close(fired)
+	}()
+
+	f.BlockUntil(1)
+	f.Advance(time.Minute)
+	select {
+	case <-fired:
+	case <-time.After(time.Second):
+		t.Fatal("timer did not fire after Advance")
+	}
+}
@@ -44,6 +44,11 @@ func (board *Board) String() string {
 	return output
 }
 
+// Grid returns a copy of the tokens in every cell of the Board.
+func (board *Board) Grid() [numRows][numCols]string {
+	return board.grid
+}
+
 // IsFull returns true if the Board is full (every cell has a nonempty token).
 func (board *Board) IsFull() bool {
 	return board.numTokens == numRows*numCols
@@ -59,6 +64,24 @@ func (board *Board) WinningToken() string {
 	return board.winningToken
 }
 
+// Moves returns the number of tokens placed on the Board.
+func (board *Board) Moves() int {
+	return board.numTokens
+}
+
+// Turn returns the token that moves next, or tokens.Empty if the game is
+// over. X always moves first.
+func (board *Board) Turn() string {
+	switch {
+	case board.HasWinner() || board.IsFull():
+		return tokens.Empty
+	case board.numTokens%2 == 0:
+		return tokens.X
+	default:
+		return tokens.O
+	}
+}
+
 // At returns the value of the Board at (row,col).
 //
 // RangeError is returned when the given (row, col) pair is out of range.
@@ -1,32 +1,36 @@
+// Package logger defines the structured logging interface used by the server,
+// with adapters for zap and log/slog.
 package logger
 
-import (
-	"os"
-)
-
-// Logger encapsulates interchangeable logging functionality needed by the server.
+// Logger writes log entries made of a message and pairs of keys and
+// values, such as Info("player removed", "lobby", 3, "reason", "timeout").
 type Logger interface {
-	// Info writes data with "Info" priority. It formats output like fmt.Sprint().
-	Info(...interface{})
+	// Debug writes an entry with "Debug" priority, for detail that is only
+	// useful when tracking down a problem.
+	Debug(msg string, keysAndValues ...interface{})
+
+	// Info writes an entry with "Info" priority.
+	Info(msg string, keysAndValues ...interface{})
 
-	// Warn writes data with "Warning" priority. It formats output like fmt.Sprint().
-	Warn(...interface{})
+	// Warn writes an entry with "Warning" priority.
+	Warn(msg string, keysAndValues ...interface{})
 
-	// Error writes data with "Error" priority. It formats output like fmt.Sprint().
-	Error(...interface{})
+	// Error writes an entry with "Error" priority.
+	Error(msg string, keysAndValues ...interface{})
 
-	// Fatal writes data with "Fatal" priority and calls os.Exit(1). It formats output like fmt.Sprint().
-	Fatal(...interface{})
+	// With returns a Logger that adds keysAndValues to every entry it writes.
+	With(keysAndValues ...interface{}) Logger
 }
 
 type noOp struct{}
 
 var noOpSingleton = &noOp{}
 
-func (n *noOp) Warn(...interface{})  {}
-func (n *noOp) Error(...interface{}) {}
-func (n *noOp) Info(...interface{})  {}
-func (n *noOp) Fatal(...interface{}) { os.Exit(1) }
+func (n *noOp) Debug(string, ...interface{}) {}
+func (n *noOp) Info(string, ...interface{})  {}
+func (n *noOp) Warn(string, ...interface{})  {}
+func (n *noOp) Error(string, ...interface{}) {}
+func (n *noOp) With(...interface{}) Logger   { return n }
 
 // NoOpLogger returns an instance of a Logger that does not perform writes when called.
 // The same instance is returned every time.
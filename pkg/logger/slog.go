@@ -0,0 +1,37 @@
+//go:build go1.21
+// +build go1.21
+
+package logger
+
+import (
+	"log/slog"
+)
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+// Slog returns a Logger that writes to l.
+func Slog(l *slog.Logger) Logger {
+	return slogLogger{l}
+}
+
+func (s slogLogger) Debug(msg string, keysAndValues ...interface{}) {
+	s.l.Debug(msg, keysAndValues...)
+}
+
+func (s slogLogger) Info(msg string, keysAndValues ...interface{}) {
+	s.l.Info(msg, keysAndValues...)
+}
+
+func (s slogLogger) Warn(msg string, keysAndValues ...interface{}) {
+	s.l.Warn(msg, keysAndValues...)
+}
+
+func (s slogLogger) Error(msg string, keysAndValues ...interface{}) {
+	s.l.Error(msg, keysAndValues...)
+}
+
+func (s slogLogger) With(keysAndValues ...interface{}) Logger {
+	return slogLogger{s.l.With(keysAndValues...)}
+}
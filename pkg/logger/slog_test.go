@@ -0,0 +1,26 @@
+//go:build go1.21
+// +build go1.21
+
+package logger
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSlogWritesFields(t *testing.T) {
+	var b bytes.Buffer
+	h := slog.NewTextHandler(&b, &slog.HandlerOptions{Level: slog.LevelDebug})
+	l := Slog(slog.New(h)).With("conn", 7)
+
+	l.Debug("received connection", "transport", "ws")
+
+	line := b.String()
+	for _, want := range []string{"level=DEBUG", `msg="received connection"`, "conn=7", "transport=ws"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("logged %q, expected it to contain %s", line, want)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package logger
+
+import (
+	"go.uber.org/zap"
+)
+
+type zapLogger struct {
+	sugar *zap.SugaredLogger
+}
+
+// Zap returns a Logger that writes to l.
+func Zap(l *zap.Logger) Logger {
+	return zapLogger{l.Sugar()}
+}
+
+func (z zapLogger) Debug(msg string, keysAndValues ...interface{}) {
+	z.sugar.Debugw(msg, keysAndValues...)
+}
+
+func (z zapLogger) Info(msg string, keysAndValues ...interface{}) {
+	z.sugar.Infow(msg, keysAndValues...)
+}
+
+func (z zapLogger) Warn(msg string, keysAndValues ...interface{}) {
+	z.sugar.Warnw(msg, keysAndValues...)
+}
+
+func (z zapLogger) Error(msg string, keysAndValues ...interface{}) {
+	z.sugar.Errorw(msg, keysAndValues...)
+}
+
+func (z zapLogger) With(keysAndValues ...interface{}) Logger {
+	return zapLogger{z.sugar.With(keysAndValues...)}
+}
@@ -0,0 +1,29 @@
+package logger
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestZapWritesFields(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	l := Zap(zap.New(core)).With("lobby", 3)
+
+	l.Debug("state changed", "to", "playing")
+	l.With("player", 1).Warn("removing player", "reason", "timeout")
+
+	entries := logs.AllUntimed()
+	if len(entries) != 2 {
+		t.Fatalf("logged %d entries, expected 2", len(entries))
+	}
+	if e := entries[0]; e.Level != zapcore.DebugLevel || e.Message != "state changed" {
+		t.Errorf("first entry is %v %q, expected debug \"state changed\"", e.Level, e.Message)
+	}
+	fields := entries[1].ContextMap()
+	if fields["lobby"] != int64(3) || fields["player"] != int64(1) || fields["reason"] != "timeout" {
+		t.Errorf("second entry has fields %v, expected lobby 3, player 1 and reason timeout", fields)
+	}
+}
@@ -0,0 +1,356 @@
+// Package metrics provides counters, gauges and histograms that can be
+// exposed in the Prometheus text format without any dependencies.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Registry holds metrics and writes them in the Prometheus text format.
+type Registry struct {
+	mux     sync.Mutex
+	metrics []metric
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+type metric interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+func (r *Registry) register(m metric) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	for _, other := range r.metrics {
+		if other.name() == m.name() {
+			panic("metrics: duplicate metric " + m.name())
+		}
+	}
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteTo writes every metric in the Registry to w, ordered by name.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mux.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mux.Unlock()
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].name() < metrics[j].name() })
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// Handler returns an http.Handler that serves the Registry's metrics.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteTo(w)
+	})
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+type desc struct {
+	metricName, help, kind string
+	labels                 []string
+}
+
+func (d *desc) name() string {
+	return d.metricName
+}
+
+func (d *desc) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.metricName, escapeHelp(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.metricName, d.kind)
+}
+
+func (d *desc) writeSample(w *bufio.Writer, suffix string, values []string, extra string, v float64) {
+	w.WriteString(d.metricName)
+	w.WriteString(suffix)
+	if len(d.labels) > 0 || extra != "" {
+		w.WriteByte('{')
+		for i, l := range d.labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", l, escapeLabel(values[i]))
+		}
+		if extra != "" {
+			if len(d.labels) > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(extra)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// atomicFloat is a float64 that can be updated from many goroutines.
+type atomicFloat struct {
+	bits uint64
+}
+
+func (f *atomicFloat) add(v float64) {
+	for {
+		old := atomic.LoadUint64(&f.bits)
+		next := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(&f.bits, old, next) {
+			return
+		}
+	}
+}
+
+func (f *atomicFloat) set(v float64) {
+	atomic.StoreUint64(&f.bits, math.Float64bits(v))
+}
+
+func (f *atomicFloat) load() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&f.bits))
+}
+
+// Counter is a value that only increases.
+type Counter struct {
+	desc
+	value atomicFloat
+}
+
+// NewCounter creates a Counter and adds it to r.
+func (r *Registry) NewCounter(name, help string) *Counter {
+	c := &Counter{desc: desc{metricName: name, help: help, kind: "counter"}}
+	r.register(c)
+	return c
+}
+
+// Inc adds one to the Counter.
+func (c *Counter) Inc() {
+	c.value.add(1)
+}
+
+// Add adds v, which must not be negative, to the Counter.
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		panic("metrics: counter can not decrease")
+	}
+	c.value.add(v)
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.writeHeader(w)
+	c.writeSample(w, "", nil, "", c.value.load())
+}
+
+// CounterVec is a set of Counters with the same name, split up by label values.
+type CounterVec struct {
+	desc
+	mux    sync.Mutex
+	values map[string]*atomicFloat
+	keys   map[string][]string
+}
+
+// NewCounterVec creates a CounterVec with the given label names and adds it to r.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		desc:   desc{metricName: name, help: help, kind: "counter", labels: labels},
+		values: make(map[string]*atomicFloat),
+		keys:   make(map[string][]string),
+	}
+	r.register(c)
+	return c
+}
+
+// Inc adds one to the Counter for the given label values.
+func (c *CounterVec) Inc(values ...string) {
+	c.with(values).add(1)
+}
+
+func (c *CounterVec) with(values []string) *atomicFloat {
+	if len(values) != len(c.labels) {
+		panic(fmt.Sprint("metrics: ", c.metricName, " expects ", len(c.labels), " label values"))
+	}
+	key := strings.Join(values, "\xff")
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	v, ok := c.values[key]
+	if !ok {
+		v = &atomicFloat{}
+		c.values[key] = v
+		c.keys[key] = append([]string(nil), values...)
+	}
+	return v
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.mux.Lock()
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	c.mux.Unlock()
+	sort.Strings(keys)
+
+	c.writeHeader(w)
+	for _, k := range keys {
+		c.mux.Lock()
+		v, values := c.values[k], c.keys[k]
+		c.mux.Unlock()
+		c.writeSample(w, "", values, "", v.load())
+	}
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	desc
+	value atomicFloat
+}
+
+// NewGauge creates a Gauge and adds it to r.
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	g := &Gauge{desc: desc{metricName: name, help: help, kind: "gauge"}}
+	r.register(g)
+	return g
+}
+
+// Set changes the value of the Gauge.
+func (g *Gauge) Set(v float64) {
+	g.value.set(v)
+}
+
+// Add adds v, which may be negative, to the Gauge.
+func (g *Gauge) Add(v float64) {
+	g.value.add(v)
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	g.writeHeader(w)
+	g.writeSample(w, "", nil, "", g.value.load())
+}
+
+// GaugeFunc is a gauge whose values are read by calling a function each
+// time metrics are written. The function returns a value for every set of
+// label values, keyed by the label values joined with a comma, or a single
+// value keyed by "" if the gauge has no labels.
+type GaugeFunc struct {
+	desc
+	collect func() map[string]float64
+}
+
+// NewGaugeFunc creates a GaugeFunc with the given label names and adds it to r.
+func (r *Registry) NewGaugeFunc(name, help string, collect func() map[string]float64, labels ...string) *GaugeFunc {
+	g := &GaugeFunc{desc: desc{metricName: name, help: help, kind: "gauge", labels: labels}, collect: collect}
+	r.register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	values := g.collect()
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	g.writeHeader(w)
+	for _, k := range keys {
+		var labelValues []string
+		if len(g.labels) > 0 {
+			labelValues = strings.SplitN(k, ",", len(g.labels))
+			if len(labelValues) != len(g.labels) {
+				continue
+			}
+		}
+		g.writeSample(w, "", labelValues, "", values[k])
+	}
+}
+
+// Histogram counts observations in cumulative buckets.
+type Histogram struct {
+	desc
+	bounds []float64
+	counts []uint64 // one more than bounds, for +Inf
+	sum    atomicFloat
+}
+
+// NewHistogram creates a Histogram with the given bucket upper bounds, which
+// must be in increasing order, and adds it to r.
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	if !sort.Float64sAreSorted(buckets) {
+		panic("metrics: histogram buckets must be sorted")
+	}
+	h := &Histogram{
+		desc:   desc{metricName: name, help: help, kind: "histogram"},
+		bounds: append([]float64(nil), buckets...),
+		counts: make([]uint64, len(buckets)+1),
+	}
+	r.register(h)
+	return h
+}
+
+// Observe adds v to the Histogram.
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	atomic.AddUint64(&h.counts[i], 1)
+	h.sum.add(v)
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.writeHeader(w)
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += atomic.LoadUint64(&h.counts[i])
+		h.writeSample(w, "_bucket", nil, `le="`+formatFloat(bound)+`"`, float64(cumulative))
+	}
+	cumulative += atomic.LoadUint64(&h.counts[len(h.bounds)])
+	h.writeSample(w, "_bucket", nil, `le="+Inf"`, float64(cumulative))
+	h.writeSample(w, "_sum", nil, "", h.sum.load())
+	h.writeSample(w, "_count", nil, "", float64(cumulative))
+}
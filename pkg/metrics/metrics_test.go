@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegistryTextFormat(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("test_errors_total", "Errors by type.", "type")
+	c.Inc("parse")
+	c.Inc("parse")
+	c.Inc("range")
+	h := r.NewHistogram("test_moves", "Moves per game.", []float64{5, 9})
+	h.Observe(5)
+	h.Observe(7)
+	r.NewGaugeFunc("test_queue", "Queue depth.", func() map[string]float64 {
+		return map[string]float64{"tcp": 3}
+	}, "transport")
+
+	var b strings.Builder
+	if _, err := r.WriteTo(&b); err != nil {
+		t.Fatalf("WriteTo returned error: %v", err)
+	}
+	out := b.String()
+
+	expected := []string{
+		"# TYPE test_errors_total counter\n",
+		`test_errors_total{type="parse"} 2` + "\n",
+		`test_errors_total{type="range"} 1` + "\n",
+		"# TYPE test_moves histogram\n",
+		`test_moves_bucket{le="5"} 1` + "\n",
+		`test_moves_bucket{le="9"} 2` + "\n",
+		`test_moves_bucket{le="+Inf"} 2` + "\n",
+		"test_moves_sum 12\n",
+		"test_moves_count 2\n",
+		`test_queue{transport="tcp"} 3` + "\n",
+	}
+	for _, line := range expected {
+		if !strings.Contains(out, line) {
+			t.Errorf("output is missing %q, got:\n%s", line, out)
+		}
+	}
+}
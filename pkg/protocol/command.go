@@ -7,6 +7,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/jeremyt135/tictactoe/pkg/game"
 	"github.com/jeremyt135/tictactoe/pkg/tokens"
 )
 
@@ -23,6 +24,10 @@ type Command interface {
 // message.
 const Greeting = "TICTACTOE\n"
 
+// Removed is the last message sent to a player removed from a game, before
+// the connection is closed.
+const Removed = "REMOVED\n"
+
 // InternalError is an error response that occurs when an error occurs that is not the responsibility
 // of the player to fix.
 var InternalError = errors.New("INTERNAL ERROR\n")
@@ -134,3 +139,396 @@ func (g GameOver) Op() string {
 func (g GameOver) String() string {
 	return fmt.Sprintln(g.Op(), g.WinningToken)
 }
+
+// Register is a command a client sends instead of echoing Greeting to enter
+// a tournament under the given Name.
+type Register struct {
+	Name string
+}
+
+// Op returns "REGISTER" as a Register Command's type of operation.
+func (r Register) Op() string {
+	return "REGISTER"
+}
+
+func (r Register) String() string {
+	return fmt.Sprintln(r.Op(), r.Name)
+}
+
+// maxNameLength is the longest name a client may register with.
+const maxNameLength = 32
+
+// ParseRegister attempts to parse a Register command from a given string.
+// Names must be between 1 and 32 printable characters without spaces.
+func ParseRegister(s string) (Command, error) {
+	s = strings.TrimSuffix(s, "\n")
+	reg := strings.SplitN(s, " ", 2)
+	cmd := Register{}
+	if len(reg) == 2 && reg[0] == cmd.Op() && len(reg[1]) > 0 && len(reg[1]) <= maxNameLength {
+		for _, r := range reg[1] {
+			if r <= ' ' || r > '~' {
+				return nil, &ParseError{failedStr: s}
+			}
+		}
+		cmd.Name = reg[1]
+		return cmd, nil
+	}
+	return nil, &ParseError{failedStr: s}
+}
+
+// StandingsRequest is the message a client sends instead of echoing Greeting to
+// receive the current tournament Standings.
+const StandingsRequest = "STANDINGS\n"
+
+// NameTakenError is a response indicating that a name is already registered.
+var NameTakenError = errors.New("INVALID NAME TAKEN\n")
+
+// RegistrationClosedError is a response indicating that the server is not
+// accepting tournament registrations.
+var RegistrationClosedError = errors.New("INVALID REGISTRATION CLOSED\n")
+
+// MalformedMessageError is a response to a line that is not printable UTF-8 text.
+var MalformedMessageError = errors.New("INVALID MESSAGE\n")
+
+// MessageTooLongError is sent before the server disconnects a client that
+// sent a line longer than the server allows.
+var MessageTooLongError = errors.New("INVALID MESSAGE TOO LONG\n")
+
+// Rejections are sent before the server closes a connection that exceeded a limit.
+var (
+	// ServerFullError is sent when the server has reached its maximum number of connections.
+	ServerFullError = errors.New("REJECTED SERVER FULL\n")
+
+	// TooManyConnectionsError is sent when the client's address has too many open connections.
+	TooManyConnectionsError = errors.New("REJECTED TOO MANY CONNECTIONS\n")
+
+	// HandshakeRateError is sent when the client's address connects too often.
+	HandshakeRateError = errors.New("REJECTED HANDSHAKE RATE\n")
+
+	// MessageRateError is sent when the client sends messages too quickly.
+	MessageRateError = errors.New("REJECTED MESSAGE RATE\n")
+)
+
+// Standing is one participant's line in the Standings.
+type Standing struct {
+	Rank     int
+	Name     string
+	Points   float64
+	Buchholz float64
+	// SonnebornBerger is the second tie-break score.
+	SonnebornBerger float64
+}
+
+// Op returns "RANK" as a Standing Command's type of operation.
+func (s Standing) Op() string {
+	return "RANK"
+}
+
+func (s Standing) String() string {
+	return fmt.Sprintln(s.Op(), s.Rank, s.Name, s.Points, s.Buchholz, s.SonnebornBerger)
+}
+
+// Standings is a command reporting the ranking of a tournament after a number of
+// rounds. It is followed by one Standing line per participant.
+type Standings struct {
+	Round, Rounds int
+	Entries       []Standing
+}
+
+// Op returns "STANDINGS" as a Standings Command's type of operation.
+func (s Standings) Op() string {
+	return "STANDINGS"
+}
+
+func (s Standings) String() string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintln(s.Op(), s.Round, s.Rounds, len(s.Entries)))
+	for _, e := range s.Entries {
+		b.WriteString(e.String())
+	}
+	return b.String()
+}
+
+// RoundNotif is a command sent to a tournament participant before each game,
+// naming the round and the opponent they will play.
+type RoundNotif struct {
+	Round    int
+	Opponent string
+}
+
+// Op returns "ROUND" as a RoundNotif Command's type of operation.
+func (rn RoundNotif) Op() string {
+	return "ROUND"
+}
+
+func (rn RoundNotif) String() string {
+	return fmt.Sprintln(rn.Op(), rn.Round, rn.Opponent)
+}
+
+// SeriesScore is a command sent to each player after every game of a series.
+// Wins and Losses are from the point of view of the receiving player.
+type SeriesScore struct {
+	Game, Games         int
+	Wins, Losses, Draws int
+}
+
+// Op returns "SCORE" as a SeriesScore Command's type of operation.
+func (ss SeriesScore) Op() string {
+	return "SCORE"
+}
+
+func (ss SeriesScore) String() string {
+	return fmt.Sprintln(ss.Op(), ss.Game, ss.Games, ss.Wins, ss.Losses, ss.Draws)
+}
+
+// Outcomes of a series from a player's point of view.
+const (
+	SeriesWin  = "WIN"
+	SeriesLoss = "LOSS"
+	SeriesDraw = "DRAW"
+)
+
+// SeriesOver is a command summarizing a finished series for the receiving player.
+type SeriesOver struct {
+	Outcome             string
+	Wins, Losses, Draws int
+}
+
+// Op returns "SERIES" as a SeriesOver Command's type of operation.
+func (so SeriesOver) Op() string {
+	return "SERIES"
+}
+
+func (so SeriesOver) String() string {
+	return fmt.Sprintln(so.Op(), so.Outcome, so.Wins, so.Losses, so.Draws)
+}
+
+// ServerMessage is a command carrying a message from the server operator.
+type ServerMessage struct {
+	Text string
+}
+
+// Op returns "MESSAGE" as a ServerMessage Command's type of operation.
+func (sm ServerMessage) Op() string {
+	return "MESSAGE"
+}
+
+func (sm ServerMessage) String() string {
+	return fmt.Sprintln(sm.Op(), sm.Text)
+}
+
+// ParseOp returns the type of operation of a line, which is its first word.
+func ParseOp(s string) string {
+	s = strings.TrimSuffix(s, "\n")
+	return strings.SplitN(s, " ", 2)[0]
+}
+
+// Capabilities is a command listing the optional commands a server
+// supports. It is sent after the handshake, only if the list is not empty.
+type Capabilities struct {
+	Names []string
+}
+
+// Capabilities a server can advertise.
+const (
+	// CapabilityChat means players can send Chat to their opponent at any time.
+	CapabilityChat = "CHAT"
+
+	// CapabilitySync means the server sends State at the start of each game
+	// and in answer to SyncRequest.
+	CapabilitySync = "SYNC"
+
+	// CapabilityPing means the server sends Ping at regular intervals and
+	// disconnects players that stop answering with Pong.
+	CapabilityPing = "PING"
+)
+
+// Op returns "CAPABILITIES" as a Capabilities Command's type of operation.
+func (c Capabilities) Op() string {
+	return "CAPABILITIES"
+}
+
+func (c Capabilities) String() string {
+	return fmt.Sprintln(c.Op(), strings.Join(c.Names, " "))
+}
+
+// Chat is a command a player sends to talk to the other players in their
+// game. It can be sent at any time, not only on the player's turn.
+type Chat struct {
+	Text string
+}
+
+// Op returns "CHAT" as a Chat Command's type of operation.
+func (c Chat) Op() string {
+	return "CHAT"
+}
+
+func (c Chat) String() string {
+	return fmt.Sprintln(c.Op(), c.Text)
+}
+
+// ParseChat attempts to parse a Chat command from a given string. The text
+// must not be empty.
+func ParseChat(s string) (Command, error) {
+	s = strings.TrimSuffix(s, "\n")
+	chat := strings.SplitN(s, " ", 2)
+	cmd := Chat{}
+	if len(chat) == 2 && chat[0] == cmd.Op() && strings.TrimSpace(chat[1]) != "" {
+		cmd.Text = chat[1]
+		return cmd, nil
+	}
+	return nil, &ParseError{failedStr: s}
+}
+
+// ChatNotif is a command relaying a Chat to the other players in a game.
+// From is the token of the player that sent it.
+type ChatNotif struct {
+	From string
+	Text string
+}
+
+// Op returns "CHAT" as a ChatNotif Command's type of operation.
+func (cn ChatNotif) Op() string {
+	return "CHAT"
+}
+
+func (cn ChatNotif) String() string {
+	return fmt.Sprintln(cn.Op(), cn.From, cn.Text)
+}
+
+// Responses to a Chat that was not relayed.
+var (
+	// ChatTooLongError is sent when the text of a Chat is longer than the server allows.
+	ChatTooLongError = errors.New("INVALID CHAT TOO LONG\n")
+
+	// ChatRateError is sent when a player chats more often than the server allows.
+	ChatRateError = errors.New("INVALID CHAT RATE\n")
+)
+
+// SyncRequest is the message a player sends during a game to be sent the
+// current State of the board.
+const SyncRequest = "SYNC\n"
+
+// Results reported in a State, besides the winning token.
+const (
+	ResultPlaying = "PLAYING"
+	ResultDraw    = "DRAW"
+)
+
+// State is a command carrying the whole state of a game, so a client can
+// check or rebuild the board it keeps from TURN messages. States can be
+// compared with ==, so a client keeping a game.Board can check it against
+// a State it received with NewState(board) == received.
+type State struct {
+	// Board holds the token in every cell, or tokens.Empty.
+	Board [3][3]string
+
+	// ToMove is the token that moves next, or tokens.Empty if the game is over.
+	ToMove string
+
+	// Moves is the number of moves made so far.
+	Moves int
+
+	// Result is ResultPlaying, ResultDraw or the winning token.
+	Result string
+}
+
+// NewState returns the State of board.
+func NewState(board *game.Board) State {
+	s := State{Board: board.Grid(), ToMove: board.Turn(), Moves: board.Moves(), Result: ResultPlaying}
+	switch {
+	case board.HasWinner():
+		s.Result = board.WinningToken()
+	case board.IsFull():
+		s.Result = ResultDraw
+	}
+	return s
+}
+
+// Op returns "STATE" as a State Command's type of operation.
+func (s State) Op() string {
+	return "STATE"
+}
+
+func (s State) String() string {
+	var rows [3]string
+	for i, row := range s.Board {
+		rows[i] = strings.Join(row[:], "")
+	}
+	return fmt.Sprintln(s.Op(), rows[0], rows[1], rows[2], s.ToMove, s.Moves, s.Result)
+}
+
+// ParseState attempts to parse a State from a given string.
+func ParseState(s string) (Command, error) {
+	s = strings.TrimSuffix(s, "\n")
+	fields := strings.Split(s, " ")
+	cmd := State{}
+	if len(fields) != 7 || fields[0] != cmd.Op() {
+		return nil, &ParseError{failedStr: s}
+	}
+	for i, row := range fields[1:4] {
+		if len(row) != 3 {
+			return nil, &ParseError{failedStr: s}
+		}
+		for j := range row {
+			cell := row[j : j+1]
+			if cell != tokens.X && cell != tokens.O && cell != tokens.Empty {
+				return nil, &ParseError{failedStr: s}
+			}
+			cmd.Board[i][j] = cell
+		}
+	}
+	moves, err := strconv.Atoi(fields[5])
+	validToMove := fields[4] == tokens.X || fields[4] == tokens.O || fields[4] == tokens.Empty
+	validResult := fields[6] == ResultPlaying || fields[6] == ResultDraw || fields[6] == tokens.X || fields[6] == tokens.O
+	if err != nil || moves < 0 || moves > 9 || !validToMove || !validResult {
+		return nil, &ParseError{failedStr: s}
+	}
+	cmd.ToMove, cmd.Moves, cmd.Result = fields[4], moves, fields[6]
+	return cmd, nil
+}
+
+// Ping is a heartbeat the server sends a player, who must answer with a
+// Pong carrying the same Seq.
+type Ping struct {
+	Seq int
+}
+
+// Op returns "PING" as a Ping Command's type of operation.
+func (p Ping) Op() string {
+	return "PING"
+}
+
+func (p Ping) String() string {
+	return fmt.Sprintln(p.Op(), p.Seq)
+}
+
+// Pong is a command a player sends to answer a Ping.
+type Pong struct {
+	Seq int
+}
+
+// Op returns "PONG" as a Pong Command's type of operation.
+func (p Pong) Op() string {
+	return "PONG"
+}
+
+func (p Pong) String() string {
+	return fmt.Sprintln(p.Op(), p.Seq)
+}
+
+// ParsePong attempts to parse a Pong command from a given string.
+func ParsePong(s string) (Command, error) {
+	s = strings.TrimSuffix(s, "\n")
+	pong := strings.Split(s, " ")
+	cmd := Pong{}
+	if len(pong) != 2 || pong[0] != cmd.Op() {
+		return nil, &ParseError{failedStr: s}
+	}
+	seq, err := strconv.Atoi(pong[1])
+	if err != nil || seq < 0 {
+		return nil, &ParseError{failedStr: s}
+	}
+	cmd.Seq = seq
+	return cmd, nil
+}
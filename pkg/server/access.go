@@ -0,0 +1,214 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// AccessList decides which IP addresses may connect, using CIDR ranges.
+// Addresses in a denied range are always rejected. If any range is allowed,
+// addresses outside the allowed ranges are rejected too. Connections without
+// an IP address, such as Unix sockets, are always accepted.
+//
+// An AccessList is safe for concurrent use and can be changed while
+// listeners are using it.
+type AccessList struct {
+	mux   sync.RWMutex
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// NewAccessList returns an empty AccessList, which accepts every address.
+func NewAccessList() *AccessList {
+	return &AccessList{}
+}
+
+// LoadAccessList reads an AccessList from a file. Each line is "allow" or
+// "deny" followed by a CIDR range or a single IP address. Blank lines and
+// lines starting with "#" are ignored.
+func LoadAccessList(path string) (*AccessList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read access list: %w", err)
+	}
+	defer f.Close()
+
+	a := NewAccessList()
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"allow <cidr>\" or \"deny <cidr>\"", path, n)
+		}
+		switch strings.ToLower(fields[0]) {
+		case "allow":
+			err = a.Allow(fields[1])
+		case "deny":
+			err = a.Deny(fields[1])
+		default:
+			err = fmt.Errorf("unknown rule %q", fields[0])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read access list: %w", err)
+	}
+	return a, nil
+}
+
+// parseRange parses a CIDR range or a single IP address.
+func parseRange(cidr string) (*net.IPNet, error) {
+	if !strings.Contains(cidr, "/") {
+		ip := net.ParseIP(cidr)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid address %q", cidr)
+		}
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid range %q", cidr)
+	}
+	return ipNet, nil
+}
+
+func addRange(ranges []*net.IPNet, r *net.IPNet) []*net.IPNet {
+	for _, existing := range ranges {
+		if existing.String() == r.String() {
+			return ranges
+		}
+	}
+	return append(ranges, r)
+}
+
+func removeRange(ranges []*net.IPNet, r *net.IPNet) ([]*net.IPNet, bool) {
+	for i, existing := range ranges {
+		if existing.String() == r.String() {
+			return append(ranges[:i:i], ranges[i+1:]...), true
+		}
+	}
+	return ranges, false
+}
+
+// Allow adds a range to the allow list.
+func (a *AccessList) Allow(cidr string) error {
+	r, err := parseRange(cidr)
+	if err != nil {
+		return err
+	}
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	a.allow = addRange(a.allow, r)
+	return nil
+}
+
+// Deny adds a range to the deny list.
+func (a *AccessList) Deny(cidr string) error {
+	r, err := parseRange(cidr)
+	if err != nil {
+		return err
+	}
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	a.deny = addRange(a.deny, r)
+	return nil
+}
+
+// Remove removes a range from both lists, returning false if it was in neither.
+func (a *AccessList) Remove(cidr string) (bool, error) {
+	r, err := parseRange(cidr)
+	if err != nil {
+		return false, err
+	}
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	var allowed, denied bool
+	a.allow, allowed = removeRange(a.allow, r)
+	a.deny, denied = removeRange(a.deny, r)
+	return allowed || denied, nil
+}
+
+// Replace makes a a copy of other, for example after loading the list again.
+func (a *AccessList) Replace(other *AccessList) {
+	other.mux.RLock()
+	allow := append([]*net.IPNet(nil), other.allow...)
+	deny := append([]*net.IPNet(nil), other.deny...)
+	other.mux.RUnlock()
+
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	a.allow, a.deny = allow, deny
+}
+
+// Rules returns the list as lines in the format read by LoadAccessList.
+func (a *AccessList) Rules() []string {
+	a.mux.RLock()
+	defer a.mux.RUnlock()
+	var rules []string
+	for _, r := range a.allow {
+		rules = append(rules, "allow "+r.String())
+	}
+	for _, r := range a.deny {
+		rules = append(rules, "deny "+r.String())
+	}
+	return rules
+}
+
+// Check returns nil if ip may connect, or an error giving the reason it may not.
+func (a *AccessList) Check(ip net.IP) error {
+	if a == nil || ip == nil {
+		return nil
+	}
+	a.mux.RLock()
+	defer a.mux.RUnlock()
+
+	for _, r := range a.deny {
+		if r.Contains(ip) {
+			return fmt.Errorf("%s is denied by %s", ip, r)
+		}
+	}
+	if len(a.allow) == 0 {
+		return nil
+	}
+	for _, r := range a.allow {
+		if r.Contains(ip) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not in an allowed range", ip)
+}
+
+// checkAddr is Check for a remote address of a connection.
+func (a *AccessList) checkAddr(addr net.Addr) error {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return a.Check(addr.IP)
+	case nil:
+		return nil
+	default:
+		return a.checkHostPort(addr.String())
+	}
+}
+
+// checkHostPort is Check for a "host:port" address. Addresses without an IP are accepted.
+func (a *AccessList) checkHostPort(hostport string) error {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return nil
+	}
+	return a.Check(net.ParseIP(host))
+}
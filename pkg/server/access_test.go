@@ -0,0 +1,68 @@
+package server
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+)
+
+func TestAccessListCheck(t *testing.T) {
+	f, err := ioutil.TempFile("", "tictactoe-access")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("# internal tournament\nallow 10.0.0.0/8\nallow 2001:db8::/32\ndeny 10.0.0.13\n")
+	f.Close()
+
+	a, err := LoadAccessList(f.Name())
+	if err != nil {
+		t.Fatalf("LoadAccessList returned error: %v", err)
+	}
+
+	cases := map[string]bool{
+		"10.1.2.3":    true,
+		"10.0.0.13":   false,
+		"192.0.2.1":   false,
+		"2001:db8::1": true,
+	}
+	for ip, allowed := range cases {
+		if err := a.Check(net.ParseIP(ip)); (err == nil) != allowed {
+			t.Errorf("Check(%s) returned %v, expected allowed to be %v", ip, err, allowed)
+		}
+	}
+
+	if _, err := a.Remove("10.0.0.13"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Check(net.ParseIP("10.0.0.13")); err != nil {
+		t.Errorf("Check returned %v after the deny rule was removed", err)
+	}
+}
+
+func TestAdminDenyKicksConnections(t *testing.T) {
+	opt := DefaultOptions()
+	opt.AccessList = NewAccessList()
+	s, _ := NewServer(opt)
+
+	c := newAddrConn("192.0.2.1")
+	s.admitConn(c)
+
+	if _, err := s.runAdminCommand("DENY", "192.0.2.0/24"); err != nil {
+		t.Fatalf("DENY returned error: %v", err)
+	}
+	select {
+	case <-c.Done():
+		if err := c.Err(); err == nil || err.(*DisconnectError).Reason != ReasonAccessDenied {
+			t.Errorf("kicked connection has error %v, expected reason %q", err, ReasonAccessDenied)
+		}
+	default:
+		t.Errorf("connection from a denied range was not kicked")
+	}
+
+	out, _ := s.runAdminCommand("ACCESS", "")
+	if out != "DENY 192.0.2.0/24\n" {
+		t.Errorf("ACCESS listed %q", out)
+	}
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"net"
+	"os"
+	"strings"
+)
+
+// listenAddress creates a stream listener for an address accepted by ListenTcp and ListenWS:
+//
+//	":42000"             every interface, IPv4 and IPv6 (dual-stack)
+//	"127.0.0.1:42000"    a single IPv4 address
+//	"[::1]:42000"        a single IPv6 address
+//	"tcp4::42000"        every IPv4 interface only ("tcp6:" for IPv6 only)
+//	"unix:/run/ttt.sock" a Unix domain socket
+func listenAddress(address string) (net.Listener, error) {
+	network, addr := splitAddress(address)
+	if network == "unix" {
+		removeStaleSocket(addr)
+	}
+	return net.Listen(network, addr)
+}
+
+// splitAddress separates an optional "unix:", "tcp4:" or "tcp6:" network prefix from address.
+func splitAddress(address string) (network, addr string) {
+	for _, network := range []string{"unix", "tcp4", "tcp6"} {
+		if addr := strings.TrimPrefix(address, network+":"); addr != address {
+			return network, addr
+		}
+	}
+	return "tcp", address
+}
+
+// removeStaleSocket removes a Unix socket left behind by a process that
+// exited without closing its listener. Other kinds of files are left alone.
+func removeStaleSocket(path string) {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		// Someone is still listening, let net.Listen report the error
+		conn.Close()
+		return
+	}
+	os.Remove(path)
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jeremyt135/tictactoe/pkg/logger"
+)
+
+func TestSplitAddress(t *testing.T) {
+	cases := []struct {
+		address, network, addr string
+	}{
+		{":42000", "tcp", ":42000"},
+		{"[::1]:42000", "tcp", "[::1]:42000"},
+		{"tcp4::42000", "tcp4", ":42000"},
+		{"tcp6:[::]:42000", "tcp6", "[::]:42000"},
+		{"unix:/run/tictactoe.sock", "unix", "/run/tictactoe.sock"},
+	}
+	for _, c := range cases {
+		network, addr := splitAddress(c.address)
+		if network != c.network || addr != c.addr {
+			t.Errorf("splitAddress(%q) = %q, %q, expected %q, %q", c.address, network, addr, c.network, c.addr)
+		}
+	}
+}
+
+func TestListenTcpUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tictactoe-unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tictactoe.sock")
+
+	l, err := ListenTcp("unix:"+path, logger.NoOpLogger())
+	if err != nil {
+		t.Fatalf("ListenTcp returned error: %v", err)
+	}
+	go l.PollAccept()
+	defer l.Close()
+
+	client, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("could not connect to Unix socket: %v", err)
+	}
+	defer client.Close()
+
+	conn := <-l.Connections()
+	conn.Send() <- "HELLO\n"
+	line, err := bufio.NewReader(client).ReadString('\n')
+	if err != nil || line != "HELLO\n" {
+		t.Errorf("client read %q (err %v), expected %q", line, err, "HELLO\n")
+	}
+}
@@ -0,0 +1,337 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jeremyt135/tictactoe/pkg/protocol"
+	"github.com/jeremyt135/tictactoe/pkg/server/internal/lobby"
+)
+
+// ListenAdmin creates a listener for the admin console. Address is either
+// a loopback "host:port" pair or "unix:" followed by the path of a Unix socket.
+// Other TCP addresses are rejected so the console is never exposed publicly.
+func ListenAdmin(address string) (net.Listener, error) {
+	if network, _ := splitAddress(address); network == "unix" {
+		l, err := listenAddress(address)
+		if err != nil {
+			return nil, fmt.Errorf("could not create admin listener: %w", err)
+		}
+		return l, nil
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid admin address: %w", err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("admin address %s is not a loopback address", address)
+	}
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("could not create admin listener: %w", err)
+	}
+	return l, nil
+}
+
+// ServeAdmin accepts admin console connections from l until it is closed.
+//
+// The console reads one command per line and answers with zero or more lines
+// followed by "OK" or "ERROR <reason>". Send "HELP" for the list of commands.
+func (s *Server) ServeAdmin(l net.Listener) error {
+	s.logger.Info("admin console listening", "address", l.Addr().String())
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Temporary() {
+				s.logger.Error("admin accept error (recovered)", "error", err)
+				continue
+			}
+			return fmt.Errorf("admin accept error (unrecoverable): %w", err)
+		}
+		go s.handleAdmin(conn)
+	}
+}
+
+const adminHelp = `LOBBIES               list lobbies with their state and board
+CONNS                 list connections
+KICK <conn>           disconnect a connection
+END <lobby>           end the game in a lobby
+BROADCAST <message>   send a message to every player in every lobby
+POOL <n>              change the number of lobbies
+RELOAD                reload the configuration
+ACCESS                list the allow and deny rules
+ALLOW <cidr>          accept connections from a range
+DENY <cidr>           reject connections from a range and kick its connections
+UNLIST <cidr>         remove a range from the allow and deny rules
+HELP                  show this help
+`
+
+func (s *Server) handleAdmin(conn net.Conn) {
+	defer conn.Close()
+	s.logger.Info("admin console connected", "remote", conn.RemoteAddr().String())
+
+	scanner := bufio.NewScanner(conn)
+	w := bufio.NewWriter(conn)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		cmd, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			cmd, arg = line[:i], strings.TrimSpace(line[i+1:])
+		}
+
+		s.logger.Info("admin command", "command", line)
+		out, err := s.runAdminCommand(strings.ToUpper(cmd), arg)
+		w.WriteString(out)
+		if err != nil {
+			fmt.Fprintln(w, "ERROR", err)
+		} else {
+			fmt.Fprintln(w, "OK")
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) runAdminCommand(cmd, arg string) (string, error) {
+	switch cmd {
+	case "HELP":
+		return adminHelp, nil
+	case "LOBBIES":
+		var b strings.Builder
+		for _, l := range s.allLobbies() {
+			b.WriteString(formatSnapshot(l.Snapshot()))
+		}
+		return b.String(), nil
+	case "CONNS":
+		var b strings.Builder
+		for _, c := range s.connections() {
+			identity := c.identity
+			if identity == "" {
+				identity = "-"
+			}
+			fmt.Fprintln(&b, "CONN", c.id, c.transport, c.remoteAddr, c.state, c.lobbyID, c.age, identity)
+		}
+		return b.String(), nil
+	case "KICK":
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			return "", errors.New("usage: KICK <conn>")
+		}
+		return "", s.kick(id, ReasonKicked)
+	case "END":
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			return "", errors.New("usage: END <lobby>")
+		}
+		l := s.findLobby(id)
+		if l == nil {
+			return "", fmt.Errorf("no lobby %d", id)
+		}
+		l.End("lobby ended by server")
+		return "", nil
+	case "BROADCAST":
+		if arg == "" {
+			return "", errors.New("usage: BROADCAST <message>")
+		}
+		msg := protocol.ServerMessage{Text: arg}
+		for _, l := range s.allLobbies() {
+			l.Broadcast(msg.String())
+		}
+		return "", nil
+	case "POOL":
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			return "", errors.New("usage: POOL <n>")
+		}
+		if err := s.SetNumLobbies(n); err != nil {
+			return "", err
+		}
+		return fmt.Sprintln("POOL", s.NumLobbies()), nil
+	case "RELOAD":
+		return s.runReload()
+	case "ACCESS", "ALLOW", "DENY", "UNLIST":
+		return s.runAccessCommand(cmd, arg)
+	default:
+		return "", fmt.Errorf("unknown command %q, try HELP", cmd)
+	}
+}
+
+func formatSnapshot(snap lobby.Snapshot) string {
+	rows := make([]string, len(snap.Board))
+	for i, row := range snap.Board {
+		rows[i] = strings.Join(row[:], "")
+	}
+	players := make([]string, len(snap.Players))
+	for i, p := range snap.Players {
+		players[i] = fmt.Sprint(p.Token, ":", p.ConnID)
+	}
+	return fmt.Sprintf("LOBBY %d state=%s playing=%t full=%t board=%s players=%s\n",
+		snap.ID, snap.State, snap.Playing, snap.Full, strings.Join(rows, "/"), strings.Join(players, ","))
+}
+
+// connStatus describes a tracked Conn at one point in time.
+type connStatus struct {
+	id         int
+	transport  string
+	remoteAddr string
+	state      string
+	lobbyID    int
+	age        time.Duration
+	identity   string
+}
+
+// connections returns the status of every tracked Conn, ordered by ID.
+func (s *Server) connections() []connStatus {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	conns := make([]connStatus, 0, len(s.conns))
+	for _, info := range s.conns {
+		status := connStatus{
+			id:         info.id,
+			transport:  info.conn.Transport(),
+			remoteAddr: remoteAddr(info.conn),
+			state:      info.state,
+			lobbyID:    info.lobbyID,
+			age:        s.clock.Now().Sub(info.accepted).Truncate(time.Second),
+			identity:   info.identity,
+		}
+		conns = append(conns, status)
+	}
+	sort.Slice(conns, func(i, j int) bool { return conns[i].id < conns[j].id })
+	return conns
+}
+
+// kick disconnects the Conn with the given ID, giving reason as the cause.
+func (s *Server) kick(id int, reason string) error {
+	s.mux.Lock()
+	info, ok := s.conns[id]
+	s.mux.Unlock()
+	if !ok {
+		return fmt.Errorf("no connection %d", id)
+	}
+	info.logger.Info("kicking connection", "reason", reason)
+	return info.conn.Close(reason)
+}
+
+func (s *Server) runAccessCommand(cmd, arg string) (string, error) {
+	if s.access == nil {
+		return "", errors.New("no access list is configured")
+	}
+	if cmd != "ACCESS" && arg == "" {
+		return "", fmt.Errorf("usage: %s <cidr>", cmd)
+	}
+
+	switch cmd {
+	case "ACCESS":
+		var b strings.Builder
+		for _, rule := range s.access.Rules() {
+			fmt.Fprintln(&b, strings.ToUpper(rule))
+		}
+		return b.String(), nil
+	case "ALLOW":
+		return "", s.access.Allow(arg)
+	case "DENY":
+		if err := s.access.Deny(arg); err != nil {
+			return "", err
+		}
+		s.kickRejected()
+		return "", nil
+	default:
+		removed, err := s.access.Remove(arg)
+		if err == nil && !removed {
+			err = fmt.Errorf("%s is not in the access list", arg)
+		}
+		return "", err
+	}
+}
+
+// kickRejected disconnects connections from addresses the access list now rejects.
+func (s *Server) kickRejected() {
+	s.mux.Lock()
+	var rejected []int
+	for id, info := range s.conns {
+		if err := s.access.Check(net.ParseIP(info.ip)); err != nil {
+			info.logger.Info("connection rejected by access list", "error", err)
+			rejected = append(rejected, id)
+		}
+	}
+	s.mux.Unlock()
+
+	for _, id := range rejected {
+		s.kick(id, ReasonAccessDenied)
+	}
+}
+
+// allLobbies returns the lobbies in the pool followed by any tournament lobbies.
+func (s *Server) allLobbies() []*lobby.Lobby {
+	s.mux.Lock()
+	lobbies := append([]*lobby.Lobby(nil), s.lobbies...)
+	s.mux.Unlock()
+
+	if s.tournament != nil {
+		lobbies = append(lobbies, s.tournament.lobbies()...)
+	}
+	return lobbies
+}
+
+func (s *Server) findLobby(id int) *lobby.Lobby {
+	for _, l := range s.allLobbies() {
+		if l.ID() == id {
+			return l
+		}
+	}
+	return nil
+}
+
+// NumLobbies returns the number of lobbies in the pool.
+func (s *Server) NumLobbies() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return len(s.lobbies)
+}
+
+// SetNumLobbies grows or shrinks the lobby pool to n lobbies. Lobbies with a
+// game in progress finish their game before being discarded, while lobbies
+// with a player waiting for an opponent are kept, so the pool may remain
+// larger than n.
+func (s *Server) SetNumLobbies(n int) error {
+	if n <= 0 {
+		return errors.New("lobbies must be positive")
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	for len(s.lobbies) < n {
+		s.lobbies = append(s.lobbies, s.newLobby())
+	}
+
+	kept := s.lobbies[:0]
+	excess := len(s.lobbies) - n
+	for _, l := range s.lobbies {
+		snap := l.Snapshot()
+		if excess > 0 && (snap.Playing || len(snap.Players) == 0) {
+			// A game in progress is played to the end
+			l.Close()
+			excess--
+			continue
+		}
+		kept = append(kept, l)
+	}
+	s.lobbies = kept
+	s.logger.Info("lobby pool resized", "lobbies", len(s.lobbies))
+	return nil
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestListenAdminRejectsPublicAddress(t *testing.T) {
+	if l, err := ListenAdmin("0.0.0.0:0"); err == nil {
+		l.Close()
+		t.Errorf("ListenAdmin accepted a public address, expected an error")
+	}
+
+	l, err := ListenAdmin("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenAdmin returned error for a loopback address: %v", err)
+	}
+	l.Close()
+}
+
+func TestAdminPoolResize(t *testing.T) {
+	s, _ := NewServer(nil)
+
+	if _, err := s.runAdminCommand("POOL", "5"); err != nil {
+		t.Fatalf("POOL 5 returned error: %v", err)
+	}
+	out, _ := s.runAdminCommand("LOBBIES", "")
+	if n := strings.Count(out, "LOBBY "); n != 5 {
+		t.Errorf("LOBBIES listed %d lobbies after POOL 5, expected 5", n)
+	}
+
+	// Idle lobbies can be discarded right away
+	s.runAdminCommand("POOL", "1")
+	if n := s.NumLobbies(); n != 1 {
+		t.Errorf("server had %d lobbies after POOL 1, expected 1", n)
+	}
+
+	if _, err := s.runAdminCommand("POOL", "0"); err == nil {
+		t.Errorf("POOL 0 succeeded, expected an error")
+	}
+}
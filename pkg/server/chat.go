@@ -0,0 +1,142 @@
+package server
+
+import (
+	"errors"
+	"unicode/utf8"
+
+	"github.com/jeremyt135/tictactoe/pkg/protocol"
+	"github.com/jeremyt135/tictactoe/pkg/server/internal/player"
+)
+
+// Defaults for ChatOptions.
+const (
+	DefaultChatMaxLength = 200
+	DefaultChatPerSecond = 1
+	DefaultChatBurst     = 5
+)
+
+// chatQueueSize is the number of checked CHAT and SYNC lines that can wait for the lobby.
+const chatQueueSize = 4
+
+// ChatOptions let players send CHAT lines to their opponent, which are
+// relayed at any time during a game, including while it isn't the sender's
+// turn. Chat is advertised to clients as a capability after the handshake.
+type ChatOptions struct {
+	// MaxLength is the longest text, in characters, that is relayed. If
+	// zero, DefaultChatMaxLength is used.
+	MaxLength int
+
+	// MessagesPerSecond is the rate at which a player can chat, allowing
+	// bursts of up to Burst messages. Chat beyond it is answered with
+	// protocol.ChatRateError instead of being relayed. If zero,
+	// DefaultChatPerSecond and DefaultChatBurst are used.
+	MessagesPerSecond float64
+	Burst             int
+}
+
+func validateChatOptions(opt *ChatOptions) error {
+	if opt.MaxLength < 0 || opt.MessagesPerSecond < 0 || opt.Burst < 0 {
+		return errors.New("chat length and rate must not be negative")
+	}
+	return nil
+}
+
+// withDefaults returns a copy of opt with zero values replaced by the defaults.
+func (opt ChatOptions) withDefaults() ChatOptions {
+	if opt.MaxLength == 0 {
+		opt.MaxLength = DefaultChatMaxLength
+	}
+	if opt.MessagesPerSecond == 0 {
+		opt.MessagesPerSecond = DefaultChatPerSecond
+		if opt.Burst == 0 {
+			opt.Burst = DefaultChatBurst
+		}
+	}
+	return opt
+}
+
+// capabilities returns the optional commands the Server advertises after the handshake.
+func (s *Server) capabilities() []string {
+	var names []string
+	current := s.settings.get()
+	if current.chat != nil {
+		names = append(names, protocol.CapabilityChat)
+	}
+	if current.rules.SendState {
+		names = append(names, protocol.CapabilitySync)
+	}
+	if current.heartbeat != nil {
+		names = append(names, protocol.CapabilityPing)
+	}
+	return names
+}
+
+// advertise sends p the Server's capabilities, if it has any.
+func (s *Server) advertise(p *player.Player) {
+	if names := s.capabilities(); len(names) > 0 {
+		p.Deliver(protocol.Capabilities{Names: names}.String())
+	}
+}
+
+// splitRequests moves the CHAT lines, if chat is non-nil, and the SYNC
+// lines, if sync is set, from receive to a channel of their own. CHAT
+// lines have their format, length and rate checked on the way. The lines
+// left are returned as the player's moves. Both channels are closed when
+// receive is.
+func (s *Server) splitRequests(info *connInfo, c Conn, receive <-chan string, chat *ChatOptions, sync bool) (<-chan string, <-chan player.Request) {
+	moves := make(chan string)
+	requests := make(chan player.Request, chatQueueSize)
+
+	go func() {
+		defer close(moves)
+		defer close(requests)
+		var bucket *tokenBucket
+		if chat != nil {
+			bucket = newTokenBucket(chat.MessagesPerSecond, chat.Burst, s.clock.Now())
+		}
+		for line := range receive {
+			var req player.Request
+			switch op := protocol.ParseOp(line); {
+			case chat != nil && op == (protocol.Chat{}).Op():
+				req = s.checkChat(info, line, chat, bucket)
+			case sync && op == protocol.ParseOp(protocol.SyncRequest):
+				req = player.Request{Line: line}
+			default:
+				select {
+				case moves <- line:
+				case <-c.Done():
+					drain(receive)
+					return
+				}
+				continue
+			}
+			select {
+			case requests <- req:
+			case <-c.Done():
+				drain(receive)
+				return
+			}
+		}
+	}()
+	return moves, requests
+}
+
+// checkChat returns the request for a CHAT line, with an error if the line
+// is malformed, too long or over the chat rate.
+func (s *Server) checkChat(info *connInfo, line string, opt *ChatOptions, bucket *tokenBucket) player.Request {
+	req := player.Request{Line: line}
+	cmd, err := protocol.ParseChat(line)
+	var parseError *protocol.ParseError
+	switch {
+	case errors.As(err, &parseError):
+		req.Err = errors.New(parseError.AsResponse())
+	case utf8.RuneCountInString(cmd.(protocol.Chat).Text) > opt.MaxLength:
+		req.Err = protocol.ChatTooLongError
+	case !bucket.allow(s.clock.Now()):
+		info.logger.Debug("exceeded the chat rate")
+		req.Err = protocol.ChatRateError
+	default:
+		req.Text = cmd.(protocol.Chat).Text
+	}
+	return req
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jeremyt135/tictactoe/pkg/clock"
+	"github.com/jeremyt135/tictactoe/pkg/protocol"
+	"github.com/jeremyt135/tictactoe/pkg/tokens"
+)
+
+// expectMessage reads from c until it gets want, failing the test if c sends
+// anything else or nothing within 5s.
+func expectMessage(t *testing.T, c *PipeClient, want string) {
+	t.Helper()
+	select {
+	case msg := <-c.Receive():
+		if msg != want {
+			t.Fatalf("client received %q, expected %q", msg, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("client did not receive %q within 5s", want)
+	}
+}
+
+func TestChatRelayedOutOfTurn(t *testing.T) {
+	s, _ := NewServer(&Options{
+		NumLobbies: 1,
+		Clock:      clock.NewFake(time.Now()),
+		Chat:       &ChatOptions{MaxLength: 10, MessagesPerSecond: 1, Burst: 1},
+	})
+	defer s.Close()
+	l := NewPipeListener()
+	defer l.Close()
+	go s.Serve(l)
+
+	caps := protocol.Capabilities{Names: []string{protocol.CapabilityChat}}.String()
+	x := echoGreeting(t, l)
+	expectMessage(t, x, caps)
+	o := echoGreeting(t, l)
+	expectMessage(t, o, caps)
+	expectMessage(t, x, protocol.PlayerToken{Token: tokens.X}.String())
+	expectMessage(t, o, protocol.PlayerToken{Token: tokens.O}.String())
+	expectMessage(t, x, protocol.TurnNotif{Token: tokens.X}.String())
+
+	// O chats while X is to move
+	o.Write(protocol.Chat{Text: "hi there"}.String())
+	expectMessage(t, x, protocol.ChatNotif{From: tokens.O, Text: "hi there"}.String())
+	o.Write(protocol.Chat{Text: "again"}.String())
+	expectMessage(t, o, protocol.ChatRateError.Error())
+	x.Write(protocol.Chat{Text: "far too long to relay"}.String())
+	expectMessage(t, x, protocol.ChatTooLongError.Error())
+
+	// Chat doesn't use up X's turn
+	move := protocol.TurnInfo{Token: tokens.X, Row: 1, Col: 1}.String()
+	x.Write(move)
+	expectMessage(t, o, move)
+	expectMessage(t, o, protocol.TurnNotif{Token: tokens.O}.String())
+}
+
+func TestChatAnsweredBeforeGameStarts(t *testing.T) {
+	s, _ := NewServer(&Options{
+		NumLobbies: 1,
+		Clock:      clock.NewFake(time.Now()),
+		Chat:       &ChatOptions{MaxLength: 10, MessagesPerSecond: 1, Burst: 1},
+	})
+	defer s.Close()
+	l := NewPipeListener()
+	defer l.Close()
+	go s.Serve(l)
+
+	// X chats while waiting for an opponent
+	caps := protocol.Capabilities{Names: []string{protocol.CapabilityChat}}.String()
+	x := echoGreeting(t, l)
+	expectMessage(t, x, caps)
+	x.Write(protocol.Chat{Text: "far too long to relay"}.String())
+	expectMessage(t, x, protocol.ChatTooLongError.Error())
+
+	o := echoGreeting(t, l)
+	expectMessage(t, o, caps)
+	expectMessage(t, x, protocol.PlayerToken{Token: tokens.X}.String())
+	expectMessage(t, o, protocol.PlayerToken{Token: tokens.O}.String())
+	expectMessage(t, x, protocol.TurnNotif{Token: tokens.X}.String())
+}
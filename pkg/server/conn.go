@@ -0,0 +1,83 @@
+package server
+
+import (
+	"sync"
+)
+
+// Reasons given for closing a Conn.
+const (
+	ReasonClientClosed = "client closed the connection"
+	ReasonServerClosed = "closed by server"
+	ReasonKicked       = "kicked by admin"
+	ReasonAccessDenied = "address denied by access list"
+	ReasonSlowConsumer = "connection too slow"
+	ReasonLineTooLong  = "message too long"
+	ReasonReadError    = "read error"
+	ReasonReadTimeout  = "read timed out"
+	ReasonWriteError   = "write error"
+	ReasonNoHeartbeat  = "missed heartbeats"
+)
+
+// DisconnectError describes why a Conn was closed. It is returned by Conn.Err.
+type DisconnectError struct {
+	// Reason is a short description such as ReasonClientClosed.
+	Reason string
+
+	// Err is the error that caused the disconnect, if any.
+	Err error
+}
+
+func (e *DisconnectError) Error() string {
+	if e.Err != nil {
+		return "disconnected: " + e.Reason + ": " + e.Err.Error()
+	}
+	return "disconnected: " + e.Reason
+}
+
+func (e *DisconnectError) Unwrap() error {
+	return e.Err
+}
+
+// closer implements Close, Done and Err for a Conn.
+type closer struct {
+	once    sync.Once
+	done    chan struct{}
+	err     *DisconnectError // set before done is closed
+	onClose func() error     // closes the underlying connection, may be nil
+}
+
+func newCloser(onClose func() error) *closer {
+	return &closer{done: make(chan struct{}), onClose: onClose}
+}
+
+// Close closes the Conn, giving reason as the cause. Only the first call has any effect.
+func (c *closer) Close(reason string) error {
+	return c.closeWith(&DisconnectError{Reason: reason})
+}
+
+func (c *closer) closeWith(err *DisconnectError) error {
+	var closeErr error
+	c.once.Do(func() {
+		c.err = err
+		close(c.done)
+		if c.onClose != nil {
+			closeErr = c.onClose()
+		}
+	})
+	return closeErr
+}
+
+// Done returns a channel that is closed once the Conn is closed.
+func (c *closer) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns a *DisconnectError describing why the Conn was closed, or nil if it's open.
+func (c *closer) Err() error {
+	select {
+	case <-c.done:
+		return c.err
+	default:
+		return nil
+	}
+}
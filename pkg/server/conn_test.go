@@ -0,0 +1,101 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jeremyt135/tictactoe/pkg/clock"
+	"github.com/jeremyt135/tictactoe/pkg/logger"
+)
+
+func waitDone(t *testing.T, c Conn) *DisconnectError {
+	t.Helper()
+	select {
+	case <-c.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Conn was not closed")
+	}
+	var err *DisconnectError
+	if !errors.As(c.Err(), &err) {
+		t.Fatalf("Err returned %v, expected a *DisconnectError", c.Err())
+	}
+	return err
+}
+
+func TestTcpConnDisconnectReasons(t *testing.T) {
+	cases := map[string]func(c *TcpConn, client net.Conn){
+		ReasonClientClosed: func(c *TcpConn, client net.Conn) { client.Close() },
+		ReasonServerClosed: func(c *TcpConn, client net.Conn) { close(c.send) },
+		ReasonKicked:       func(c *TcpConn, client net.Conn) { c.Close(ReasonKicked) },
+	}
+	for reason, disconnect := range cases {
+		server, client := net.Pipe()
+		c := newTcpConn(server, 0, nil, logger.NoOpLogger())
+		go c.poll()
+
+		if c.Err() != nil {
+			t.Errorf("open Conn returned error %v", c.Err())
+		}
+		disconnect(c, client)
+		if err := waitDone(t, c); err.Reason != reason {
+			t.Errorf("Conn closed with reason %q, expected %q", err.Reason, reason)
+		}
+		if _, ok := <-c.Receive(); ok {
+			t.Error("Receive was not closed after the Conn was closed")
+		}
+		if reason != ReasonServerClosed {
+			close(c.send)
+		}
+		client.Close()
+	}
+}
+
+func TestTcpConnWriteDeadline(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	server, client := net.Pipe()
+	defer client.Close()
+	c := newTcpConn(server, 0, clk, logger.NoOpLogger())
+	go c.poll()
+	defer close(c.send)
+	c.setReadTimeout(0)
+
+	// The client doesn't read, so the write blocks until its deadline
+	c.send <- "first\n"
+	clk.BlockUntil(1) // the write deadline
+	clk.Advance(connTimeout)
+
+	c.send <- "second\n"
+	buf := make([]byte, 16)
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatalf("Read returned error: %v", err)
+	}
+	if msg := string(buf[:n]); msg != "second\n" {
+		t.Errorf("client read %q, expected the message after the timed out write", msg)
+	}
+	if c.Err() != nil {
+		t.Errorf("Conn was closed with %v after a write timed out", c.Err())
+	}
+}
+
+func TestTcpConnReadTimeout(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	server, client := net.Pipe()
+	defer client.Close()
+	c := newTcpConn(server, 0, clk, logger.NoOpLogger())
+	go c.poll()
+	defer close(c.send)
+
+	// The client sends a line, then goes quiet
+	client.Write([]byte("HELLO\n"))
+	if msg := <-c.Receive(); msg != "HELLO\n" {
+		t.Fatalf("server received %q, expected %q", msg, "HELLO\n")
+	}
+	clk.BlockUntil(1) // the read deadline
+	clk.Advance(connTimeout)
+	if err := waitDone(t, c); err.Reason != ReasonReadTimeout {
+		t.Errorf("Conn closed with reason %q, expected %q", err.Reason, ReasonReadTimeout)
+	}
+}
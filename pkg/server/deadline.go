@@ -0,0 +1,98 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jeremyt135/tictactoe/pkg/clock"
+)
+
+// connTimeout is how long a read or write on a connection, or its TLS
+// handshake, may block. Reads have no deadline when the Server sends
+// heartbeats instead.
+const connTimeout = time.Minute
+
+// expired is a deadline that has already passed.
+var expired = time.Unix(1, 0)
+
+// deadline sets a read or write deadline on a connection by timing it with
+// a Clock, so the deadline follows a fake Clock in tests. When the timer
+// fires, the deadline is moved into the past to interrupt blocked I/O.
+type deadline struct {
+	clock clock.Clock
+	set   func(time.Time) error // such as net.Conn.SetReadDeadline
+	mux   sync.Mutex
+	timer clock.Timer
+	gen   int // changed whenever the deadline is, so a stale timer does nothing
+}
+
+func newDeadline(c clock.Clock, set func(time.Time) error) *deadline {
+	if c == nil {
+		c = clock.Real()
+	}
+	return &deadline{clock: c, set: set}
+}
+
+// arm makes I/O fail once d has passed, replacing any earlier deadline.
+func (dl *deadline) arm(d time.Duration) error {
+	dl.mux.Lock()
+	defer dl.mux.Unlock()
+
+	dl.stopLocked()
+	gen := dl.gen
+	dl.timer = dl.clock.AfterFunc(d, func() {
+		dl.mux.Lock()
+		defer dl.mux.Unlock()
+		if dl.gen == gen {
+			dl.set(expired)
+		}
+	})
+	return dl.set(time.Time{})
+}
+
+// disarm removes the deadline.
+func (dl *deadline) disarm() error {
+	dl.mux.Lock()
+	defer dl.mux.Unlock()
+
+	dl.stopLocked()
+	return dl.set(time.Time{})
+}
+
+func (dl *deadline) stopLocked() {
+	if dl.timer != nil {
+		dl.timer.Stop()
+	}
+	dl.gen++
+}
+
+// readDeadline is a deadline armed before each read, for a timeout the
+// Server can change while the connection is open.
+type readDeadline struct {
+	*deadline
+	mux     sync.Mutex
+	timeout time.Duration // zero for no deadline
+}
+
+func newReadDeadline(c clock.Clock, set func(time.Time) error) *readDeadline {
+	return &readDeadline{deadline: newDeadline(c, set), timeout: connTimeout}
+}
+
+// rearm starts the timeout again for the next read.
+func (dl *readDeadline) rearm() error {
+	dl.mux.Lock()
+	defer dl.mux.Unlock()
+	if dl.timeout == 0 {
+		return dl.disarm()
+	}
+	return dl.arm(dl.timeout)
+}
+
+// setTimeout changes the timeout, starting it again for the read in
+// progress. Zero removes the deadline.
+func (dl *readDeadline) setTimeout(d time.Duration) error {
+	dl.mux.Lock()
+	dl.timeout = d
+	dl.mux.Unlock()
+	return dl.rearm()
+}
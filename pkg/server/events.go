@@ -0,0 +1,323 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jeremyt135/tictactoe/pkg/protocol"
+	"github.com/jeremyt135/tictactoe/pkg/server/internal/lobby"
+)
+
+// Events holds functions a Server calls when things happen to its
+// connections and lobbies. Any of them may be nil.
+//
+// Events are delivered in order from a goroutine of their own, so a slow
+// function delays later events but never a game. If too many events are
+// waiting, new ones are dropped and counted in the tictactoe_events_dropped_total metric.
+type Events struct {
+	OnConnect     func(ConnectEvent)
+	OnHandshake   func(HandshakeEvent)
+	OnLobbyStart  func(LobbyStartEvent)
+	OnMove        func(MoveEvent)
+	OnInvalidMove func(InvalidMoveEvent)
+	OnGameOver    func(GameOverEvent)
+	OnDisconnect  func(DisconnectEvent)
+}
+
+// PlayerInfo identifies a player in a lobby.
+type PlayerInfo struct {
+	// ID is the player's seat in the lobby.
+	ID     int
+	ConnID int
+	Token  string
+
+	// Name is the name the player registered for a tournament with, if any.
+	Name string
+
+	// RTT is the round-trip time of the player's last heartbeat, or 0 if
+	// heartbeats are disabled or none was answered yet.
+	RTT time.Duration
+
+	// Identity is the common name of the player's verified TLS
+	// certificate, if any.
+	Identity string
+}
+
+// ConnectEvent is sent when a connection is accepted.
+type ConnectEvent struct {
+	Time       time.Time
+	ConnID     int
+	RemoteAddr net.Addr
+	Transport  string
+}
+
+// Kinds of handshake.
+const (
+	HandshakePlay      = "play"
+	HandshakeStandings = "standings"
+	HandshakeRegister  = "register"
+)
+
+// HandshakeEvent is sent when a client answers the greeting, or fails to.
+type HandshakeEvent struct {
+	Time   time.Time
+	ConnID int
+
+	// Kind is what the client asked for, such as HandshakePlay, or "" if the handshake failed.
+	Kind string
+
+	// Name is the name a client registered for a tournament with.
+	Name string
+
+	// Identity is the common name of the client's verified TLS
+	// certificate, or "" if it did not present one.
+	Identity string
+
+	// Err is why the handshake failed, or nil.
+	Err error
+}
+
+// LobbyStartEvent is sent when a lobby starts a game or series of games.
+type LobbyStartEvent struct {
+	Time    time.Time
+	LobbyID int
+	Players []PlayerInfo
+}
+
+// MoveEvent is sent after each valid move.
+type MoveEvent struct {
+	Time     time.Time
+	LobbyID  int
+	Player   PlayerInfo
+	Row, Col int
+
+	// Board is the board after the move.
+	Board [3][3]string
+}
+
+// InvalidMoveEvent is sent when a move is rejected.
+type InvalidMoveEvent struct {
+	Time    time.Time
+	LobbyID int
+	Player  PlayerInfo
+
+	// Err is the error sent to the player.
+	Err error
+}
+
+// GameRecord describes one game of a series.
+type GameRecord struct {
+	// Winner is the winning token, or "_" if there was none.
+	Winner string
+
+	// Finished is false if the game ended early.
+	Finished       bool
+	Moves          []protocol.TurnInfo
+	Board          [3][3]string
+	Started, Ended time.Time
+}
+
+// GameOverEvent is sent when a lobby's game or series of games ends.
+type GameOverEvent struct {
+	Time    time.Time
+	LobbyID int
+	GameID  string
+
+	// Players holds the players that started the series, indexed by ID.
+	Players []PlayerInfo
+
+	// Winner is the ID of the player that won the series, or -1. Forfeit is
+	// the ID of a player removed before the game could finish, or -1.
+	Winner  int
+	Forfeit int
+
+	// Wins holds the games won by each player, indexed by ID.
+	Wins           []int
+	Draws          int
+	Games          []GameRecord
+	Started, Ended time.Time
+}
+
+// DisconnectEvent is sent when a connection is closed.
+type DisconnectEvent struct {
+	Time       time.Time
+	ConnID     int
+	RemoteAddr net.Addr
+	Transport  string
+
+	// Reason is the Reason of Err, such as ReasonClientClosed.
+	Reason string
+
+	// Err is the *DisconnectError returned by the connection.
+	Err error
+}
+
+// maxPendingEvents is the number of events that can wait to be delivered.
+const maxPendingEvents = 1024
+
+// eventQueue runs functions in order, from a goroutine that only runs
+// while there are functions waiting.
+type eventQueue struct {
+	mux     sync.Mutex
+	pending []func()
+	running bool
+}
+
+// push adds f to the queue, returning false if the queue is full.
+func (q *eventQueue) push(f func()) bool {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	if len(q.pending) >= maxPendingEvents {
+		return false
+	}
+	q.pending = append(q.pending, f)
+	if !q.running {
+		q.running = true
+		go q.run()
+	}
+	return true
+}
+
+func (q *eventQueue) run() {
+	for {
+		q.mux.Lock()
+		if len(q.pending) == 0 {
+			q.running = false
+			q.mux.Unlock()
+			return
+		}
+		f := q.pending[0]
+		q.pending[0] = nil
+		q.pending = q.pending[1:]
+		q.mux.Unlock()
+
+		f()
+	}
+}
+
+func (s *Server) queueEvent(f func()) {
+	if !s.eventQueue.push(f) {
+		s.metrics.eventsDropped.Inc()
+	}
+}
+
+func eventPlayer(p lobby.PlayerInfo) PlayerInfo {
+	return PlayerInfo{ID: p.ID, ConnID: p.ConnID, Token: p.Token, Name: p.Name, RTT: p.RTT, Identity: p.Identity}
+}
+
+func eventPlayers(players []lobby.PlayerInfo) []PlayerInfo {
+	out := make([]PlayerInfo, 0, len(players))
+	for _, p := range players {
+		out = append(out, eventPlayer(p))
+	}
+	return out
+}
+
+func (s *Server) connected(info *connInfo) {
+	f := s.events.OnConnect
+	if f == nil {
+		return
+	}
+	e := ConnectEvent{
+		Time:       s.clock.Now(),
+		ConnID:     info.id,
+		RemoteAddr: info.conn.RemoteAddr(),
+		Transport:  info.conn.Transport(),
+	}
+	s.queueEvent(func() { f(e) })
+}
+
+func (s *Server) handshaken(info *connInfo, kind, name string, err error) {
+	f := s.events.OnHandshake
+	if f == nil {
+		return
+	}
+	e := HandshakeEvent{Time: s.clock.Now(), ConnID: info.id, Kind: kind, Name: name, Identity: info.identity, Err: err}
+	s.queueEvent(func() { f(e) })
+}
+
+func (s *Server) disconnected(info *connInfo) {
+	f := s.events.OnDisconnect
+	if f == nil {
+		return
+	}
+	e := DisconnectEvent{
+		Time:       s.clock.Now(),
+		ConnID:     info.id,
+		RemoteAddr: info.conn.RemoteAddr(),
+		Transport:  info.conn.Transport(),
+		Err:        info.conn.Err(),
+	}
+	var derr *DisconnectError
+	if errors.As(e.Err, &derr) {
+		e.Reason = derr.Reason
+	}
+	s.queueEvent(func() { f(e) })
+}
+
+func (s *Server) lobbyStarted(r lobby.Result) {
+	f := s.events.OnLobbyStart
+	if f == nil {
+		return
+	}
+	e := LobbyStartEvent{Time: s.clock.Now(), LobbyID: r.LobbyID, Players: eventPlayers(r.Seats[:])}
+	s.queueEvent(func() { f(e) })
+}
+
+func (s *Server) moved(m lobby.Move) {
+	f := s.events.OnMove
+	if f == nil {
+		return
+	}
+	e := MoveEvent{
+		Time:    s.clock.Now(),
+		LobbyID: m.LobbyID,
+		Player:  eventPlayer(m.Player),
+		Row:     m.Turn.Row,
+		Col:     m.Turn.Col,
+		Board:   m.Board,
+	}
+	s.queueEvent(func() { f(e) })
+}
+
+func (s *Server) invalidMove(m lobby.InvalidMove) {
+	f := s.events.OnInvalidMove
+	if f == nil {
+		return
+	}
+	e := InvalidMoveEvent{Time: s.clock.Now(), LobbyID: m.LobbyID, Player: eventPlayer(m.Player), Err: m.Err}
+	s.queueEvent(func() { f(e) })
+}
+
+func (s *Server) gameOver(r lobby.Result) {
+	f := s.events.OnGameOver
+	if f == nil {
+		return
+	}
+	e := GameOverEvent{
+		Time:    s.clock.Now(),
+		LobbyID: r.LobbyID,
+		GameID:  r.GameID,
+		Players: eventPlayers(r.Seats[:]),
+		Winner:  r.Winner,
+		Forfeit: r.Forfeit,
+		Wins:    append([]int(nil), r.Wins[:]...),
+		Draws:   r.Draws,
+		Started: r.Started,
+		Ended:   r.Ended,
+	}
+	for _, g := range r.Games {
+		e.Games = append(e.Games, GameRecord{
+			Winner:   g.Winner,
+			Finished: g.Finished,
+			Moves:    g.Moves,
+			Board:    g.Board,
+			Started:  g.Started,
+			Ended:    g.Ended,
+		})
+	}
+	s.queueEvent(func() { f(e) })
+}
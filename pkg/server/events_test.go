@@ -0,0 +1,120 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jeremyt135/tictactoe/pkg/tokens"
+)
+
+// playPipeGame plays one game between two PipeListener clients in which X
+// takes the top row, and waits for both clients to be disconnected.
+func playPipeGame(t *testing.T, s *Server) {
+	t.Helper()
+	l := NewPipeListener()
+	defer l.Close()
+	go s.Serve(l)
+
+	cells := map[string][]int{tokens.X: {0, 1, 2}, tokens.O: {3, 4}}
+	done := make(chan struct{}, 2)
+	for i := 0; i < 2; i++ {
+		c, err := l.Dial()
+		if err != nil {
+			t.Fatalf("Dial returned error: %v", err)
+		}
+		go func() {
+			playPipe(c, cells)
+			done <- struct{}{}
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("game did not end within 5s")
+		}
+	}
+}
+
+func TestEventsDuringGame(t *testing.T) {
+	events := make(chan interface{}, 100)
+	s, _ := NewServer(&Options{NumLobbies: 1, Events: &Events{
+		OnConnect:     func(e ConnectEvent) { events <- e },
+		OnHandshake:   func(e HandshakeEvent) { events <- e },
+		OnLobbyStart:  func(e LobbyStartEvent) { events <- e },
+		OnMove:        func(e MoveEvent) { events <- e },
+		OnInvalidMove: func(e InvalidMoveEvent) { events <- e },
+		OnGameOver:    func(e GameOverEvent) { events <- e },
+		OnDisconnect:  func(e DisconnectEvent) { events <- e },
+	}})
+	defer s.Close()
+	playPipeGame(t, s)
+
+	counts := make(map[string]int)
+	var moves []MoveEvent
+	var over GameOverEvent
+	for counts["disconnect"] < 2 {
+		var e interface{}
+		select {
+		case e = <-events:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received events %v, expected 2 disconnects", counts)
+		}
+		switch e := e.(type) {
+		case ConnectEvent:
+			counts["connect"]++
+			if e.Transport != "pipe" {
+				t.Errorf("connect event has transport %q, expected pipe", e.Transport)
+			}
+		case HandshakeEvent:
+			counts["handshake"]++
+			if e.Kind != HandshakePlay || e.Err != nil {
+				t.Errorf("handshake event has kind %q and error %v, expected a play handshake", e.Kind, e.Err)
+			}
+		case LobbyStartEvent:
+			counts["start"]++
+			if len(e.Players) != 2 || len(moves) > 0 {
+				t.Errorf("lobby start event has players %+v after %d moves, expected 2 players before any move", e.Players, len(moves))
+			}
+		case MoveEvent:
+			moves = append(moves, e)
+		case InvalidMoveEvent:
+			t.Errorf("unexpected invalid move event %+v", e)
+		case GameOverEvent:
+			counts["over"]++
+			over = e
+		case DisconnectEvent:
+			counts["disconnect"]++
+			if e.Reason != ReasonServerClosed {
+				t.Errorf("disconnect event has reason %q, expected %q", e.Reason, ReasonServerClosed)
+			}
+		}
+	}
+
+	if counts["connect"] != 2 || counts["handshake"] != 2 || counts["start"] != 1 || counts["over"] != 1 {
+		t.Errorf("received events %v, expected 2 connects, 2 handshakes, 1 start and 1 game over", counts)
+	}
+	if len(moves) != 5 {
+		t.Fatalf("received %d move events, expected 5", len(moves))
+	}
+	last := moves[len(moves)-1]
+	if last.Player.Token != tokens.X || last.Board[0] != [3]string{tokens.X, tokens.X, tokens.X} {
+		t.Errorf("last move was by %s leaving board %v, expected X to take the top row", last.Player.Token, last.Board)
+	}
+	if over.Winner < 0 || over.Players[over.Winner].Token != tokens.X || len(over.Games) != 1 {
+		t.Errorf("game over event has winner %d, players %+v and %d games, expected X to win one game",
+			over.Winner, over.Players, len(over.Games))
+	}
+}
+
+func TestEventsDoNotBlockGames(t *testing.T) {
+	release := make(chan struct{})
+	s, _ := NewServer(&Options{NumLobbies: 1, Events: &Events{
+		OnMove: func(MoveEvent) { <-release },
+	}})
+	defer s.Close()
+	defer close(release)
+
+	// The game ends while the first move event is still being handled
+	playPipeGame(t, s)
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jeremyt135/tictactoe/pkg/protocol"
+	"github.com/jeremyt135/tictactoe/pkg/server/internal/player"
+)
+
+// Defaults for HeartbeatOptions.
+const (
+	DefaultHeartbeatInterval  = 15 * time.Second
+	DefaultHeartbeatMaxMissed = 3
+)
+
+// HeartbeatOptions make the Server check that players are still there,
+// whether or not they are moving. Once a player has joined, the Server sends
+// "PING <n>" every Interval, which the player must answer with "PONG <n>".
+// Heartbeats are advertised to clients as a capability after the handshake.
+// Without them, a TCP or WebSocket client that sends nothing for a minute is
+// disconnected; with them, a player can stay silent as long as they answer.
+//
+// The round-trip time of each answered PING is recorded, and a player's
+// turn timeout is extended by their last round-trip time.
+type HeartbeatOptions struct {
+	// Interval is the time between PINGs. If zero, DefaultHeartbeatInterval is used.
+	Interval time.Duration
+
+	// MaxMissed is the number of PINGs in a row a player can leave
+	// unanswered before they are disconnected. If zero,
+	// DefaultHeartbeatMaxMissed is used.
+	MaxMissed int
+}
+
+func validateHeartbeatOptions(opt *HeartbeatOptions) error {
+	if opt.Interval < 0 || opt.MaxMissed < 0 {
+		return errors.New("heartbeat interval and missed heartbeats must not be negative")
+	}
+	return nil
+}
+
+// withDefaults returns a copy of opt with zero values replaced by the defaults.
+func (opt HeartbeatOptions) withDefaults() HeartbeatOptions {
+	if opt.Interval == 0 {
+		opt.Interval = DefaultHeartbeatInterval
+	}
+	if opt.MaxMissed == 0 {
+		opt.MaxMissed = DefaultHeartbeatMaxMissed
+	}
+	return opt
+}
+
+// heartbeatQueueSize is the number of lines heartbeat holds for the lobby
+// while it isn't reading them. Further lines, and the PONGs behind them,
+// wait on the Conn, so a player who keeps sending is disconnected for
+// missing heartbeats.
+const heartbeatQueueSize = 16
+
+// heartbeat sends PINGs to p through pings and takes the PONG lines out of
+// receive, recording the round-trip time of each. The lines left are
+// returned, and the channel is closed when receive is. c is closed with
+// ReasonNoHeartbeat once p misses opt.MaxMissed PINGs in a row.
+//
+// Lines the lobby isn't ready for are held so that PINGs go on while it
+// waits for an opponent or between games.
+func (s *Server) heartbeat(info *connInfo, c Conn, p *player.Player, pings chan<- string, receive <-chan string, opt HeartbeatOptions) <-chan string {
+	lines := make(chan string)
+
+	go func() {
+		defer close(lines)
+		var (
+			seq     int
+			sent    time.Time
+			waiting bool // for a PONG to seq
+			missed  int
+			pending []string // for the lobby
+		)
+		timer := s.clock.NewTimer(opt.Interval)
+		defer func() { timer.Stop() }()
+		for {
+			var out chan<- string
+			var next string
+			if len(pending) > 0 {
+				out, next = lines, pending[0]
+			}
+			in := receive
+			if len(pending) >= heartbeatQueueSize {
+				in = nil
+			}
+			select {
+			case out <- next:
+				pending = pending[1:]
+			case line, ok := <-in:
+				if !ok {
+					for _, line := range pending {
+						select {
+						case lines <- line:
+						case <-c.Done():
+							return
+						}
+					}
+					return
+				}
+				if protocol.ParseOp(line) != (protocol.Pong{}).Op() {
+					pending = append(pending, line)
+					continue
+				}
+				cmd, err := protocol.ParsePong(line)
+				if err != nil || !waiting || cmd.(protocol.Pong).Seq != seq {
+					// A late answer to an earlier PING is ignored
+					continue
+				}
+				rtt := s.clock.Now().Sub(sent)
+				waiting, missed = false, 0
+				p.SetRTT(rtt)
+				s.metrics.heartbeatRTT.Observe(rtt.Seconds())
+			case <-timer.C():
+				if waiting {
+					missed++
+					info.logger.Debug("missed a heartbeat", "missed", missed)
+				}
+				if missed >= opt.MaxMissed {
+					info.logger.Info("disconnecting", "error", "missed heartbeats", "missed", missed)
+					s.metrics.rejected.Inc(rejectNoHeartbeat)
+					c.Close(ReasonNoHeartbeat)
+					drain(receive)
+					return
+				}
+				seq++
+				sent, waiting = s.clock.Now(), true
+				select {
+				case pings <- protocol.Ping{Seq: seq}.String():
+				default:
+					// the last PING hasn't been sent yet
+				}
+				timer = s.clock.NewTimer(opt.Interval)
+			case <-c.Done():
+				drain(receive)
+				return
+			}
+		}
+	}()
+	return lines
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jeremyt135/tictactoe/pkg/clock"
+	"github.com/jeremyt135/tictactoe/pkg/logger"
+	"github.com/jeremyt135/tictactoe/pkg/protocol"
+)
+
+func TestHeartbeatRecordsRTTAndDisconnects(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	disconnects := make(chan DisconnectEvent, 1)
+	s, _ := NewServer(&Options{
+		NumLobbies: 1,
+		Clock:      clk,
+		Heartbeat:  &HeartbeatOptions{Interval: 10 * time.Second, MaxMissed: 2},
+		Events:     &Events{OnDisconnect: func(e DisconnectEvent) { disconnects <- e }},
+	})
+	defer s.Close()
+	l := NewPipeListener()
+	defer l.Close()
+	go s.Serve(l)
+
+	// The player waits for an opponent, answering the first PING
+	x := echoGreeting(t, l)
+	expectMessage(t, x, protocol.Capabilities{Names: []string{protocol.CapabilityPing}}.String())
+	clk.BlockUntil(1)
+	clk.Advance(10 * time.Second)
+	expectMessage(t, x, protocol.Ping{Seq: 1}.String())
+	clk.Advance(40 * time.Millisecond)
+	x.Write(protocol.Pong{Seq: 1}.String())
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		players := s.allLobbies()[0].Snapshot().Players
+		if len(players) == 1 && players[0].RTT == 40*time.Millisecond {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("lobby has players %+v, expected one with an RTT of 40ms", players)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Then stops answering
+	for seq := 2; seq <= 3; seq++ {
+		clk.BlockUntil(1)
+		clk.Advance(10 * time.Second)
+		expectMessage(t, x, protocol.Ping{Seq: seq}.String())
+	}
+	clk.BlockUntil(1)
+	clk.Advance(10 * time.Second)
+	select {
+	case e := <-disconnects:
+		if e.Reason != ReasonNoHeartbeat {
+			t.Errorf("connection closed with reason %q, expected %q", e.Reason, ReasonNoHeartbeat)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection was not closed after missing 2 heartbeats")
+	}
+}
+
+func TestHeartbeatDisconnectsWithLinePending(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	disconnects := make(chan DisconnectEvent, 1)
+	s, _ := NewServer(&Options{
+		NumLobbies: 1,
+		Clock:      clk,
+		Heartbeat:  &HeartbeatOptions{Interval: 10 * time.Second, MaxMissed: 2},
+		Events:     &Events{OnDisconnect: func(e DisconnectEvent) { disconnects <- e }},
+	})
+	defer s.Close()
+	l := NewPipeListener()
+	defer l.Close()
+	go s.Serve(l)
+
+	// While waiting for an opponent, the player sends a line the lobby
+	// won't read and answers a PING, then goes silent
+	x := echoGreeting(t, l)
+	expectMessage(t, x, protocol.Capabilities{Names: []string{protocol.CapabilityPing}}.String())
+	clk.BlockUntil(1)
+	clk.Advance(10 * time.Second)
+	expectMessage(t, x, protocol.Ping{Seq: 1}.String())
+	clk.Advance(40 * time.Millisecond)
+	x.Write(protocol.TurnInfo{Token: "X", Row: 0, Col: 0}.String())
+	x.Write(protocol.Pong{Seq: 1}.String())
+
+	// The PONG gets past the line
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		players := s.allLobbies()[0].Snapshot().Players
+		if len(players) == 1 && players[0].RTT == 40*time.Millisecond {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("lobby has players %+v, expected one with an RTT of 40ms", players)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	for seq := 2; seq <= 3; seq++ {
+		clk.BlockUntil(1)
+		clk.Advance(10 * time.Second)
+		expectMessage(t, x, protocol.Ping{Seq: seq}.String())
+	}
+	clk.BlockUntil(1)
+	clk.Advance(10 * time.Second)
+	select {
+	case e := <-disconnects:
+		if e.Reason != ReasonNoHeartbeat {
+			t.Errorf("connection closed with reason %q, expected %q", e.Reason, ReasonNoHeartbeat)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection with a line pending was not closed after missing 2 heartbeats")
+	}
+}
+
+// dialTCPPlayer connects to l, completes the handshake and waits for the
+// player to join a lobby of s.
+func dialTCPPlayer(t *testing.T, s *Server, l *TcpListener) net.Conn {
+	t.Helper()
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("could not connect: %v", err)
+	}
+	if line, err := bufio.NewReader(client).ReadString('\n'); err != nil || line != protocol.Greeting {
+		t.Fatalf("client read %q (err %v), expected %q", line, err, protocol.Greeting)
+	}
+	client.Write([]byte(protocol.Greeting))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(s.allLobbies()[0].Snapshot().Players) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("player did not join a lobby")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return client
+}
+
+func TestSilentPeerDroppedWithoutHeartbeats(t *testing.T) {
+	for _, heartbeat := range []*HeartbeatOptions{nil, {Interval: time.Hour}} {
+		clk := clock.NewFake(time.Now())
+		disconnects := make(chan DisconnectEvent, 1)
+		opt := DefaultOptions()
+		opt.NumLobbies = 1
+		opt.Clock = clk
+		opt.Heartbeat = heartbeat
+		opt.Events = &Events{OnDisconnect: func(e DisconnectEvent) { disconnects <- e }}
+		s, err := NewServer(opt)
+		if err != nil {
+			t.Fatalf("NewServer returned error: %v", err)
+		}
+		l, err := ListenTcp("127.0.0.1:0", logger.NoOpLogger())
+		if err != nil {
+			t.Fatalf("ListenTcp returned error: %v", err)
+		}
+		l.UseClock(clk)
+		go l.PollAccept()
+		go s.Serve(l)
+
+		// The player waits for an opponent without sending anything
+		client := dialTCPPlayer(t, s, l)
+		var dropped *DisconnectEvent
+		for i := 0; i < 20 && dropped == nil; i++ {
+			clk.Advance(connTimeout)
+			select {
+			case e := <-disconnects:
+				dropped = &e
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		switch {
+		case heartbeat == nil && dropped == nil:
+			t.Error("silent player was not disconnected without heartbeats")
+		case heartbeat == nil && dropped.Reason != ReasonReadTimeout:
+			t.Errorf("connection closed with reason %q, expected %q", dropped.Reason, ReasonReadTimeout)
+		case heartbeat != nil && dropped != nil:
+			t.Errorf("player waiting between heartbeats was disconnected with reason %q", dropped.Reason)
+		}
+
+		client.Close()
+		s.Close()
+		l.Close()
+	}
+}
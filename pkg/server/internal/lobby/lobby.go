@@ -1,8 +1,14 @@
 package lobby
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/jeremyt135/tictactoe/pkg/clock"
 	"github.com/jeremyt135/tictactoe/pkg/logger"
 	"github.com/jeremyt135/tictactoe/pkg/server/internal/config"
 	"github.com/jeremyt135/tictactoe/pkg/server/internal/player"
@@ -13,51 +19,495 @@ import (
 )
 
 // Lobby records an ongoing game and its players.
+//
+// Every Lobby runs a goroutine that owns its state. Methods send commands
+// to that goroutine and wait for them to run, so they are safe to call
+// from any goroutine, except from the functions passed to OnStart, OnMove,
+// OnLine, OnGameOver and OnInvalidMove, which are called by the Lobby's goroutine.
 type Lobby struct {
 	board         *game.Board
 	players       player.Array
 	logger        logger.Logger
+	clock         clock.Clock
 	id            int
-	playing       bool
+	state         state
 	currentPlayer int
+	keepPlayers   bool
+	rules         Rules  // applied to the next game
+	active        Rules  // used by the game in progress
+	ending        string // reason given to End during a game
+	closing       bool   // Close was called during a game
+	onStart       []func(Result)
+	onMove        []func(Move)
+	onLine        []func(Line)
+	onGameOver    []func(Result)
+	onInvalidMove []func(InvalidMove)
+	result        Result
+	game          Game // game in progress
+	cmds          chan func()
+	closed        chan struct{}
+}
+
+// state is a stage in the life of a Lobby.
+type state int
+
+const (
+	stateWaiting  state = iota // waiting for players to fill the Lobby
+	stateStarting              // full, about to start a game
+	statePlaying               // playing a game or series
+	statePostGame              // reporting the result and removing players
+	stateClosed                // stopped, running no more commands
+)
+
+var stateNames = [...]string{"waiting", "starting", "playing", "post-game", "closed"}
+
+func (s state) String() string {
+	return stateNames[s]
 }
 
-var nextLobbyID = 0
+// Rules are the settings of a Lobby that apply to a whole game or series.
+// Changing them doesn't affect a game in progress.
+type Rules struct {
+	SeriesLength int
+
+	// TurnTimeout is extended on each turn by the player's last round-trip
+	// time, so a player on a slow connection isn't timed out for the lag.
+	TurnTimeout     time.Duration
+	MaxTurnAttempts int
+
+	// SendState sends each player the State of the board when they are
+	// seated and when a game starts.
+	SendState bool
+}
+
+// Result records the outcome of a game played in a Lobby.
+type Result struct {
+	LobbyID int
+
+	// GameID identifies the game, or series of games, among every game
+	// played by any Lobby.
+	GameID string
+
+	// Winner is the ID of the player that won the series, or -1 if there was no winner.
+	Winner int
+
+	// Games holds every game played, including one left unfinished. Wins holds
+	// the games won by each player, indexed by ID, and Draws the games with no winner.
+	Games []Game
+	Wins  [config.MaxPlayers]int
+	Draws int
+
+	// Seats describes the players that started the series, indexed by ID.
+	Seats [config.MaxPlayers]PlayerInfo
 
-// New constructs a new game Lobby.
+	Started, Ended time.Time
+
+	// Forfeit is the ID of a player removed before the game could finish, or -1.
+	Forfeit int
+
+	// Players holds the players still in the Lobby when the game ended, indexed by ID.
+	// It is only filled in when the Lobby keeps its players.
+	Players [config.MaxPlayers]*player.Player
+}
+
+// Game records a single game played in a Lobby.
+type Game struct {
+	// Winner is the winning token, or tokens.Empty if there was no winner.
+	Winner string
+
+	// Finished is false if the game ended early because a player was removed
+	// or the Lobby was ended.
+	Finished bool
+
+	Moves          []protocol.TurnInfo
+	Board          [3][3]string
+	Started, Ended time.Time
+}
+
+var nextLobbyID int32 = -1
+
+// New constructs a new game Lobby and starts its goroutine, which runs until Close is called.
 func New() (lobby *Lobby) {
 	lobby = &Lobby{
 		board:         game.New(),
 		players:       player.NewFixedArray(),
-		id:            nextLobbyID,
+		id:            int(atomic.AddInt32(&nextLobbyID, 1)),
 		logger:        logger.NoOpLogger(),
-		playing:       false,
+		clock:         clock.Real(),
+		state:         stateWaiting,
 		currentPlayer: -1,
+		rules:         Rules{SeriesLength: 1, MaxTurnAttempts: DefaultMaxTurnAttempts},
+		cmds:          make(chan func()),
+		closed:        make(chan struct{}),
 	}
-
-	nextLobbyID++
+	lobby.reset()
+	go lobby.run()
 	return
 }
 
-// UseLogger changes the logger being used by the Lobby.
+// run executes commands and plays games until the Lobby is closed.
+func (l *Lobby) run() {
+	defer close(l.closed)
+	for {
+		switch l.state {
+		case stateWaiting:
+			l.wait()
+		case stateStarting:
+			l.start()
+		case statePlaying:
+			l.play()
+		case statePostGame:
+			l.stop()
+		case stateClosed:
+			return
+		}
+	}
+}
+
+// wait runs a command, or answers a request from the player waiting for an
+// opponent. A waiting player whose connection closes gives up their seat.
+func (l *Lobby) wait() {
+	var p *player.Player
+	var requests <-chan player.Request
+	for i := 0; i < config.MaxPlayers; i++ {
+		if seated := l.players.At(i); seated != nil && seated.Requests != nil {
+			p, requests = seated, seated.Requests
+		}
+	}
+	select {
+	case cmd := <-l.cmds:
+		cmd()
+	case req, ok := <-requests:
+		if !ok {
+			l.removePlayer(p, "disconnected")
+			return
+		}
+		l.request(p, req)
+	}
+}
+
+// do runs f on the Lobby's goroutine and waits for it to return. It returns
+// false without running f if the Lobby is closed.
+func (l *Lobby) do(f func()) bool {
+	done := make(chan struct{})
+	select {
+	case l.cmds <- func() {
+		f()
+		close(done)
+	}:
+		<-done
+		return true
+	case <-l.closed:
+		return false
+	}
+}
+
+func (l *Lobby) setState(s state) {
+	l.logger.Debug("state changed", "from", l.state.String(), "to", s.String())
+	l.state = s
+}
+
+// UseLogger changes the logger being used by the Lobby. Entries are
+// written with the Lobby's ID under the "lobby" key.
 func (l *Lobby) UseLogger(logger logger.Logger) *Lobby {
-	l.logger = logger
+	l.do(func() {
+		l.logger = logger.With("lobby", l.id)
+	})
+	return l
+}
+
+// UseClock sets the Clock used for turn timeouts and the times in a Result.
+func (l *Lobby) UseClock(c clock.Clock) *Lobby {
+	l.do(func() {
+		l.clock = c
+	})
+	return l
+}
+
+// OnGameOver adds a function to call with the Result of each game, or series
+// of games, played in the Lobby. Functions are called in the order they were
+// added, from the Lobby's goroutine.
+func (l *Lobby) OnGameOver(f func(Result)) *Lobby {
+	l.do(func() {
+		l.onGameOver = append(l.onGameOver, f)
+	})
+	return l
+}
+
+// OnInvalidMove adds a function to call whenever a player's move is rejected.
+func (l *Lobby) OnInvalidMove(f func(InvalidMove)) *Lobby {
+	l.do(func() {
+		l.onInvalidMove = append(l.onInvalidMove, f)
+	})
+	return l
+}
+
+func (l *Lobby) invalidMove(p *player.Player, err error) {
+	m := InvalidMove{LobbyID: l.id, Player: playerInfo(p), Err: err}
+	for _, f := range l.onInvalidMove {
+		f(m)
+	}
+}
+
+// OnStart adds a function to call when a game, or series of games, starts.
+// It is given the Result so far, which holds the seats and start time.
+func (l *Lobby) OnStart(f func(Result)) *Lobby {
+	l.do(func() {
+		l.onStart = append(l.onStart, f)
+	})
+	return l
+}
+
+// OnMove adds a function to call after each valid move.
+func (l *Lobby) OnMove(f func(Move)) *Lobby {
+	l.do(func() {
+		l.onMove = append(l.onMove, f)
+	})
 	return l
 }
 
+func (l *Lobby) moved(p *player.Player, turn protocol.TurnInfo) {
+	m := Move{LobbyID: l.id, Player: playerInfo(p), Turn: turn, Board: l.board.Grid()}
+	for _, f := range l.onMove {
+		f(m)
+	}
+}
+
+// OnLine adds a function to call for every line sent to or received from a player.
+func (l *Lobby) OnLine(f func(Line)) *Lobby {
+	l.do(func() {
+		l.onLine = append(l.onLine, f)
+	})
+	return l
+}
+
+func (l *Lobby) line(p *player.Player, sent bool, text string) {
+	if len(l.onLine) == 0 {
+		return
+	}
+	line := Line{LobbyID: l.id, Player: playerInfo(p), Time: l.clock.Now(), Sent: sent, Text: text}
+	for _, f := range l.onLine {
+		f(line)
+	}
+}
+
+// KeepPlayers makes the Lobby hand its players back through Result
+// when a game ends, instead of removing them from the server.
+func (l *Lobby) KeepPlayers() *Lobby {
+	l.do(func() {
+		l.keepPlayers = true
+	})
+	return l
+}
+
+// UseSeries makes the Lobby play a series of n games between the same
+// players, alternating who plays X. The series ends early once a player
+// can no longer be caught.
+func (l *Lobby) UseSeries(n int) *Lobby {
+	l.do(func() {
+		if n > 0 {
+			l.rules.SeriesLength = n
+		}
+	})
+	return l
+}
+
+// UseTurnTimeout makes players forfeit if they don't make a valid move
+// within d of being told it's their turn. Zero disables the timeout.
+func (l *Lobby) UseTurnTimeout(d time.Duration) *Lobby {
+	l.do(func() {
+		if d >= 0 {
+			l.rules.TurnTimeout = d
+		}
+	})
+	return l
+}
+
+// UseMaxTurnAttempts sets the number of invalid moves a player can make
+// in a single turn before they forfeit.
+func (l *Lobby) UseMaxTurnAttempts(n int) *Lobby {
+	l.do(func() {
+		if n > 0 {
+			l.rules.MaxTurnAttempts = n
+		}
+	})
+	return l
+}
+
+// UseSendState makes the Lobby send each player the State of the board
+// when they are seated and when a game starts.
+func (l *Lobby) UseSendState(send bool) *Lobby {
+	l.do(func() {
+		l.rules.SendState = send
+	})
+	return l
+}
+
+// UseRules replaces the Rules used from the next game on. Zero values keep the current setting,
+// except TurnTimeout, where zero disables the timeout, and SendState.
+func (l *Lobby) UseRules(r Rules) *Lobby {
+	return l.UseSeries(r.SeriesLength).UseTurnTimeout(r.TurnTimeout).UseMaxTurnAttempts(r.MaxTurnAttempts).UseSendState(r.SendState)
+}
+
 // IsFull returns true if the Lobby is full and cannot accept more players.
 func (l *Lobby) IsFull() bool {
-	return l.players.IsFull()
+	var full bool
+	l.do(func() {
+		full = l.players.IsFull()
+	})
+	return full
 }
 
 // IsPlaying returns true if the Lobby has a game in progress and cannot accept players.
 func (l *Lobby) IsPlaying() bool {
-	return l.playing
+	var playing bool
+	l.do(func() {
+		playing = l.isPlaying()
+	})
+	return playing
+}
+
+func (l *Lobby) isPlaying() bool {
+	return l.state == stateStarting || l.state == statePlaying || l.state == statePostGame
 }
 
 // IsAvailable returns true if the Lobby is available and can add players
 func (l *Lobby) IsAvailable() bool {
-	return !(l.IsFull() || l.IsPlaying())
+	var available bool
+	l.do(func() {
+		available = l.isAvailable()
+	})
+	return available
+}
+
+func (l *Lobby) isAvailable() bool {
+	return l.state == stateWaiting && !l.players.IsFull()
+}
+
+// PlayerInfo describes a player in a Snapshot.
+type PlayerInfo struct {
+	ID       int
+	ConnID   int
+	Token    string
+	Name     string
+	RTT      time.Duration
+	Identity string
+}
+
+func playerInfo(p *player.Player) PlayerInfo {
+	return PlayerInfo{ID: p.ID, ConnID: p.ConnID, Token: p.Token, Name: p.Name, RTT: p.RTT(), Identity: p.Identity}
+}
+
+// Move describes a valid move made in a Lobby.
+type Move struct {
+	LobbyID int
+	Player  PlayerInfo
+	Turn    protocol.TurnInfo
+
+	// Board is the board after the move.
+	Board [3][3]string
+}
+
+// InvalidMove describes a move rejected by a Lobby.
+type InvalidMove struct {
+	LobbyID int
+	Player  PlayerInfo
+
+	// Err is the error sent to the player.
+	Err error
+}
+
+// Line is a protocol line sent to or received from a player in a Lobby.
+type Line struct {
+	LobbyID int
+	Player  PlayerInfo
+	Time    time.Time
+
+	// Sent is true for a line sent to the player and false for one they sent.
+	Sent bool
+	Text string
+}
+
+// Snapshot is a copy of the state of a Lobby at one point in time.
+type Snapshot struct {
+	ID      int
+	State   string
+	Playing bool
+	Full    bool
+	Board   [3][3]string
+	Players []PlayerInfo
+}
+
+// Snapshot returns a copy of the Lobby's current state.
+func (l *Lobby) Snapshot() Snapshot {
+	snap := Snapshot{ID: l.id, State: stateClosed.String()}
+	l.do(func() {
+		snap.State = l.state.String()
+		snap.Playing = l.isPlaying()
+		snap.Full = l.players.IsFull()
+		snap.Board = l.board.Grid()
+		for i := 0; i < config.MaxPlayers; i++ {
+			if p := l.players.At(i); p != nil {
+				snap.Players = append(snap.Players, playerInfo(p))
+			}
+		}
+	})
+	return snap
+}
+
+// Broadcast sends msg to every player in the Lobby.
+func (l *Lobby) Broadcast(msg string) {
+	l.do(func() {
+		l.broadcast(msg)
+	})
+}
+
+func (l *Lobby) broadcast(msg string) {
+	for i := 0; i < config.MaxPlayers; i++ {
+		if p := l.players.At(i); p != nil {
+			l.send(p, msg)
+		}
+	}
+}
+
+// End stops the game in progress, or removes any waiting players if there
+// is no game, telling the players why.
+func (l *Lobby) End(why string) {
+	l.do(func() {
+		if l.isPlaying() {
+			if l.ending == "" {
+				l.ending = why
+			}
+			return
+		}
+		l.removeAll(why)
+	})
+}
+
+// Close removes any waiting players and stops the Lobby's goroutine. A game
+// in progress is played to the end first.
+func (l *Lobby) Close() {
+	l.do(func() {
+		if l.isPlaying() {
+			l.closing = true
+			return
+		}
+		l.removeAll("lobby closed")
+		l.setState(stateClosed)
+	})
+}
+
+// Closed returns a channel that is closed once the Lobby has stopped.
+func (l *Lobby) Closed() <-chan struct{} {
+	return l.closed
+}
+
+func (l *Lobby) removeAll(why string) {
+	for i := 0; i < config.MaxPlayers; i++ {
+		if p := l.players.At(i); p != nil {
+			l.removePlayer(p, why)
+		}
+	}
 }
 
 // ID returns the Lobby's ID value
@@ -65,11 +515,19 @@ func (l *Lobby) ID() int {
 	return l.id
 }
 
-// AddPlayer adds a player to the game Lobby.
+// AddPlayer adds a player to the game Lobby. A game starts once the Lobby is full.
 //
-// If the Lobby is full, returns an error.
+// If the Lobby is full, playing or closed, returns an error.
 func (l *Lobby) AddPlayer(p *player.Player) error {
-	if !l.IsAvailable() {
+	err := errors.New("lobby is closed")
+	l.do(func() {
+		err = l.addPlayer(p)
+	})
+	return err
+}
+
+func (l *Lobby) addPlayer(p *player.Player) error {
+	if !l.isAvailable() {
 		return errors.New("lobby is not available")
 	}
 	ind, err := l.players.Add(p)
@@ -79,116 +537,372 @@ func (l *Lobby) AddPlayer(p *player.Player) error {
 
 	p.ID = ind
 	p.Token = tokens.FromIndex(ind)
+	if l.rules.SendState {
+		l.send(p, protocol.NewState(l.board).String())
+	}
+	if p.Identity != "" {
+		l.logger.Info("player joined", "player", p.ID, "identity", p.Identity)
+	}
 
-	if l.IsFull() {
-		go l.play()
+	if l.players.IsFull() {
+		l.setState(stateStarting)
 	}
 	return nil
 }
 
+// start fixes the rules and seats for the game that is about to begin.
+func (l *Lobby) start() {
+	l.active = l.rules
+	l.result.Started = l.clock.Now()
+	l.result.GameID = newGameID()
+	for i := 0; i < config.MaxPlayers; i++ {
+		p := l.players.At(i)
+		l.result.Seats[i] = playerInfo(p)
+	}
+	for _, f := range l.onStart {
+		f(l.result)
+	}
+	l.setState(statePlaying)
+}
+
+// stop reports the result of the game, then removes or hands back the
+// players and readies the Lobby for the next game.
 func (l *Lobby) stop() {
-	l.playing = false
-	l.logger.Info("lobby ", l.id, " stopping...")
-	// TODO - gracefully shut down & tell any connected players l shut down
-	// maybe add option to rematch
-	for i := 0; i < l.players.Size(); i++ {
+	if l.result.Forfeit < 0 {
+		l.result.Winner = l.seriesWinner()
+	}
+	l.result.Ended = l.clock.Now()
+	result := l.result
+	for i := 0; i < config.MaxPlayers; i++ {
 		p := l.players.At(i)
-		l.removePlayer(p, "lobby stopping")
+		if p == nil {
+			continue
+		}
+		if l.keepPlayers {
+			result.Players[i] = p
+			l.players.Remove(i)
+		} else {
+			l.removePlayer(p, "lobby stopping")
+		}
 	}
-	l.logger.Info("lobby ", l.id, " resetting...")
 	l.reset()
+
+	for _, f := range l.onGameOver {
+		f(result)
+	}
+
+	if l.closing {
+		l.setState(stateClosed)
+	} else {
+		l.setState(stateWaiting)
+	}
+}
+
+// newGameID returns a random ID for a Result.
+func newGameID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
 }
 
 func (l *Lobby) reset() {
 	l.board = game.New()
 	l.currentPlayer = -1
+	l.ending = ""
+	l.result = Result{LobbyID: l.id, Winner: -1, Forfeit: -1}
+}
+
+// forfeit removes p from the game, making their opponent the winner.
+func (l *Lobby) forfeit(p *player.Player, why string) {
+	l.result.Forfeit = p.ID
+	for i := 0; i < config.MaxPlayers; i++ {
+		if opp := l.players.At(i); opp != nil && opp != p {
+			l.result.Winner = opp.ID
+		}
+	}
+	l.removePlayer(p, why)
 }
 
-// maxTurnAttempts is the number of tries a player can have at making
-// a valid turn before they are disconnected.
-const maxTurnAttempts = 3
+// DefaultMaxTurnAttempts is the number of tries a player can have at making
+// a valid turn before they are disconnected, unless changed with UseMaxTurnAttempts.
+const DefaultMaxTurnAttempts = 3
 
+// play plays the series of games, then moves the Lobby to post-game.
 func (l *Lobby) play() {
-	l.playing = true
-	l.logger.Info("lobby ", l.id, " playing")
+	defer l.setState(statePostGame)
 
-	l.identifyPlayers()
+	for n := 0; n < l.active.SeriesLength && !l.seriesDecided(n); n++ {
+		l.newGame(n)
+		l.identifyPlayers()
+		if l.active.SendState {
+			l.broadcast(protocol.NewState(l.board).String())
+		}
+		if !l.playGame() {
+			// a player was removed, which ends the series
+			l.recordGame(false)
+			return
+		}
+		l.scoreGame()
+		l.notifyWinner()
+		if l.active.SeriesLength > 1 {
+			l.notifyScore()
+		}
+		l.answerRequests()
+	}
+	if l.active.SeriesLength > 1 {
+		l.notifySeriesOver()
+	}
+}
 
+// newGame clears the board and assigns tokens for a game in the series.
+// Players alternate playing as X, who always moves first.
+func (l *Lobby) newGame(n int) {
+	l.board = game.New()
+	for i := 0; i < config.MaxPlayers; i++ {
+		if p := l.players.At(i); p != nil {
+			p.Token = tokens.FromIndex((p.ID + n) % config.MaxPlayers)
+		}
+	}
+	first := n % config.MaxPlayers
+	l.currentPlayer = (first + config.MaxPlayers - 1) % config.MaxPlayers
+	l.game = Game{Winner: tokens.Empty, Started: l.clock.Now()}
+}
+
+// recordGame adds the game in progress to the Result.
+func (l *Lobby) recordGame(finished bool) {
+	l.game.Finished = finished
+	l.game.Winner = l.board.WinningToken()
+	l.game.Board = l.board.Grid()
+	l.game.Ended = l.clock.Now()
+	l.result.Games = append(l.result.Games, l.game)
+}
+
+// playGame runs turns until the game is over. It returns false if a
+// player was removed before the game could finish.
+func (l *Lobby) playGame() bool {
 	// continue until game is over
-	for !l.board.HasWinner() && l.playing {
+	for !l.board.HasWinner() && !l.board.IsFull() {
 		p := l.nextPlayer()
+		opp := l.opponent(p)
+		if slow := l.slowPlayer(); slow != nil {
+			l.dropSlow(slow)
+			return false
+		}
 
 		// First, notify player that it's their turn
 		msg := protocol.TurnNotif{Token: p.Token}
-		p.Send <- msg.String()
+		l.send(p, msg.String())
 
 		// Wait for and validate their response. Give them a few tries.
+		var timer clock.Timer
+		var timeout <-chan time.Time
+		if l.active.TurnTimeout > 0 {
+			timer = l.clock.NewTimer(l.active.TurnTimeout + p.RTT())
+			timeout = timer.C()
+		}
 		var attempts = 0
-		for ; attempts < maxTurnAttempts; attempts++ {
-			s, ok := <-p.Receive
+		for ; attempts < l.active.MaxTurnAttempts; attempts++ {
+			s, ok := l.nextMove(p, opp, timeout)
 			if !ok {
-				l.logger.Error("lobby ", l.id, " could not receive move from ", p.Token, ": channel closed")
-				l.removePlayer(p, "disconnected")
-				l.stop()
-				return
+				return false
 			}
 
 			msg, err := protocol.ParseTurnInfo(s)
 			var parseError *protocol.ParseError
 			if err != nil {
-				l.logger.Info("lobby ", l.id, " error in move from ", p.Token, ": ", err)
+				l.logger.Info("could not parse move", "player", p.ID, "token", p.Token, "error", err)
 				if errors.As(err, &parseError) {
-					p.Send <- parseError.AsResponse()
+					l.send(p, parseError.AsResponse())
+					l.invalidMove(p, parseError)
 				} else {
-					p.Send <- protocol.InternalError.Error()
+					l.send(p, protocol.InternalError.Error())
+					l.invalidMove(p, protocol.InternalError)
 				}
 				continue
 			}
 
-			l.logger.Info("lobby ", l.id, " ", msg)
+			l.logger.Debug("received move", "player", p.ID, "move", msg.String())
 
 			turn := msg.(protocol.TurnInfo)
 			if turn.Token != p.Token {
 				// p trying to move as opponent
-				l.logger.Info("lobby ", l.id, " turn from", p.Token, " did not match turn token ", turn.Token)
+				l.logger.Info("move token does not match player", "player", p.ID, "token", p.Token, "move_token", turn.Token)
 
-				p.Send <- protocol.TokenError.Error()
+				l.send(p, protocol.TokenError.Error())
+				l.invalidMove(p, protocol.TokenError)
 				continue
 			}
 
 			// attempt move
 			turnOk, err := l.board.Put(turn.Token, turn.Row, turn.Col)
 			if err != nil {
-				l.logger.Info("lobby ", l.id, " : ", err)
+				l.logger.Info("invalid move", "player", p.ID, "token", p.Token, "error", err)
 				switch err.(type) {
 				case *game.TokenError:
-					p.Send <- protocol.TokenError.Error()
+					l.send(p, protocol.TokenError.Error())
+					l.invalidMove(p, protocol.TokenError)
 				case *game.RangeError:
-					p.Send <- protocol.RangeError.Error()
+					l.send(p, protocol.RangeError.Error())
+					l.invalidMove(p, protocol.RangeError)
 				}
 				continue
 			}
 			if !turnOk {
-				l.logger.Info("lobby ", l.id, " turn from", turn.Token, " did not change board")
-				p.Send <- protocol.SpaceTakenError.Error()
+				l.logger.Info("move did not change the board", "player", p.ID, "token", turn.Token)
+				l.send(p, protocol.SpaceTakenError.Error())
+				l.invalidMove(p, protocol.SpaceTakenError)
 				continue
 			} else {
+				l.game.Moves = append(l.game.Moves, turn)
+				l.moved(p, turn)
 				l.notifyTurnTaken(turn)
 				break
 			}
 		}
 
-		if attempts == maxTurnAttempts {
+		if timer != nil {
+			timer.Stop()
+		}
+		if attempts == l.active.MaxTurnAttempts {
 			// assume p was trying to cheat and remove them
-			l.removePlayer(p, "too many invalid moves")
-			// stop game and return, no winner
-			l.stop()
+			l.forfeit(p, "too many invalid moves")
+			return false
+		}
+	}
+	return true
+}
+
+// nextMove waits for a message from p, running commands and answering
+// requests from either player in the meantime. It returns false if the game
+// can't go on, after removing the player at fault or telling the players why
+// the Lobby was ended.
+func (l *Lobby) nextMove(p, opp *player.Player, timeout <-chan time.Time) (string, bool) {
+	pRequests, oppRequests := p.Requests, opp.Requests
+	for {
+		select {
+		case req, ok := <-pRequests:
+			if !ok {
+				// p's Receive is closed too, which ends their turn
+				pRequests = nil
+				continue
+			}
+			l.request(p, req)
+		case req, ok := <-oppRequests:
+			if !ok {
+				oppRequests = nil
+				continue
+			}
+			l.request(opp, req)
+		case s, ok := <-p.Receive:
+			if !ok {
+				l.logger.Error("could not receive move: channel closed", "player", p.ID, "token", p.Token)
+				l.forfeit(p, "disconnected")
+				return "", false
+			}
+			l.line(p, false, s)
+			return s, true
+		case cmd := <-l.cmds:
+			cmd()
+			if l.ending != "" {
+				l.logger.Info("lobby ended", "reason", l.ending)
+				l.broadcast(protocol.ServerMessage{Text: l.ending}.String())
+				return "", false
+			}
+		case <-timeout:
+			l.logger.Info("turn timed out", "player", p.ID, "token", p.Token)
+			l.forfeit(p, "turn timed out")
+			return "", false
+		case <-p.Slow():
+			l.dropSlow(p)
+			return "", false
+		case <-opp.Slow():
+			l.dropSlow(opp)
+			return "", false
+		}
+	}
+}
+
+// request answers a request from p: a CHAT is relayed to every other
+// player and a SYNC is answered with the State of the board. If the server
+// turned the request down, p is sent the reason instead.
+func (l *Lobby) request(p *player.Player, req player.Request) {
+	l.line(p, false, req.Line)
+	if req.Err != nil {
+		l.send(p, req.Err.Error())
+		return
+	}
+	switch protocol.ParseOp(req.Line) {
+	case protocol.Chat{}.Op():
+		notif := protocol.ChatNotif{From: p.Token, Text: req.Text}.String()
+		for i := 0; i < config.MaxPlayers; i++ {
+			if other := l.players.At(i); other != nil && other != p {
+				l.send(other, notif)
+			}
+		}
+	case protocol.ParseOp(protocol.SyncRequest):
+		l.send(p, protocol.NewState(l.board).String())
+	}
+}
+
+// answerRequests answers the requests the players made since their last
+// move, without waiting for more.
+func (l *Lobby) answerRequests() {
+	for i := 0; i < config.MaxPlayers; i++ {
+		p := l.players.At(i)
+		if p == nil {
+			continue
+		}
+		for pending := true; pending; {
+			select {
+			case req, ok := <-p.Requests:
+				if ok {
+					l.request(p, req)
+				}
+				pending = ok
+			default:
+				pending = false
+			}
+		}
+	}
+}
+
+// scoreGame adds the result of the finished game to the series score.
+func (l *Lobby) scoreGame() {
+	l.recordGame(true)
+	for i := 0; i < config.MaxPlayers; i++ {
+		if p := l.players.At(i); p != nil && p.Token == l.board.WinningToken() {
+			l.result.Wins[p.ID]++
+			l.logger.Info("game won", "game", len(l.result.Games), "player", p.ID)
 			return
 		}
 	}
-	// notify players of winner and shut down
-	l.notifyWinner()
-	l.stop()
+	l.result.Draws++
+	l.logger.Info("game drawn", "game", len(l.result.Games))
+}
+
+// seriesDecided returns true if a player has won more games than could
+// be made up with the games remaining in the series.
+func (l *Lobby) seriesDecided(played int) bool {
+	remaining := l.active.SeriesLength - played
+	lead := l.result.Wins[0] - l.result.Wins[1]
+	if lead < 0 {
+		lead = -lead
+	}
+	return lead > remaining
+}
+
+// seriesWinner returns the ID of the player with the most wins, or -1 if tied.
+func (l *Lobby) seriesWinner() int {
+	switch {
+	case l.result.Wins[0] > l.result.Wins[1]:
+		return 0
+	case l.result.Wins[1] > l.result.Wins[0]:
+		return 1
+	default:
+		return -1
+	}
 }
 
 func (l *Lobby) identifyPlayers() {
@@ -196,7 +910,7 @@ func (l *Lobby) identifyPlayers() {
 	for i := 0; i < l.players.Size(); i++ {
 		p := l.players.At(i)
 		msg := protocol.PlayerToken{Token: p.Token}
-		p.Send <- msg.String()
+		l.send(p, msg.String())
 		//if err != nil {
 		//	l.removePlayer(p, "could not write identity")
 		//	l.stop()
@@ -209,7 +923,41 @@ func (l *Lobby) notifyWinner() {
 	for i := 0; i < l.players.Size(); i++ {
 		p := l.players.At(i)
 		msg := protocol.GameOver{WinningToken: l.board.WinningToken()}
-		p.Send <- msg.String()
+		l.send(p, msg.String())
+	}
+}
+
+func (l *Lobby) notifyScore() {
+	// Tell each player the running score from their point of view.
+	for i := 0; i < config.MaxPlayers; i++ {
+		p := l.players.At(i)
+		msg := protocol.SeriesScore{
+			Game:   len(l.result.Games),
+			Games:  l.active.SeriesLength,
+			Wins:   l.result.Wins[p.ID],
+			Losses: l.result.Wins[1-p.ID],
+			Draws:  l.result.Draws,
+		}
+		l.send(p, msg.String())
+	}
+}
+
+func (l *Lobby) notifySeriesOver() {
+	winner := l.seriesWinner()
+	for i := 0; i < config.MaxPlayers; i++ {
+		p := l.players.At(i)
+		msg := protocol.SeriesOver{
+			Outcome: protocol.SeriesDraw,
+			Wins:    l.result.Wins[p.ID],
+			Losses:  l.result.Wins[1-p.ID],
+			Draws:   l.result.Draws,
+		}
+		if winner == p.ID {
+			msg.Outcome = protocol.SeriesWin
+		} else if winner >= 0 {
+			msg.Outcome = protocol.SeriesLoss
+		}
+		l.send(p, msg.String())
 	}
 }
 
@@ -218,7 +966,7 @@ func (l *Lobby) notifyTurnTaken(turn protocol.TurnInfo) {
 	for i := 0; i < l.players.Size(); i++ {
 		p := l.players.At(i)
 		if p.Token != turn.Token {
-			p.Send <- turn.String()
+			l.send(p, turn.String())
 		}
 	}
 }
@@ -229,12 +977,54 @@ func (l *Lobby) nextPlayer() *player.Player {
 	return l.players.At(nextID)
 }
 
+// opponent returns the other player in the Lobby, or nil if there is none.
+func (l *Lobby) opponent(p *player.Player) *player.Player {
+	for i := 0; i < config.MaxPlayers; i++ {
+		if opp := l.players.At(i); opp != nil && opp != p {
+			return opp
+		}
+	}
+	return nil
+}
+
+// slowPlayer returns a player in the Lobby that was marked slow, or nil.
+func (l *Lobby) slowPlayer() *player.Player {
+	for i := 0; i < config.MaxPlayers; i++ {
+		if p := l.players.At(i); p != nil && p.IsSlow() {
+			return p
+		}
+	}
+	return nil
+}
+
+// send queues msg for p without blocking the Lobby, so one player's slow
+// connection can't stall the other. A player that can't keep up is marked
+// slow and removed before the next move.
+func (l *Lobby) send(p *player.Player, msg string) {
+	if !p.Deliver(msg) {
+		l.logger.Info("could not queue message: connection too slow", "player", p.ID)
+		return
+	}
+	l.line(p, true, msg)
+}
+
+// dropSlow forfeits p because their connection couldn't keep up, and tells
+// their opponent why the game ended.
+func (l *Lobby) dropSlow(p *player.Player) {
+	if opp := l.opponent(p); opp != nil {
+		l.send(opp, protocol.ServerMessage{Text: "opponent disconnected: connection too slow"}.String())
+	}
+	l.forfeit(p, "connection too slow")
+}
+
 func (l *Lobby) removePlayer(p *player.Player, why string) {
 	if p == nil {
 		return
 	}
-	l.logger.Info("lobby ", l.id, " removing player ", p.ID, ", ", why, "\n")
-	p.Send <- "REMOVED\n"
+	l.logger.Info("removing player", "player", p.ID, "reason", why)
+	if p.Deliver(protocol.Removed) {
+		l.line(p, true, protocol.Removed)
+	}
 	close(p.Send)
 	l.players.Remove(p.ID)
 }
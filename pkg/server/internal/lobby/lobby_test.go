@@ -0,0 +1,302 @@
+package lobby
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeremyt135/tictactoe/pkg/clock"
+	"github.com/jeremyt135/tictactoe/pkg/protocol"
+	"github.com/jeremyt135/tictactoe/pkg/server/internal/player"
+	"github.com/jeremyt135/tictactoe/pkg/tokens"
+)
+
+// client is the other end of a Player's channels.
+type client struct {
+	send    chan string // read by the client
+	receive chan string // written by the client
+}
+
+func newClient() (*client, *player.Player) {
+	c := &client{send: make(chan string, 32), receive: make(chan string, 32)}
+	return c, player.New(c.send, c.receive)
+}
+
+// autoPlay answers every request for a move with the next cell in order,
+// until the Lobby closes the client's channel.
+func (c *client) autoPlay() {
+	next := 0
+	move := func(token string) {
+		c.receive <- protocol.TurnInfo{Token: token, Row: next / 3, Col: next % 3}.String()
+		next++
+	}
+	var token string
+	for msg := range c.send {
+		switch {
+		case strings.HasPrefix(msg, "PLAYER "):
+			next = 0
+		case strings.HasPrefix(msg, "MOVE "):
+			token = strings.TrimSpace(strings.TrimPrefix(msg, "MOVE "))
+			move(token)
+		case msg == protocol.SpaceTakenError.Error():
+			move(token)
+		}
+	}
+}
+
+func waitResult(t *testing.T, results <-chan Result) Result {
+	t.Helper()
+	select {
+	case r := <-results:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("game did not end")
+	}
+	return Result{}
+}
+
+func TestLobbyStates(t *testing.T) {
+	results := make(chan Result, 1)
+	l := New().UseMaxTurnAttempts(9).OnGameOver(func(r Result) {
+		results <- r
+	})
+	defer l.Close()
+
+	if snap := l.Snapshot(); snap.State != "waiting" || snap.Playing {
+		t.Errorf("new lobby is %s, playing=%t, expected waiting", snap.State, snap.Playing)
+	}
+
+	var clients []*client
+	for i := 0; i < 2; i++ {
+		c, p := newClient()
+		clients = append(clients, c)
+		if err := l.AddPlayer(p); err != nil {
+			t.Fatalf("AddPlayer returned error: %v", err)
+		}
+	}
+	if l.IsAvailable() {
+		t.Error("full lobby is available, expected it to be playing")
+	}
+	_, extra := newClient()
+	if err := l.AddPlayer(extra); err == nil {
+		t.Error("AddPlayer to a full lobby returned nil, expected an error")
+	}
+
+	for _, c := range clients {
+		go c.autoPlay()
+	}
+	r := waitResult(t, results)
+	if len(r.Games) != 1 || !r.Games[0].Finished {
+		t.Errorf("result has games %+v, expected one finished game", r.Games)
+	}
+	if snap := l.Snapshot(); snap.State != "waiting" || len(snap.Players) != 0 {
+		t.Errorf("lobby after game is %s with %d players, expected waiting and empty", snap.State, len(snap.Players))
+	}
+}
+
+func TestLobbyEnd(t *testing.T) {
+	results := make(chan Result, 1)
+	l := New().OnGameOver(func(r Result) {
+		results <- r
+	})
+	defer l.Close()
+
+	var clients []*client
+	for i := 0; i < 2; i++ {
+		c, p := newClient()
+		clients = append(clients, c)
+		l.AddPlayer(p)
+	}
+	// Nobody moves, so the game only ends when told to
+	l.End("maintenance")
+
+	r := waitResult(t, results)
+	if len(r.Games) != 1 || r.Games[0].Finished || r.Forfeit != -1 {
+		t.Errorf("result has games %+v and forfeit %d, expected one unfinished game", r.Games, r.Forfeit)
+	}
+	expected := protocol.ServerMessage{Text: "maintenance"}.String()
+	for _, c := range clients {
+		var told bool
+		for msg := range c.send {
+			told = told || msg == expected
+		}
+		if !told {
+			t.Errorf("player was not sent %q", expected)
+		}
+	}
+}
+
+func TestLobbyCloseWaitsForGame(t *testing.T) {
+	results := make(chan Result, 1)
+	l := New().UseMaxTurnAttempts(9).OnGameOver(func(r Result) {
+		results <- r
+	})
+
+	var clients []*client
+	for i := 0; i < 2; i++ {
+		c, p := newClient()
+		clients = append(clients, c)
+		l.AddPlayer(p)
+	}
+	l.Close()
+	select {
+	case <-l.Closed():
+		t.Fatal("lobby closed during a game, expected the game to finish first")
+	default:
+	}
+
+	for _, c := range clients {
+		go c.autoPlay()
+	}
+	if r := waitResult(t, results); len(r.Games) != 1 || !r.Games[0].Finished {
+		t.Errorf("result has games %+v, expected one finished game", r.Games)
+	}
+	select {
+	case <-l.Closed():
+	case <-time.After(time.Second):
+		t.Fatal("lobby did not close after its game")
+	}
+
+	_, p := newClient()
+	if err := l.AddPlayer(p); err == nil {
+		t.Error("AddPlayer to a closed lobby returned nil, expected an error")
+	}
+	if snap := l.Snapshot(); snap.State != "closed" {
+		t.Errorf("closed lobby is %s, expected closed", snap.State)
+	}
+}
+
+func TestLobbyTurnTimeout(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(start)
+	results := make(chan Result, 1)
+	l := New().UseClock(clk).UseTurnTimeout(30 * time.Second).OnGameOver(func(r Result) {
+		results <- r
+	})
+	defer l.Close()
+
+	for i := 0; i < 2; i++ {
+		_, p := newClient()
+		l.AddPlayer(p)
+	}
+	// Nobody moves, so the first player forfeits once their turn is over
+	clk.BlockUntil(1)
+	clk.Advance(30 * time.Second)
+
+	r := waitResult(t, results)
+	if r.Forfeit < 0 || len(r.Games) != 1 || r.Games[0].Finished {
+		t.Errorf("result has games %+v and forfeit %d, expected a forfeited game", r.Games, r.Forfeit)
+	}
+	if d := r.Ended.Sub(r.Started); d != 30*time.Second {
+		t.Errorf("game lasted %s, expected 30s", d)
+	}
+}
+
+// playCells answers every request for a move with the next of the cells
+// given for its token, starting over each game, and returns the PLAYER,
+// SCORE and SERIES lines it was sent once the Lobby closes its channel.
+func (c *client) playCells(cells map[string][]int) []string {
+	var lines []string
+	next := 0
+	for msg := range c.send {
+		switch protocol.ParseOp(msg) {
+		case protocol.PlayerToken{}.Op():
+			next = 0
+			lines = append(lines, msg)
+		case protocol.TurnNotif{}.Op():
+			token := strings.TrimSpace(strings.TrimPrefix(msg, "MOVE "))
+			cell := cells[token][next]
+			next++
+			c.receive <- protocol.TurnInfo{Token: token, Row: cell / 3, Col: cell % 3}.String()
+		case protocol.SeriesScore{}.Op(), protocol.SeriesOver{}.Op():
+			lines = append(lines, msg)
+		}
+	}
+	return lines
+}
+
+// playSeries plays a series of up to n games between two clients playing
+// cells, and returns the lines each was sent and the Result.
+func playSeries(t *testing.T, n int, cells [2]map[string][]int) ([2][]string, Result) {
+	t.Helper()
+	results := make(chan Result, 1)
+	l := New().UseSeries(n).OnGameOver(func(r Result) {
+		results <- r
+	})
+	defer l.Close()
+
+	var lines [2]chan []string
+	for i := range cells {
+		c, p := newClient()
+		if err := l.AddPlayer(p); err != nil {
+			t.Fatalf("AddPlayer returned error: %v", err)
+		}
+		lines[i] = make(chan []string, 1)
+		go func(i int) {
+			lines[i] <- c.playCells(cells[i])
+		}(i)
+	}
+	r := waitResult(t, results)
+	return [2][]string{<-lines[0], <-lines[1]}, r
+}
+
+func checkLines(t *testing.T, player int, got []string, want []protocol.Command) {
+	t.Helper()
+	var expected []string
+	for _, cmd := range want {
+		expected = append(expected, cmd.String())
+	}
+	if strings.Join(got, "") != strings.Join(expected, "") {
+		t.Errorf("player %d was sent %q, expected %q", player, got, expected)
+	}
+}
+
+func TestLobbySeriesAlternatesX(t *testing.T) {
+	// Whoever is X takes the top row, so each player wins one game
+	xWins := map[string][]int{tokens.X: {0, 1, 2}, tokens.O: {3, 4}}
+	lines, r := playSeries(t, 2, [2]map[string][]int{xWins, xWins})
+
+	if len(r.Games) != 2 || r.Winner != -1 || r.Draws != 0 {
+		t.Errorf("result has %d games, winner %d and %d draws, expected 2 games and no winner", len(r.Games), r.Winner, r.Draws)
+	}
+	checkLines(t, 0, lines[0], []protocol.Command{
+		protocol.PlayerToken{Token: tokens.X},
+		protocol.SeriesScore{Game: 1, Games: 2, Wins: 1},
+		protocol.PlayerToken{Token: tokens.O},
+		protocol.SeriesScore{Game: 2, Games: 2, Wins: 1, Losses: 1},
+		protocol.SeriesOver{Outcome: protocol.SeriesDraw, Wins: 1, Losses: 1},
+	})
+	checkLines(t, 1, lines[1], []protocol.Command{
+		protocol.PlayerToken{Token: tokens.O},
+		protocol.SeriesScore{Game: 1, Games: 2, Losses: 1},
+		protocol.PlayerToken{Token: tokens.X},
+		protocol.SeriesScore{Game: 2, Games: 2, Wins: 1, Losses: 1},
+		protocol.SeriesOver{Outcome: protocol.SeriesDraw, Wins: 1, Losses: 1},
+	})
+}
+
+func TestLobbySeriesEndsOnceDecided(t *testing.T) {
+	// Player 0 takes the top row whichever token they have
+	winner := map[string][]int{tokens.X: {0, 1, 2}, tokens.O: {0, 1, 2}}
+	loser := map[string][]int{tokens.X: {3, 4, 8}, tokens.O: {3, 4, 8}}
+	lines, r := playSeries(t, 3, [2]map[string][]int{winner, loser})
+
+	// After 2-0 the third game can't change the outcome
+	if len(r.Games) != 2 || r.Winner != 0 || r.Wins[0] != 2 {
+		t.Errorf("result has %d games, winner %d and wins %v, expected player 0 to win 2 games", len(r.Games), r.Winner, r.Wins)
+	}
+	checkLines(t, 0, lines[0], []protocol.Command{
+		protocol.PlayerToken{Token: tokens.X},
+		protocol.SeriesScore{Game: 1, Games: 3, Wins: 1},
+		protocol.PlayerToken{Token: tokens.O},
+		protocol.SeriesScore{Game: 2, Games: 3, Wins: 2},
+		protocol.SeriesOver{Outcome: protocol.SeriesWin, Wins: 2},
+	})
+	checkLines(t, 1, lines[1], []protocol.Command{
+		protocol.PlayerToken{Token: tokens.O},
+		protocol.SeriesScore{Game: 1, Games: 3, Losses: 1},
+		protocol.PlayerToken{Token: tokens.X},
+		protocol.SeriesScore{Game: 2, Games: 3, Losses: 2},
+		protocol.SeriesOver{Outcome: protocol.SeriesLoss, Losses: 2},
+	})
+}
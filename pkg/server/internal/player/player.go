@@ -1,14 +1,98 @@
 package player
 
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
 // Player keeps a record of a connection and its identity.
 type Player struct {
-	Token   string
-	ID      int
+	// rtt is the round-trip time of the last heartbeat. It comes first so
+	// it is 64-bit aligned for atomic access.
+	rtt int64
+
+	Token  string
+	ID     int
+	Name   string
+	ConnID int
+
+	// Identity is the common name of the player's verified TLS
+	// certificate, or "" if they did not present one.
+	Identity string
+
 	Send    chan<- string
 	Receive <-chan string
+
+	// Requests, if not nil, carries the player's lines that aren't moves,
+	// such as CHAT and SYNC. They are kept off Receive so they can be
+	// answered while it isn't the player's turn.
+	Requests <-chan Request
+
+	slow     chan struct{}
+	slowOnce sync.Once
+}
+
+// Request is a line from a player that isn't a move, checked by the server.
+type Request struct {
+	// Line is the line as the player sent it.
+	Line string
+
+	// Text is the text of a CHAT to relay. It is empty if Err is set.
+	Text string
+
+	// Err, if not nil, is the response to send the player instead of
+	// carrying out the request.
+	Err error
 }
 
 // New returns a pointer to a Player that will use the given connection.
 func New(send chan<- string, receive <-chan string) *Player {
-	return &Player{Send: send, Receive: receive}
+	return &Player{Send: send, Receive: receive, slow: make(chan struct{})}
+}
+
+// Deliver queues msg for the player without blocking. If Send is full, the
+// player is marked slow and Deliver returns false.
+func (p *Player) Deliver(msg string) bool {
+	select {
+	case p.Send <- msg:
+		return true
+	default:
+		p.MarkSlow()
+		return false
+	}
+}
+
+// MarkSlow records that the player's connection can't keep up with the
+// messages sent to it.
+func (p *Player) MarkSlow() {
+	p.slowOnce.Do(func() {
+		close(p.slow)
+	})
+}
+
+// Slow returns a channel that is closed once the player is marked slow.
+func (p *Player) Slow() <-chan struct{} {
+	return p.slow
+}
+
+// IsSlow returns true if the player was marked slow.
+func (p *Player) IsSlow() bool {
+	select {
+	case <-p.slow:
+		return true
+	default:
+		return false
+	}
+}
+
+// SetRTT records the round-trip time of the player's last heartbeat.
+func (p *Player) SetRTT(d time.Duration) {
+	atomic.StoreInt64(&p.rtt, int64(d))
+}
+
+// RTT returns the round-trip time of the player's last heartbeat, or 0 if
+// it hasn't answered one.
+func (p *Player) RTT() time.Duration {
+	return time.Duration(atomic.LoadInt64(&p.rtt))
 }
@@ -0,0 +1,259 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/jeremyt135/tictactoe/pkg/protocol"
+)
+
+// LimitOptions protect a Server from clients that open too many connections,
+// send too many messages or can't keep up with the messages sent to them.
+// Zero values disable a limit, except for the send queue, which uses its default.
+type LimitOptions struct {
+	// MaxConnections is the number of connections the Server accepts at once.
+	MaxConnections int
+
+	// MaxConnectionsPerIP is the number of connections accepted at once from one IP address.
+	MaxConnectionsPerIP int
+
+	// HandshakesPerSecond is the rate at which one IP address can open connections,
+	// allowing bursts of up to HandshakeBurst connections.
+	HandshakesPerSecond float64
+	HandshakeBurst      int
+
+	// MessagesPerSecond is the rate at which a connection can send messages,
+	// allowing bursts of up to MessageBurst messages. Connections that send
+	// faster are disconnected.
+	MessagesPerSecond float64
+	MessageBurst      int
+
+	// SendQueueSize is the number of messages that can wait to be sent to a
+	// connection, and SendTimeout is how long a connection has to accept each
+	// one. Connections that fall further behind are disconnected.
+	SendQueueSize int
+	SendTimeout   time.Duration
+}
+
+func validateLimitOptions(opt *LimitOptions) error {
+	if opt.MaxConnections < 0 || opt.MaxConnectionsPerIP < 0 {
+		return errors.New("connection limits must not be negative")
+	}
+	if opt.HandshakesPerSecond < 0 || opt.HandshakeBurst < 0 {
+		return errors.New("handshake rate must not be negative")
+	}
+	if opt.MessagesPerSecond < 0 || opt.MessageBurst < 0 {
+		return errors.New("message rate must not be negative")
+	}
+	if opt.SendQueueSize < 0 || opt.SendTimeout < 0 {
+		return errors.New("send queue must not be negative")
+	}
+	return nil
+}
+
+// Labels for the reason a connection was rejected.
+const (
+	rejectServerFull    = "server_full"
+	rejectIPConnections = "ip_connections"
+	rejectHandshakeRate = "handshake_rate"
+	rejectMessageRate   = "message_rate"
+	rejectSlowConsumer  = "slow_consumer"
+	rejectNoHeartbeat   = "missed_heartbeats"
+)
+
+// rejectReason returns the metric label for a rejection error.
+func rejectReason(err error) string {
+	switch {
+	case errors.Is(err, protocol.ServerFullError):
+		return rejectServerFull
+	case errors.Is(err, protocol.TooManyConnectionsError):
+		return rejectIPConnections
+	case errors.Is(err, protocol.HandshakeRateError):
+		return rejectHandshakeRate
+	default:
+		return rejectMessageRate
+	}
+}
+
+// tokenBucket allows events at a steady rate with bursts up to its capacity.
+// It is not safe for concurrent use.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: now}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// allow takes a token if one is available.
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// full returns true if the bucket has refilled completely, so forgetting it changes nothing.
+func (b *tokenBucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+// maxIdleBuckets is the number of handshake buckets kept before full ones are discarded.
+const maxIdleBuckets = 1024
+
+// remoteIP returns the IP address c is connected from, or "" if it is
+// unknown or c is a local connection such as a Unix socket.
+func remoteIP(c Conn) string {
+	switch addr := c.RemoteAddr().(type) {
+	case nil:
+		return ""
+	case *net.TCPAddr:
+		return addr.IP.String()
+	case *net.UnixAddr:
+		return ""
+	default:
+		host, _, err := net.SplitHostPort(addr.String())
+		if err != nil || net.ParseIP(host) == nil {
+			return ""
+		}
+		return host
+	}
+}
+
+// admitConn checks the connection limits and starts tracking c if it is
+// within them. Otherwise it returns the rejection to send to the client.
+func (s *Server) admitConn(c Conn) (*connInfo, error) {
+	limits := s.settings.get().limits
+	ip := remoteIP(c)
+	now := s.clock.Now()
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if limits.MaxConnections > 0 && len(s.conns) >= limits.MaxConnections {
+		return nil, protocol.ServerFullError
+	}
+	if ip != "" {
+		if limits.MaxConnectionsPerIP > 0 && s.connsPerIP[ip] >= limits.MaxConnectionsPerIP {
+			return nil, protocol.TooManyConnectionsError
+		}
+		if limits.HandshakesPerSecond > 0 {
+			b, ok := s.handshakes[ip]
+			if !ok {
+				s.pruneHandshakeBuckets(now)
+				b = newTokenBucket(limits.HandshakesPerSecond, limits.HandshakeBurst, now)
+				s.handshakes[ip] = b
+			}
+			if !b.allow(now) {
+				return nil, protocol.HandshakeRateError
+			}
+		}
+	}
+	return s.trackConnLocked(c, ip), nil
+}
+
+// pruneHandshakeBuckets forgets full buckets once there are many of them.
+func (s *Server) pruneHandshakeBuckets(now time.Time) {
+	if len(s.handshakes) < maxIdleBuckets {
+		return
+	}
+	for ip, b := range s.handshakes {
+		if b.full(now) {
+			delete(s.handshakes, ip)
+		}
+	}
+}
+
+// reject tells the client why its connection is being closed, then closes it.
+func (s *Server) reject(c Conn, err error) {
+	reason := rejectReason(err)
+	s.logger.Info("rejected connection", "transport", c.Transport(), "remote", remoteAddr(c), "reason", reason)
+	s.metrics.rejected.Inc(reason)
+	select {
+	case c.Send() <- err.Error():
+	default:
+	}
+	close(c.Send())
+}
+
+// limitMessages returns the channels a player uses to talk to c. If the
+// message rate is limited, messages from c are forwarded only while within
+// the limit, and c is disconnected with protocol.MessageRateError once it's
+// exceeded.
+func (s *Server) limitMessages(info *connInfo, c Conn) (chan<- string, <-chan string) {
+	limits := s.settings.get().limits
+	if limits.MessagesPerSecond <= 0 {
+		return c.Send(), c.Receive()
+	}
+
+	send := make(chan string, 10)
+	receive := make(chan string)
+	exceeded := make(chan struct{})
+	closed := make(chan struct{}) // closed once c.Send() is closed
+
+	go func() {
+		bucket := newTokenBucket(limits.MessagesPerSecond, limits.MessageBurst, s.clock.Now())
+	forward:
+		for msg := range c.Receive() {
+			if !bucket.allow(s.clock.Now()) {
+				info.logger.Info("exceeded the message rate")
+				s.metrics.rejected.Inc(rejectMessageRate)
+				close(exceeded)
+				break
+			}
+			select {
+			case receive <- msg:
+			case <-closed:
+				break forward
+			}
+		}
+		close(receive)
+		// Keep reading so the Conn is not blocked until it closes
+		drain(c.Receive())
+	}()
+
+	go func() {
+		defer close(closed)
+		for {
+			select {
+			case msg, ok := <-send:
+				if !ok {
+					close(c.Send())
+					return
+				}
+				c.Send() <- msg
+			case <-exceeded:
+				c.Send() <- protocol.MessageRateError.Error()
+				close(c.Send())
+				// The player may still send until it notices the Conn is gone
+				go drain(send)
+				return
+			}
+		}
+	}()
+	return send, receive
+}
+
+// drain reads from ch until it is closed, so its sender is never blocked.
+func drain(ch <-chan string) {
+	for range ch {
+	}
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jeremyt135/tictactoe/pkg/protocol"
+)
+
+// addrConn is a fakeConn connected from a remote address.
+type addrConn struct {
+	fakeConn
+	addr net.Addr
+}
+
+func (c addrConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func newAddrConn(ip string) addrConn {
+	return addrConn{
+		fakeConn: newFakeConn(make(chan string, 10), make(chan string, 10), nil),
+		addr:     &net.TCPAddr{IP: net.ParseIP(ip), Port: 50000},
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(1, 2, now)
+	if !b.allow(now) || !b.allow(now) {
+		t.Fatalf("bucket did not allow a burst of 2")
+	}
+	if b.allow(now) {
+		t.Errorf("bucket allowed a third event in the same instant")
+	}
+	if !b.allow(now.Add(time.Second)) {
+		t.Errorf("bucket did not refill after a second")
+	}
+}
+
+func TestAdmitConnLimits(t *testing.T) {
+	opt := DefaultOptions()
+	opt.Limits = LimitOptions{MaxConnections: 3, MaxConnectionsPerIP: 2}
+	s, _ := NewServer(opt)
+
+	for i := 0; i < 2; i++ {
+		if _, err := s.admitConn(newAddrConn("192.0.2.1")); err != nil {
+			t.Fatalf("connection %d was rejected: %v", i, err)
+		}
+	}
+	if _, err := s.admitConn(newAddrConn("192.0.2.1")); err != protocol.TooManyConnectionsError {
+		t.Errorf("third connection from one address returned %v, expected TooManyConnectionsError", err)
+	}
+	if _, err := s.admitConn(newAddrConn("192.0.2.2")); err != nil {
+		t.Errorf("connection from another address was rejected: %v", err)
+	}
+	if _, err := s.admitConn(newAddrConn("192.0.2.3")); err != protocol.ServerFullError {
+		t.Errorf("connection over the global limit returned %v, expected ServerFullError", err)
+	}
+}
+
+func TestAdmitConnHandshakeRate(t *testing.T) {
+	opt := DefaultOptions()
+	opt.Limits = LimitOptions{HandshakesPerSecond: 0.001, HandshakeBurst: 1}
+	s, _ := NewServer(opt)
+
+	if _, err := s.admitConn(newAddrConn("192.0.2.1")); err != nil {
+		t.Fatalf("first connection was rejected: %v", err)
+	}
+	if _, err := s.admitConn(newAddrConn("192.0.2.1")); err != protocol.HandshakeRateError {
+		t.Errorf("second connection returned %v, expected HandshakeRateError", err)
+	}
+}
+
+func TestLimitMessages(t *testing.T) {
+	opt := DefaultOptions()
+	opt.Limits = LimitOptions{MessagesPerSecond: 0.001, MessageBurst: 1}
+	s, _ := NewServer(opt)
+
+	c := newAddrConn("192.0.2.1")
+	info, _ := s.admitConn(c)
+	_, receive := s.limitMessages(info, c)
+
+	c.receive <- "MOVE X 0 0\n"
+	if msg := <-receive; msg != "MOVE X 0 0\n" {
+		t.Errorf("received %q, expected the first message to be forwarded", msg)
+	}
+	c.receive <- "MOVE X 0 1\n"
+	if msg, ok := <-receive; ok {
+		t.Errorf("received %q, expected receive to be closed", msg)
+	}
+	if msg := <-c.send; msg != protocol.MessageRateError.Error() {
+		t.Errorf("client was sent %q, expected %q", msg, protocol.MessageRateError.Error())
+	}
+}
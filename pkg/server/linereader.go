@@ -0,0 +1,87 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultMaxLineLength is the longest line, without its line ending, a client may send.
+const DefaultMaxLineLength = 256
+
+var (
+	errLineTooLong = errors.New("line exceeds the maximum length")
+	errMalformed   = errors.New("line is not printable UTF-8 text")
+)
+
+// lineReader reads newline terminated lines of at most max bytes. Unlike
+// bufio.Reader.ReadString it never buffers more than max bytes, and a line
+// interrupted by a temporary error is completed by the next call instead of
+// being returned in pieces.
+type lineReader struct {
+	r       *bufio.Reader
+	max     int
+	partial []byte
+}
+
+func newLineReader(r io.Reader, max int) *lineReader {
+	if max <= 0 {
+		max = DefaultMaxLineLength
+	}
+	return &lineReader{r: bufio.NewReader(r), max: max}
+}
+
+// readLine returns the next line ending in "\n", with any "\r" before the
+// "\n" removed. It returns errLineTooLong if the line is longer than the
+// maximum, after which the reader can't be used, and errMalformed if the
+// line is not valid, printable UTF-8.
+func (lr *lineReader) readLine() (string, error) {
+	for {
+		chunk, err := lr.r.ReadSlice('\n')
+		lr.partial = append(lr.partial, chunk...)
+		// Allow room for the "\r\n" line ending
+		if len(lr.partial) > lr.max+2 {
+			lr.partial = nil
+			return "", errLineTooLong
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if err != nil {
+			// Keep the partial line for the next call
+			return "", err
+		}
+
+		line := lr.partial
+		lr.partial = nil
+		msg, err := normalizeLine(line)
+		if err == nil && len(msg)-1 > lr.max {
+			return "", errLineTooLong
+		}
+		return msg, err
+	}
+}
+
+// normalizeLine checks that line is printable UTF-8 and returns it ending
+// in a single "\n". Line endings of "\n" and "\r\n" are accepted.
+func normalizeLine(line []byte) (string, error) {
+	n := len(line)
+	if n > 0 && line[n-1] == '\n' {
+		n--
+	}
+	if n > 0 && line[n-1] == '\r' {
+		n--
+	}
+	line = line[:n]
+	if !utf8.Valid(line) {
+		return "", errMalformed
+	}
+	for _, r := range string(line) {
+		if !unicode.IsPrint(r) {
+			return "", errMalformed
+		}
+	}
+	return string(line) + "\n", nil
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLineReader(t *testing.T) {
+	cases := []struct {
+		input string
+		msg   string
+		err   error
+	}{
+		{"MOVE 1 1\n", "MOVE 1 1\n", nil},
+		{"MOVE 1 1\r\n", "MOVE 1 1\n", nil},
+		{"\n", "\n", nil},
+		{"MOVE\x001\n", "", errMalformed},
+		{"MOVE \xff\n", "", errMalformed},
+		{strings.Repeat("A", 8) + "\r\n", strings.Repeat("A", 8) + "\n", nil},
+		{strings.Repeat("A", 9) + "\n", "", errLineTooLong},
+		{strings.Repeat("A", 100), "", errLineTooLong},
+	}
+	for _, c := range cases {
+		msg, err := newLineReader(strings.NewReader(c.input), 8).readLine()
+		if msg != c.msg || !errors.Is(err, c.err) {
+			t.Errorf("readLine() on %q = %q, %v, expected %q, %v", c.input, msg, err, c.msg, c.err)
+		}
+	}
+}
+
+// timeoutError is a temporary net.Error.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// stepReader returns each of its steps from one call to Read.
+type stepReader struct {
+	steps []interface{}
+}
+
+func (r *stepReader) Read(b []byte) (int, error) {
+	if len(r.steps) == 0 {
+		return 0, io.EOF
+	}
+	step := r.steps[0]
+	r.steps = r.steps[1:]
+	if err, ok := step.(error); ok {
+		return 0, err
+	}
+	return copy(b, step.(string)), nil
+}
+
+func TestLineReaderKeepsPartialLine(t *testing.T) {
+	r := newLineReader(&stepReader{steps: []interface{}{"MOVE ", timeoutError{}, "1 1\nQUIT\n"}}, 0)
+
+	if msg, err := r.readLine(); !isTemporary(err) || msg != "" {
+		t.Fatalf("readLine() = %q, %v, expected a temporary error", msg, err)
+	}
+	for _, expected := range []string{"MOVE 1 1\n", "QUIT\n"} {
+		if msg, err := r.readLine(); err != nil || msg != expected {
+			t.Fatalf("readLine() = %q, %v, expected %q", msg, err, expected)
+		}
+	}
+	if _, err := r.readLine(); !errors.Is(err, io.EOF) {
+		t.Errorf("readLine() returned %v at end of input, expected io.EOF", err)
+	}
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/jeremyt135/tictactoe/pkg/metrics"
+	"github.com/jeremyt135/tictactoe/pkg/protocol"
+	"github.com/jeremyt135/tictactoe/pkg/server/internal/lobby"
+)
+
+// Labels for the outcome of a handshake.
+const (
+	handshakeOK      = "ok"
+	handshakeTimeout = "timeout"
+	handshakeClosed  = "closed"
+	handshakeInvalid = "invalid"
+)
+
+// serverMetrics holds the metrics a Server exposes at /metrics.
+type serverMetrics struct {
+	registry      *metrics.Registry
+	accepted      *metrics.Counter
+	rejected      *metrics.CounterVec
+	handshakes    *metrics.CounterVec
+	invalidMoves  *metrics.CounterVec
+	gameDuration  *metrics.Histogram
+	gameMoves     *metrics.Histogram
+	eventsDropped *metrics.Counter
+	heartbeatRTT  *metrics.Histogram
+}
+
+func newServerMetrics(s *Server) *serverMetrics {
+	r := metrics.NewRegistry()
+	m := &serverMetrics{
+		registry: r,
+		accepted: r.NewCounter("tictactoe_connections_accepted_total",
+			"Connections received from listeners."),
+		rejected: r.NewCounterVec("tictactoe_connections_rejected_total",
+			"Connections closed for exceeding a limit, by reason.", "reason"),
+		handshakes: r.NewCounterVec("tictactoe_handshakes_total",
+			"Handshakes by outcome.", "result"),
+		invalidMoves: r.NewCounterVec("tictactoe_invalid_moves_total",
+			"Moves rejected by lobbies, by the type of error sent to the player.", "error"),
+		gameDuration: r.NewHistogram("tictactoe_game_duration_seconds",
+			"Duration of finished games.", []float64{5, 15, 30, 60, 120, 300, 600}),
+		gameMoves: r.NewHistogram("tictactoe_game_moves",
+			"Number of moves in finished games.", []float64{5, 6, 7, 8, 9}),
+		eventsDropped: r.NewCounter("tictactoe_events_dropped_total",
+			"Events not delivered to Options.Events because too many were waiting."),
+		heartbeatRTT: r.NewHistogram("tictactoe_heartbeat_rtt_seconds",
+			"Round-trip time of answered heartbeats.", []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}),
+	}
+
+	r.NewGaugeFunc("tictactoe_lobbies", "Lobbies by state.", func() map[string]float64 {
+		states := map[string]float64{"empty": 0, "waiting": 0, "playing": 0}
+		for _, l := range s.allLobbies() {
+			snap := l.Snapshot()
+			switch {
+			case snap.Playing:
+				states["playing"]++
+			case len(snap.Players) > 0:
+				states["waiting"]++
+			default:
+				states["empty"]++
+			}
+		}
+		return states
+	}, "state")
+	r.NewGaugeFunc("tictactoe_lobby_players", "Players seated in lobbies.", func() map[string]float64 {
+		var players int
+		for _, l := range s.allLobbies() {
+			players += len(l.Snapshot().Players)
+		}
+		return map[string]float64{"": float64(players)}
+	})
+	r.NewGaugeFunc("tictactoe_games_active", "Games in progress.", func() map[string]float64 {
+		var playing int
+		for _, l := range s.allLobbies() {
+			if l.IsPlaying() {
+				playing++
+			}
+		}
+		return map[string]float64{"": float64(playing)}
+	})
+	r.NewGaugeFunc("tictactoe_send_queue_depth", "Messages waiting to be sent to clients, by transport.",
+		func() map[string]float64 {
+			depth := make(map[string]float64)
+			s.mux.Lock()
+			defer s.mux.Unlock()
+			for _, info := range s.conns {
+				depth[info.conn.Transport()] += float64(len(info.queue) + len(info.conn.Send()))
+			}
+			return depth
+		}, "transport")
+	return m
+}
+
+// recordResult observes the duration and number of moves of each finished game.
+func (m *serverMetrics) recordResult(r lobby.Result) {
+	for _, g := range r.Games {
+		if !g.Finished {
+			continue
+		}
+		m.gameDuration.Observe(g.Ended.Sub(g.Started).Seconds())
+		m.gameMoves.Observe(float64(len(g.Moves)))
+	}
+}
+
+// recordInvalidMove counts a rejected move by the error sent to the player.
+func (m *serverMetrics) recordInvalidMove(mv lobby.InvalidMove) {
+	err := mv.Err
+	var parseError *protocol.ParseError
+	switch {
+	case errors.As(err, &parseError):
+		m.invalidMoves.Inc("ParseError")
+	case errors.Is(err, protocol.TokenError):
+		m.invalidMoves.Inc("TokenError")
+	case errors.Is(err, protocol.RangeError):
+		m.invalidMoves.Inc("RangeError")
+	case errors.Is(err, protocol.SpaceTakenError):
+		m.invalidMoves.Inc("SpaceTakenError")
+	default:
+		m.invalidMoves.Inc("InternalError")
+	}
+}
+
+// Metrics returns the registry of metrics about the Server, so
+// applications can serve it or add their own metrics to it.
+func (s *Server) Metrics() *metrics.Registry {
+	return s.metrics.registry
+}
@@ -1,17 +1,88 @@
 package server
 
 import (
+	"time"
+
+	"github.com/jeremyt135/tictactoe/pkg/clock"
 	"github.com/jeremyt135/tictactoe/pkg/logger"
 )
 
+// DefaultHandshakeTimeout is used when Options.HandshakeTimeout is zero.
+const DefaultHandshakeTimeout = time.Minute
+
 // Options hold configuration data for a server.
 type Options struct {
 	NumLobbies int
 	Logger     logger.Logger
+
+	// SeriesLength is the number of games played between the players of a
+	// lobby before they are removed. Players alternate playing as X. If zero,
+	// one game is played.
+	SeriesLength int
+
+	// HandshakeTimeout is how long a client has to answer the greeting.
+	// If zero, DefaultHandshakeTimeout is used.
+	HandshakeTimeout time.Duration
+
+	// TurnTimeout is how long a player has to make a valid move before
+	// they forfeit. If zero, players can take as long as they like.
+	TurnTimeout time.Duration
+
+	// MaxTurnAttempts is the number of invalid moves a player can make in
+	// one turn before they forfeit. If zero, lobby.DefaultMaxTurnAttempts is used.
+	MaxTurnAttempts int
+
+	// Limits protect the Server from clients that connect or send too often.
+	Limits LimitOptions
+
+	// AccessList, if not nil, can be changed from the admin console. It
+	// should be the AccessList used by the Server's listeners.
+	AccessList *AccessList
+
+	// Reload, if not nil, is called by the admin console RELOAD command. It
+	// should read the configuration again and apply it with Server.Reconfigure.
+	Reload func() (ReloadReport, error)
+
+	// Tournament, if not nil, lets clients register for a tournament
+	// in addition to playing in the lobby pool.
+	Tournament *TournamentOptions
+
+	// Clock times handshakes, sends, turns and rate limits. If nil, the
+	// system clock is used. It should be the Clock used by the Server's
+	// listeners, which time the read and write deadlines of their connections.
+	Clock clock.Clock
+
+	// Events, if not nil, are called when things happen to the Server's
+	// connections and lobbies.
+	Events *Events
+
+	// Webhooks, if not nil, are posted the result of every game.
+	Webhooks *WebhookOptions
+
+	// Transcripts, if not nil, make the Server write a transcript of every game.
+	Transcripts *TranscriptOptions
+
+	// Chat, if not nil, lets players send CHAT lines to their opponent.
+	Chat *ChatOptions
+
+	// Sync makes the Server send each player the board as a STATE line when
+	// they join a lobby and when a game starts, and in answer to a SYNC line
+	// at any time. It is off by default because clients written before
+	// STATE would not expect the line.
+	Sync bool
+
+	// Heartbeat, if not nil, makes the Server send players PING lines and
+	// disconnect those that stop answering.
+	Heartbeat *HeartbeatOptions
 }
 
 // DefaultOptions returns default Options for configuring a server.
 // The default Logger used does nothing.
 func DefaultOptions() *Options {
-	return &Options{NumLobbies: 2, Logger: logger.NoOpLogger()}
+	return &Options{
+		NumLobbies:       2,
+		SeriesLength:     1,
+		HandshakeTimeout: DefaultHandshakeTimeout,
+		Logger:           logger.NoOpLogger(),
+	}
 }
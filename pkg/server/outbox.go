@@ -0,0 +1,105 @@
+package server
+
+import (
+	"time"
+
+	"github.com/jeremyt135/tictactoe/pkg/server/internal/player"
+)
+
+// Defaults for the send queue of a connection.
+const (
+	DefaultSendQueueSize = 32
+	DefaultSendTimeout   = 10 * time.Second
+)
+
+// outbox is a Conn whose Send channel is a bounded queue in front of the Conn.
+type outbox struct {
+	Conn
+	queue chan string
+	pings chan string // heartbeats, which are never closed
+}
+
+func (o *outbox) Send() chan<- string {
+	return o.queue
+}
+
+// newPlayer creates a Player for c. Messages to the player go through a
+// queue, so the lobby never waits on a slow connection: if the queue fills
+// up, or c takes longer than the send timeout to accept a message, the
+// player is marked slow and c is disconnected.
+func (s *Server) newPlayer(info *connInfo, c Conn) *player.Player {
+	limits := s.settings.get().limits
+	size, timeout := limits.SendQueueSize, limits.SendTimeout
+	if size <= 0 {
+		size = DefaultSendQueueSize
+	}
+	if timeout <= 0 {
+		timeout = DefaultSendTimeout
+	}
+
+	out := &outbox{Conn: c, queue: make(chan string, size), pings: make(chan string, 1)}
+	s.mux.Lock()
+	info.queue = out.queue
+	s.mux.Unlock()
+	p := player.New(s.limitMessages(info, out))
+	current := s.settings.get()
+	if current.heartbeat != nil {
+		// Heartbeats find clients that have gone away, so a player can
+		// stay silent while waiting for an opponent or thinking
+		if rt, ok := c.(interface{ setReadTimeout(time.Duration) }); ok {
+			rt.setReadTimeout(0)
+		}
+		p.Receive = s.heartbeat(info, c, p, out.pings, p.Receive, *current.heartbeat)
+	}
+	if current.chat != nil || current.rules.SendState {
+		p.Receive, p.Requests = s.splitRequests(info, c, p.Receive, current.chat, current.rules.SendState)
+	}
+	p.ConnID = info.id
+	p.Identity = info.identity
+	go s.deliver(info, c, out, p, timeout)
+	return p
+}
+
+// deliver forwards messages from the queue and pings of out to c until the
+// queue is closed, then closes c.Send().
+func (s *Server) deliver(info *connInfo, c Conn, out *outbox, p *player.Player, timeout time.Duration) {
+	defer close(c.Send())
+
+	for {
+		var msg string
+		select {
+		case m, ok := <-out.queue:
+			if !ok {
+				return
+			}
+			msg = m
+		case msg = <-out.pings:
+		}
+
+		select {
+		case c.Send() <- msg:
+			continue
+		default:
+		}
+
+		// Only time the sends that have to wait
+		timer := s.clock.NewTimer(timeout)
+		select {
+		case c.Send() <- msg:
+			timer.Stop()
+			continue
+		case <-p.Slow():
+			info.logger.Info("could not keep up with its send queue")
+		case <-timer.C():
+			info.logger.Info("did not accept a message in time", "timeout", timeout)
+		}
+		timer.Stop()
+
+		s.metrics.rejected.Inc(rejectSlowConsumer)
+		p.MarkSlow()
+		c.Close(ReasonSlowConsumer)
+		// The player may still be sent messages until the lobby removes it
+		drain(out.queue)
+		return
+	}
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeremyt135/tictactoe/pkg/clock"
+	"github.com/jeremyt135/tictactoe/pkg/logger"
+	"github.com/jeremyt135/tictactoe/pkg/protocol"
+)
+
+func TestNewPlayerSendQueueFull(t *testing.T) {
+	s, _ := NewServer(&Options{NumLobbies: 1, Limits: LimitOptions{SendQueueSize: 2, SendTimeout: time.Minute}})
+	c := newFakeConn(make(chan string), make(chan string), nil)
+	p := s.newPlayer(&connInfo{id: 1, logger: logger.NoOpLogger()}, c)
+
+	// One message is held by the forwarder and two wait in the queue
+	queued := 0
+	for i := 0; i < 4 && p.Deliver("MESSAGE hello\n"); i++ {
+		queued++
+	}
+	if queued > 3 {
+		t.Errorf("queued %d messages, expected at most 3", queued)
+	}
+	select {
+	case <-p.Slow():
+	case <-time.After(time.Second):
+		t.Fatal("player was not marked slow after its queue filled")
+	}
+
+	close(p.Send)
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-c.send:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("Send channel of slow conn was not closed")
+		}
+	}
+}
+
+func TestNewPlayerSendTimeout(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	s, _ := NewServer(&Options{NumLobbies: 1, Clock: clk, Limits: LimitOptions{SendTimeout: time.Minute}})
+	c := newFakeConn(make(chan string), make(chan string), nil)
+	p := s.newPlayer(&connInfo{id: 1, logger: logger.NoOpLogger()}, c)
+
+	// Nothing reads from c, so the message waits until the send times out
+	p.Deliver("MESSAGE hello\n")
+	clk.BlockUntil(1)
+	clk.Advance(time.Minute)
+
+	if err := waitDone(t, c); err.Reason != ReasonSlowConsumer {
+		t.Errorf("conn closed with reason %q, expected %q", err.Reason, ReasonSlowConsumer)
+	}
+	if !p.IsSlow() {
+		t.Error("player was not marked slow after a send timed out")
+	}
+	close(p.Send)
+}
+
+func TestSlowConsumerOpponentIsTold(t *testing.T) {
+	s, _ := NewServer(&Options{NumLobbies: 1, Limits: LimitOptions{SendQueueSize: 8, SendTimeout: 50 * time.Millisecond}})
+	l := fakeListener{
+		ch: make(chan Conn),
+	}
+
+	told := make(chan string, 1)
+	fast := newFakeConn(make(chan string), make(chan string, 10), func(conn fakeConn) {
+		for msg := range conn.send {
+			switch {
+			case msg == protocol.Greeting:
+				conn.receive <- msg
+			case strings.HasPrefix(msg, "MOVE "):
+				token := strings.TrimSpace(strings.TrimPrefix(msg, "MOVE "))
+				conn.receive <- protocol.TurnInfo{Token: token, Row: 0, Col: 0}.String()
+			case strings.HasPrefix(msg, "MESSAGE "):
+				told <- msg
+			}
+		}
+	})
+	slow := newFakeConn(make(chan string), make(chan string), func(conn fakeConn) {
+		// Answer the greeting, then stop reading
+		conn.receive <- <-conn.send
+	})
+	l.conns = append(l.conns, fast, slow)
+
+	if err := s.Serve(l); err != nil {
+		t.Fatalf("Serve returned error: %v", err)
+	}
+
+	expected := protocol.ServerMessage{Text: "opponent disconnected: connection too slow"}.String()
+	select {
+	case msg := <-told:
+		if msg != expected {
+			t.Errorf("opponent was sent %q, expected %q", msg, expected)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("opponent of slow conn was not told it disconnected")
+	}
+}
@@ -0,0 +1,275 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jeremyt135/tictactoe/pkg/clock"
+)
+
+// PipeListener is a Listener for clients in the same process. Each call to
+// Dial creates a connection in memory and returns its client end, so an
+// application can embed a Server, or a test can play games through one,
+// without opening sockets.
+type PipeListener struct {
+	connections chan Conn
+	latency     time.Duration
+	clock       clock.Clock
+	done        chan struct{}
+	nextID      int64
+	mux         sync.RWMutex // held for writing when closing
+	closed      bool
+}
+
+// NewPipeListener creates a PipeListener.
+func NewPipeListener() *PipeListener {
+	return &PipeListener{
+		connections: make(chan Conn, 100),
+		clock:       clock.Real(),
+		done:        make(chan struct{}),
+	}
+}
+
+// UseLatency delays every message sent in either direction by d, to
+// simulate a network. Messages still arrive in the order they were sent.
+func (l *PipeListener) UseLatency(d time.Duration) *PipeListener {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.latency = d
+	return l
+}
+
+// UseClock sets the Clock that times the latency.
+func (l *PipeListener) UseClock(c clock.Clock) *PipeListener {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.clock = c
+	return l
+}
+
+// Dial connects a new client, returning the client end of the connection.
+// The server end is passed to the Server through Connections.
+func (l *PipeListener) Dial() (*PipeClient, error) {
+	l.mux.RLock()
+	defer l.mux.RUnlock()
+
+	if l.closed {
+		return nil, errors.New("could not dial: PipeListener is closed")
+	}
+	id := atomic.AddInt64(&l.nextID, 1)
+	c := newPipeConn(pipeAddr(id), l.latency, l.clock)
+	go c.poll()
+	l.connections <- c
+	return c.client, nil
+}
+
+// PollAccept waits until the PipeListener is closed. Connections are
+// accepted by Dial.
+func (l *PipeListener) PollAccept() error {
+	<-l.done
+	return nil
+}
+
+func (l *PipeListener) Connections() <-chan Conn {
+	return l.connections
+}
+
+// Close stops the PipeListener. Connections already dialed stay open.
+func (l *PipeListener) Close() error {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	close(l.done)
+	close(l.connections)
+	return nil
+}
+
+// pipeAddr is the address of a connection from a PipeListener.
+type pipeAddr int64
+
+func (a pipeAddr) Network() string {
+	return "pipe"
+}
+
+func (a pipeAddr) String() string {
+	return "pipe:" + strconv.FormatInt(int64(a), 10)
+}
+
+// pipeMessage is a message that may not be delivered before due.
+type pipeMessage struct {
+	text string
+	due  time.Time
+}
+
+// PipeConn is the server end of a connection from a PipeListener.
+type PipeConn struct {
+	*closer
+	addr     pipeAddr
+	latency  time.Duration
+	clock    clock.Clock
+	send     chan string      // channel for server to send messages to the client
+	receive  chan string      // channel for server to receive messages from the client
+	upstream chan pipeMessage // messages written by the client
+	client   *PipeClient
+}
+
+func newPipeConn(addr pipeAddr, latency time.Duration, clk clock.Clock) *PipeConn {
+	c := &PipeConn{
+		closer:   newCloser(nil),
+		addr:     addr,
+		latency:  latency,
+		clock:    clk,
+		send:     make(chan string, 10),
+		receive:  make(chan string, 10),
+		upstream: make(chan pipeMessage, 10),
+	}
+	c.client = &PipeClient{conn: c, receive: make(chan string, 10)}
+	return c
+}
+
+func (c *PipeConn) Send() chan<- string {
+	return c.send
+}
+
+func (c *PipeConn) Receive() <-chan string {
+	return c.receive
+}
+
+// RemoteAddr returns an address unique to the connection, such as "pipe:1".
+func (c *PipeConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+// Transport returns "pipe".
+func (c *PipeConn) Transport() string {
+	return "pipe"
+}
+
+func (c *PipeConn) poll() {
+	go c.pollClient()
+	go c.pollMessages()
+}
+
+// wait waits until m is due, returning false if the PipeConn is closed first.
+func (c *PipeConn) wait(m pipeMessage) bool {
+	d := m.due.Sub(c.clock.Now())
+	if d <= 0 {
+		return true
+	}
+	timer := c.clock.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+		return true
+	case <-c.Done():
+		return false
+	}
+}
+
+// pollClient forwards messages from the client to the server.
+func (c *PipeConn) pollClient() {
+	// pollClient is the only sender on c.receive, so it closes it
+	defer close(c.receive)
+
+	for {
+		select {
+		case m := <-c.upstream:
+			if !c.wait(m) {
+				return
+			}
+			select {
+			case c.receive <- m.text:
+			case <-c.Done():
+				return
+			}
+		case <-c.Done():
+			return
+		}
+	}
+}
+
+// pollMessages forwards messages from the server to the client.
+func (c *PipeConn) pollMessages() {
+	// pollMessages is the only sender on the client's channel, so it closes it
+	defer close(c.client.receive)
+
+	for {
+		select {
+		case msg, ok := <-c.send:
+			if !ok {
+				c.Close(ReasonServerClosed)
+				return
+			}
+			m := pipeMessage{text: msg, due: c.clock.Now().Add(c.latency)}
+			if !c.wait(m) {
+				// Let the server finish with the Conn
+				go drain(c.send)
+				return
+			}
+			select {
+			case c.client.receive <- m.text:
+			case <-c.Done():
+				go drain(c.send)
+				return
+			}
+		case <-c.Done():
+			go drain(c.send)
+			return
+		}
+	}
+}
+
+// PipeClient is the client end of a connection from a PipeListener.
+type PipeClient struct {
+	conn    *PipeConn
+	receive chan string
+}
+
+// Write sends msg to the server. It returns the reason the connection was
+// closed if it is no longer open.
+func (c *PipeClient) Write(msg string) error {
+	if err := c.conn.Err(); err != nil {
+		return err
+	}
+	m := pipeMessage{text: msg, due: c.conn.clock.Now().Add(c.conn.latency)}
+	select {
+	case c.conn.upstream <- m:
+		return nil
+	case <-c.conn.Done():
+		return c.conn.Err()
+	}
+}
+
+// Read returns the next message from the server. Once the connection is
+// closed and every message has been read, it returns the reason it was closed.
+func (c *PipeClient) Read() (string, error) {
+	msg, ok := <-c.receive
+	if !ok {
+		return "", c.conn.Err()
+	}
+	return msg, nil
+}
+
+// Receive returns a channel of messages from the server, which is closed
+// with the connection.
+func (c *PipeClient) Receive() <-chan string {
+	return c.receive
+}
+
+// Done returns a channel that is closed once the connection is closed.
+func (c *PipeClient) Done() <-chan struct{} {
+	return c.conn.Done()
+}
+
+// Close disconnects the client.
+func (c *PipeClient) Close() error {
+	return c.conn.Close(ReasonClientClosed)
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeremyt135/tictactoe/pkg/protocol"
+	"github.com/jeremyt135/tictactoe/pkg/tokens"
+)
+
+// playPipe answers the greeting, then plays the cells given for its token in
+// order, moving on to the next cell when a move is rejected. It returns
+// every message the server sent and the reason the connection was closed.
+func playPipe(c *PipeClient, cells map[string][]int) ([]string, error) {
+	var msgs []string
+	var token string
+	next := 0
+	for {
+		msg, err := c.Read()
+		if err != nil {
+			return msgs, err
+		}
+		msgs = append(msgs, msg)
+		switch {
+		case msg == protocol.Greeting:
+			c.Write(msg)
+		case strings.HasPrefix(msg, "PLAYER "):
+			token = strings.TrimSpace(strings.TrimPrefix(msg, "PLAYER "))
+		case strings.HasPrefix(msg, "MOVE "), strings.HasPrefix(msg, "INVALID "):
+			cell := cells[token][next]
+			next++
+			c.Write(protocol.TurnInfo{Token: token, Row: cell / 3, Col: cell % 3}.String())
+		}
+	}
+}
+
+func TestPipeListenerPlaysGame(t *testing.T) {
+	s, _ := NewServer(nil)
+	defer s.Close()
+	l := NewPipeListener().UseLatency(time.Millisecond)
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve(l)
+	}()
+
+	// X takes the top row while O plays the middle row
+	cells := map[string][]int{tokens.X: {0, 1, 2}, tokens.O: {3, 4}}
+	type outcome struct {
+		msgs []string
+		err  error
+	}
+	outcomes := make(chan outcome, 2)
+	for i := 0; i < 2; i++ {
+		c, err := l.Dial()
+		if err != nil {
+			t.Fatalf("Dial returned error: %v", err)
+		}
+		go func() {
+			msgs, err := playPipe(c, cells)
+			outcomes <- outcome{msgs, err}
+		}()
+	}
+
+	winner := protocol.GameOver{WinningToken: tokens.X}.String()
+	for i := 0; i < 2; i++ {
+		var o outcome
+		select {
+		case o = <-outcomes:
+		case <-time.After(5 * time.Second):
+			t.Fatal("game did not end within 5s")
+		}
+		var won bool
+		for _, msg := range o.msgs {
+			won = won || msg == winner
+		}
+		if !won {
+			t.Errorf("client was sent %q, expected %q", o.msgs, winner)
+		}
+		if last := o.msgs[len(o.msgs)-1]; last != protocol.Removed {
+			t.Errorf("last message was %q, expected %q", last, protocol.Removed)
+		}
+		var derr *DisconnectError
+		if !errors.As(o.err, &derr) || derr.Reason != ReasonServerClosed {
+			t.Errorf("connection closed with %v, expected %q", o.err, ReasonServerClosed)
+		}
+	}
+
+	l.Close()
+	if err := <-served; err != nil {
+		t.Errorf("Serve returned error: %v", err)
+	}
+	if _, err := l.Dial(); err == nil {
+		t.Error("Dial on closed PipeListener returned nil, expected an error")
+	}
+}
+
+func TestPipeConnLatency(t *testing.T) {
+	const latency = 50 * time.Millisecond
+	l := NewPipeListener().UseLatency(latency)
+	defer l.Close()
+	c, _ := l.Dial()
+	conn := (<-l.Connections()).(*PipeConn)
+	if conn.Transport() != "pipe" || conn.RemoteAddr().String() != "pipe:1" {
+		t.Errorf("conn has transport %q and address %v, expected pipe and pipe:1", conn.Transport(), conn.RemoteAddr())
+	}
+
+	start := time.Now()
+	c.Write("one\n")
+	c.Write("two\n")
+	for _, expected := range []string{"one\n", "two\n"} {
+		if msg := <-conn.Receive(); msg != expected {
+			t.Errorf("server received %q, expected %q", msg, expected)
+		}
+	}
+	if d := time.Since(start); d < latency {
+		t.Errorf("messages arrived after %s, expected at least %s", d, latency)
+	}
+
+	start = time.Now()
+	conn.Send() <- "three\n"
+	if msg, _ := c.Read(); msg != "three\n" {
+		t.Errorf("client read %q, expected %q", msg, "three\n")
+	}
+	if d := time.Since(start); d < latency {
+		t.Errorf("message arrived after %s, expected at least %s", d, latency)
+	}
+
+	c.Close()
+	if _, ok := <-conn.Receive(); ok {
+		t.Error("server received a message after the client closed")
+	}
+	if err := c.Write("four\n"); err == nil {
+		t.Error("Write after Close returned nil, expected an error")
+	}
+	var derr *DisconnectError
+	if !errors.As(conn.Err(), &derr) || derr.Reason != ReasonClientClosed {
+		t.Errorf("conn closed with %v, expected %q", conn.Err(), ReasonClientClosed)
+	}
+	close(conn.Send())
+}
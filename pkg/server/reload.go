@@ -0,0 +1,194 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeremyt135/tictactoe/pkg/server/internal/lobby"
+)
+
+// settingsValues are the Options that can be changed while the Server is running.
+type settingsValues struct {
+	handshakeTimeout time.Duration
+	rules            lobby.Rules
+	limits           LimitOptions
+	chat             *ChatOptions      // nil if chat is disabled
+	heartbeat        *HeartbeatOptions // nil if heartbeats are disabled
+}
+
+// settings guards the current settingsValues of a Server.
+type settings struct {
+	mux    sync.RWMutex
+	values settingsValues
+}
+
+func (st *settings) set(opt *Options) {
+	v := settingsValues{
+		handshakeTimeout: opt.HandshakeTimeout,
+		limits:           opt.Limits,
+		rules: lobby.Rules{
+			SeriesLength:    opt.SeriesLength,
+			TurnTimeout:     opt.TurnTimeout,
+			MaxTurnAttempts: opt.MaxTurnAttempts,
+			SendState:       opt.Sync,
+		},
+	}
+	if opt.Chat != nil {
+		chat := opt.Chat.withDefaults()
+		v.chat = &chat
+	}
+	if opt.Heartbeat != nil {
+		heartbeat := opt.Heartbeat.withDefaults()
+		v.heartbeat = &heartbeat
+	}
+	if v.handshakeTimeout == 0 {
+		v.handshakeTimeout = DefaultHandshakeTimeout
+	}
+	if v.rules.SeriesLength == 0 {
+		v.rules.SeriesLength = 1
+	}
+	if v.rules.MaxTurnAttempts == 0 {
+		v.rules.MaxTurnAttempts = lobby.DefaultMaxTurnAttempts
+	}
+
+	st.mux.Lock()
+	defer st.mux.Unlock()
+	st.values = v
+}
+
+func (st *settings) get() settingsValues {
+	st.mux.RLock()
+	defer st.mux.RUnlock()
+	return st.values
+}
+
+// ReloadReport lists the settings changed by reloading the configuration.
+type ReloadReport struct {
+	// Applied are settings that took effect immediately.
+	Applied []string
+
+	// RestartRequired are settings that changed but only take effect after a restart.
+	RestartRequired []string
+}
+
+// Merge appends the settings listed in other.
+func (r *ReloadReport) Merge(other ReloadReport) {
+	r.Applied = append(r.Applied, other.Applied...)
+	r.RestartRequired = append(r.RestartRequired, other.RestartRequired...)
+}
+
+// Reconfigure applies opt to the running Server. The lobby pool size, the
+// handshake and turn timeouts, the series length, the number of turn
+// attempts, the limits, chat, sync and heartbeats change immediately; games
+// in progress keep the settings they started with, and connections keep
+// their message and chat rates and heartbeats. Changes to the tournament, webhooks and transcripts are
+// reported as requiring a restart, and the Logger, Reload function, Clock
+// and Events are ignored.
+func (s *Server) Reconfigure(opt *Options) (ReloadReport, error) {
+	var report ReloadReport
+	if opt == nil {
+		return report, errors.New("could not reconfigure Server: nil Options")
+	}
+	if err := validateOptions(opt); err != nil {
+		return report, fmt.Errorf("could not reconfigure Server: %w", err)
+	}
+
+	if n := s.NumLobbies(); n != opt.NumLobbies {
+		if err := s.SetNumLobbies(opt.NumLobbies); err != nil {
+			return report, err
+		}
+		report.Applied = append(report.Applied, "lobbies")
+	}
+
+	old := s.settings.get()
+	s.settings.set(opt)
+	current := s.settings.get()
+	if old.handshakeTimeout != current.handshakeTimeout {
+		report.Applied = append(report.Applied, "handshake_timeout")
+	}
+	if old.rules.SeriesLength != current.rules.SeriesLength {
+		report.Applied = append(report.Applied, "series_length")
+	}
+	if old.rules.TurnTimeout != current.rules.TurnTimeout {
+		report.Applied = append(report.Applied, "turn_timeout")
+	}
+	if old.rules.MaxTurnAttempts != current.rules.MaxTurnAttempts {
+		report.Applied = append(report.Applied, "max_turn_attempts")
+	}
+	if old.limits != current.limits {
+		report.Applied = append(report.Applied, "limits")
+	}
+	if !reflect.DeepEqual(old.chat, current.chat) {
+		report.Applied = append(report.Applied, "chat")
+	}
+	if old.rules.SendState != current.rules.SendState {
+		report.Applied = append(report.Applied, "sync")
+	}
+	if !reflect.DeepEqual(old.heartbeat, current.heartbeat) {
+		report.Applied = append(report.Applied, "heartbeat")
+	}
+	if old.rules != current.rules {
+		for _, l := range s.allLobbies() {
+			l.UseRules(current.rules)
+		}
+	}
+
+	if s.tournamentChanged(opt.Tournament) {
+		report.RestartRequired = append(report.RestartRequired, "tournament")
+	}
+	if s.webhooksChanged(opt.Webhooks) {
+		report.RestartRequired = append(report.RestartRequired, "webhooks")
+	}
+	if s.transcriptsChanged(opt.Transcripts) {
+		report.RestartRequired = append(report.RestartRequired, "transcripts")
+	}
+	return report, nil
+}
+
+func (s *Server) transcriptsChanged(opt *TranscriptOptions) bool {
+	if s.transcripts == nil || opt == nil {
+		return (s.transcripts == nil) != (opt == nil)
+	}
+	return s.transcripts.given != *opt
+}
+
+func (s *Server) webhooksChanged(opt *WebhookOptions) bool {
+	if s.webhooks == nil || opt == nil {
+		return (s.webhooks == nil) != (opt == nil)
+	}
+	return !reflect.DeepEqual(s.webhooks.given, *opt)
+}
+
+func (s *Server) tournamentChanged(opt *TournamentOptions) bool {
+	if s.tournament == nil || opt == nil {
+		return (s.tournament == nil) != (opt == nil)
+	}
+	return s.tournament.opt != *opt
+}
+
+// runReload calls the Reload function given in Options and formats its report for the admin console.
+func (s *Server) runReload() (string, error) {
+	if s.reload == nil {
+		return "", errors.New("reloading is not configured")
+	}
+	report, err := s.reload()
+	if err != nil {
+		return "", err
+	}
+	return formatReloadReport(report), nil
+}
+
+func formatReloadReport(report ReloadReport) string {
+	var b strings.Builder
+	for _, setting := range report.Applied {
+		fmt.Fprintln(&b, "APPLIED", setting)
+	}
+	for _, setting := range report.RestartRequired {
+		fmt.Fprintln(&b, "RESTART", setting)
+	}
+	return b.String()
+}
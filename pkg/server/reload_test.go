@@ -0,0 +1,56 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeremyt135/tictactoe/pkg/tournament"
+)
+
+func TestReconfigure(t *testing.T) {
+	s, _ := NewServer(nil)
+
+	opt := DefaultOptions()
+	opt.NumLobbies = 4
+	opt.TurnTimeout = 30 * time.Second
+	opt.Tournament = &TournamentOptions{Format: tournament.RoundRobin, Participants: 4}
+	report, err := s.Reconfigure(opt)
+	if err != nil {
+		t.Fatalf("Reconfigure returned error: %v", err)
+	}
+
+	if n := s.NumLobbies(); n != 4 {
+		t.Errorf("server had %d lobbies, expected 4", n)
+	}
+	if d := s.settings.get().rules.TurnTimeout; d != 30*time.Second {
+		t.Errorf("turn timeout was %s, expected 30s", d)
+	}
+	applied := strings.Join(report.Applied, ",")
+	if applied != "lobbies,turn_timeout" {
+		t.Errorf("applied %q, expected lobbies and turn_timeout", applied)
+	}
+	if len(report.RestartRequired) != 1 || report.RestartRequired[0] != "tournament" {
+		t.Errorf("restart required for %v, expected tournament", report.RestartRequired)
+	}
+
+	opt.MaxTurnAttempts = -1
+	if _, err := s.Reconfigure(opt); err == nil {
+		t.Errorf("Reconfigure accepted invalid options")
+	}
+}
+
+func TestAdminReload(t *testing.T) {
+	s, _ := NewServer(nil)
+	if _, err := s.runAdminCommand("RELOAD", ""); err == nil {
+		t.Errorf("RELOAD succeeded without a Reload function, expected an error")
+	}
+
+	s.reload = func() (ReloadReport, error) {
+		return ReloadReport{Applied: []string{"lobbies"}, RestartRequired: []string{"listeners"}}, nil
+	}
+	out, err := s.runAdminCommand("RELOAD", "")
+	if err != nil || out != "APPLIED lobbies\nRESTART listeners\n" {
+		t.Errorf("RELOAD returned %q (err %v)", out, err)
+	}
+}
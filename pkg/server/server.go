@@ -4,9 +4,12 @@ package server
 import (
 	"errors"
 	"fmt"
+	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/jeremyt135/tictactoe/pkg/clock"
 	"github.com/jeremyt135/tictactoe/pkg/logger"
 	"github.com/jeremyt135/tictactoe/pkg/protocol"
 	"github.com/jeremyt135/tictactoe/pkg/server/internal/lobby"
@@ -17,11 +20,30 @@ import (
 type Conn interface {
 	// Send returns a channel to send the Conn data.
 	// It's expected that the server will close this channel if it
-	// is removing the Conn.
+	// is removing the Conn, which closes the Conn once the messages
+	// already sent are written.
 	Send() chan<- string
 
 	// Receive returns a channel to retrieve data from the Conn.
+	// It is closed once the client disconnects.
 	Receive() <-chan string
+
+	// Close disconnects the client right away, giving reason as the cause.
+	// Only the first call has any effect.
+	Close(reason string) error
+
+	// Done returns a channel that is closed once the Conn is closed.
+	Done() <-chan struct{}
+
+	// Err returns a *DisconnectError describing why the Conn was closed,
+	// or nil while it is open.
+	Err() error
+
+	// RemoteAddr returns the address of the client, or nil if it has none.
+	RemoteAddr() net.Addr
+
+	// Transport names the kind of connection, such as "tcp" or "ws".
+	Transport() string
 }
 
 // Listener supplies a Server with incoming connections.
@@ -35,20 +57,96 @@ type Listener interface {
 	Connections() <-chan Conn
 }
 
-// Server runs a tic-tac-toe server that accepts bare TCP connections.
+// Server runs a tic-tac-toe server that accepts connections from one or more Listeners.
 type Server struct {
-	listener Listener
-	port     int
-	lobbies  []*lobby.Lobby
-	mux      sync.Mutex
-	logger   logger.Logger
-	wg       sync.WaitGroup
+	lobbies []*lobby.Lobby
+	mux     sync.Mutex
+	logger  logger.Logger
+	clock   clock.Clock
+	wg      sync.WaitGroup
+
+	events      Events
+	eventQueue  eventQueue
+	webhooks    *webhooks
+	transcripts *transcripts
+
+	tournament *tournamentManager
+	settings   settings
+	reload     func() (ReloadReport, error)
+	access     *AccessList
+	conns      map[int]*connInfo       // guarded by mux
+	connsPerIP map[string]int          // guarded by mux
+	handshakes map[string]*tokenBucket // handshake rate per IP, guarded by mux
+	nextConnID int
+	started    time.Time
+	stats      stats
+	metrics    *serverMetrics
 }
 
+// connInfo tracks a Conn accepted by the server.
+type connInfo struct {
+	id       int
+	conn     Conn
+	ip       string
+	accepted time.Time
+	state    string
+	lobbyID  int
+	identity string        // from the client's TLS certificate, set after the handshake
+	queue    chan string   // the player's send queue in front of conn, if any
+	logger   logger.Logger // scoped to the connection
+}
+
+// States of a tracked Conn.
+const (
+	connHandshake  = "handshake"
+	connLobby      = "lobby"
+	connTournament = "tournament"
+)
+
 func validateOptions(opt *Options) error {
 	if opt.NumLobbies <= 0 {
 		return errors.New("lobbies must be positive")
 	}
+	if opt.SeriesLength < 0 {
+		return errors.New("series length must not be negative")
+	}
+	if opt.HandshakeTimeout < 0 {
+		return errors.New("handshake timeout must not be negative")
+	}
+	if opt.TurnTimeout < 0 {
+		return errors.New("turn timeout must not be negative")
+	}
+	if opt.MaxTurnAttempts < 0 {
+		return errors.New("max turn attempts must not be negative")
+	}
+	if err := validateLimitOptions(&opt.Limits); err != nil {
+		return err
+	}
+	if opt.Tournament != nil {
+		if err := validateTournamentOptions(opt.Tournament); err != nil {
+			return err
+		}
+	}
+	if opt.Webhooks != nil {
+		if err := validateWebhookOptions(opt.Webhooks); err != nil {
+			return err
+		}
+	}
+	if opt.Transcripts != nil {
+		if err := validateTranscriptOptions(opt.Transcripts); err != nil {
+			return err
+		}
+	}
+	if opt.Chat != nil {
+		if err := validateChatOptions(opt.Chat); err != nil {
+			return err
+		}
+	}
+	if opt.Heartbeat != nil {
+		if err := validateHeartbeatOptions(opt.Heartbeat); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -57,7 +155,11 @@ func validateOptions(opt *Options) error {
 // Options may be passed to configure operations such as logging.
 // If nil, default options will be used.
 func NewServer(opt *Options) (*Server, error) {
-	s := &Server{}
+	s := &Server{
+		conns:      make(map[int]*connInfo),
+		connsPerIP: make(map[string]int),
+		handshakes: make(map[string]*tokenBucket),
+	}
 
 	if opt == nil {
 		opt = DefaultOptions()
@@ -67,62 +169,101 @@ func NewServer(opt *Options) (*Server, error) {
 		}
 	}
 
-	if opt.Logger == nil {
-		s.logger = logger.NoOpLogger()
+	s.logger = orNoOp(opt.Logger)
+	if opt.Clock == nil {
+		s.clock = clock.Real()
 	} else {
-		s.logger = opt.Logger
+		s.clock = opt.Clock
+	}
+	s.started = s.clock.Now()
+	if opt.Events != nil {
+		s.events = *opt.Events
+	}
+	if opt.Webhooks != nil {
+		s.webhooks = newWebhooks(*opt.Webhooks, s.clock, s.logger)
+	}
+	if opt.Transcripts != nil {
+		t, err := newTranscripts(*opt.Transcripts, s.clock, s.logger)
+		if err != nil {
+			return nil, fmt.Errorf("could not create Server with opt: %w", err)
+		}
+		s.transcripts = t
 	}
 
+	s.settings.set(opt)
+	s.reload = opt.Reload
+	s.access = opt.AccessList
+	s.metrics = newServerMetrics(s)
 	s.lobbies = make([]*lobby.Lobby, opt.NumLobbies)
 	for i := 0; i < len(s.lobbies); i++ {
-		s.lobbies[i] = lobby.New()
+		s.lobbies[i] = s.newLobby()
+	}
+
+	if opt.Tournament != nil {
+		s.tournament = newTournamentManager(*opt.Tournament, s.newLobby, s.logger)
 	}
 
 	return s, nil
 }
 
-// Close shuts down a Server.
+// Close shuts down a Server. Lobbies stop once their games are over.
 func (s *Server) Close() {
 	s.logger.Info("shutting down")
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for _, l := range s.lobbies {
+		l.Close()
+	}
 }
 
-func (s *Server) Serve(l Listener) error {
-	if l == nil {
-		return errors.New("can not create Server with nil listener")
+// Serve accepts connections from every Listener until all of them have
+// stopped. Players from all Listeners share the same pool of lobbies, so a
+// client connected over TCP can be matched with one connected over WebSocket.
+// The first error returned by a Listener is returned once all have stopped.
+func (s *Server) Serve(listeners ...Listener) error {
+	if len(listeners) == 0 {
+		return errors.New("can not serve without listeners")
+	}
+	for _, l := range listeners {
+		if l == nil {
+			return errors.New("can not serve nil listener")
+		}
 	}
-	s.listener = l
 
-	s.wg.Add(2)
+	s.wg.Add(2 * len(listeners))
 
-	errs := make(chan error, 1)
-	go func() {
-		errs <- s.listener.PollAccept()
-		s.wg.Done()
-	}()
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l Listener) {
+			errs <- l.PollAccept()
+			s.wg.Done()
+		}(l)
 
-	go func() {
-		s.pollConnections()
-		s.wg.Done()
-	}()
+		go func(l Listener) {
+			s.pollConnections(l)
+			s.wg.Done()
+		}(l)
+	}
 
 	s.wg.Wait()
+	close(errs)
 
-	select {
-	case err := <-errs:
-		return err
-	default:
-		return nil
+	for err := range errs {
+		if err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
-func (s *Server) pollConnections() {
+func (s *Server) pollConnections(l Listener) {
 	s.logger.Info("server waiting for connections")
 
-	conns := s.listener.Connections()
+	conns := l.Connections()
 	for {
 		c, ok := <-conns
 		if !ok {
-			s.logger.Info("server.listener.Connections closed")
+			s.logger.Debug("listener connections closed")
 			break
 		}
 
@@ -133,62 +274,254 @@ func (s *Server) pollConnections() {
 		}()
 	}
 
-	s.logger.Info("server exited pollConnections")
+	s.logger.Debug("server stopped polling connections")
 }
 
 func (s *Server) handleConnection(c Conn) {
-	s.logger.Info("received connection")
+	atomic.AddInt64(&s.stats.connectionsAccepted, 1)
+	s.metrics.accepted.Inc()
+	info, err := s.admitConn(c)
+	if err != nil {
+		s.reject(c, err)
+		return
+	}
+	info.logger.Info("received connection")
+	s.connected(info)
 
-	if ok := confirmConnection(c); ok {
-		l := s.nextAvailableLobby()
+	greeted := s.clock.Now()
+	res, err := confirmConnection(c, s.settings.get().handshakeTimeout, s.clock)
+	if s.transcripts != nil && err == nil {
+		s.transcripts.handshake(info, greeted, res, s.clock.Now())
+	}
+	if err != nil {
+		info.logger.Error("handshake failed", "error", err)
+		s.handshakeFailed(err)
+		s.handshaken(info, "", "", err)
+		close(c.Send())
+		return
+	}
+	s.identify(info)
+
+	switch {
+	case res == protocol.Greeting:
+		s.metrics.handshakes.Inc(handshakeOK)
+		s.handshaken(info, HandshakePlay, "", nil)
+		p := s.newPlayer(info, c)
+		s.advertise(p)
+		l, err := s.joinLobby(info, p)
+		if err != nil {
+			info.logger.Error("could not add client to lobby", "error", err)
+			close(p.Send)
+			return
+		}
 		if l == nil {
-			s.logger.Info("received connection but could not find an open lobby")
-			close(c.Send())
+			info.logger.Info("could not find an open lobby")
+			close(p.Send)
 			return
 		}
-		if err := l.AddPlayer(player.New(c.Send(), c.Receive())); err != nil {
-			s.logger.Error("error adding a client to lobby: ", err)
+		info.logger.Info("added client to lobby", "lobby", l.ID())
+	case res == protocol.StandingsRequest && s.tournament != nil:
+		s.metrics.handshakes.Inc(handshakeOK)
+		s.handshaken(info, HandshakeStandings, "", nil)
+		if standings := s.tournament.standings(); standings != nil {
+			c.Send() <- standings.String()
+		} else {
+			c.Send() <- protocol.Standings{}.String()
+		}
+		close(c.Send())
+	case s.tournament != nil:
+		cmd, err := protocol.ParseRegister(res)
+		if err != nil {
+			info.logger.Error("received invalid registration", "error", err)
+			s.handshakeFailed(err)
+			s.handshaken(info, "", "", err)
 			close(c.Send())
 			return
 		}
-		s.logger.Info("added a client to lobby ", l.ID())
-	} else {
-		s.logger.Error("received invalid response or could not write to client")
+		s.metrics.handshakes.Inc(handshakeOK)
+		s.setConnState(info, connTournament, -1)
+		p := s.newPlayer(info, c)
+		p.Name = cmd.(protocol.Register).Name
+		s.advertise(p)
+		s.handshaken(info, HandshakeRegister, p.Name, nil)
+		if err := s.tournament.register(p); err != nil {
+			info.logger.Info("could not register client for tournament", "name", p.Name, "error", err)
+			if errors.Is(err, protocol.NameTakenError) || errors.Is(err, protocol.RegistrationClosedError) {
+				p.Deliver(err.Error())
+			}
+			close(p.Send)
+		}
+	default:
+		info.logger.Error("received invalid response to greeting")
+		s.handshakeFailed(errInvalidResponse)
+		s.handshaken(info, "", "", errInvalidResponse)
 		close(c.Send())
-		return
 	}
 }
 
-func confirmConnection(c Conn) bool {
-	// Perform handshake - both sides must send protocol.Greeting
+// Errors that end a handshake.
+var (
+	errHandshakeTimeout = errors.New("handshake timed out")
+	errHandshakeClosed  = errors.New("connection closed during handshake")
+	errInvalidResponse  = errors.New("invalid response to greeting")
+)
+
+// handshakeFailed counts a failed handshake.
+func (s *Server) handshakeFailed(err error) {
+	atomic.AddInt64(&s.stats.handshakesFailed, 1)
+	switch {
+	case errors.Is(err, errHandshakeTimeout):
+		s.metrics.handshakes.Inc(handshakeTimeout)
+	case errors.Is(err, errHandshakeClosed):
+		s.metrics.handshakes.Inc(handshakeClosed)
+	default:
+		s.metrics.handshakes.Inc(handshakeInvalid)
+	}
+}
+
+// confirmConnection performs the handshake with c, returning the
+// client's response to protocol.Greeting.
+func confirmConnection(c Conn, timeout time.Duration, clk clock.Clock) (string, error) {
+	// Perform handshake - server sends protocol.Greeting and client must
+	// echo it, or answer with a tournament command if it wants to join one.
+	timer := clk.NewTimer(timeout)
+	defer timer.Stop()
+
 	select {
 	case c.Send() <- protocol.Greeting:
-	case <-time.After(time.Minute):
+	case <-timer.C():
 		// Drop slow connections
-		return false
+		return "", errHandshakeTimeout
 	}
 
 	select {
 	case res, ok := <-c.Receive():
-		if !ok || res != protocol.Greeting {
-			return false
+		if !ok {
+			return "", errHandshakeClosed
 		}
-		return true
-	case <-time.After(time.Minute):
+		return res, nil
+	case <-timer.C():
 		// Drop slow connections
-		return false
+		return "", errHandshakeTimeout
+	}
+}
+
+// newLobby creates a Lobby configured for the Server.
+func (s *Server) newLobby() *lobby.Lobby {
+	l := lobby.New().
+		UseLogger(s.logger).
+		UseClock(s.clock).
+		UseRules(s.settings.get().rules).
+		OnGameOver(s.stats.record).
+		OnGameOver(s.metrics.recordResult).
+		OnGameOver(s.gameOver).
+		OnInvalidMove(s.metrics.recordInvalidMove).
+		OnInvalidMove(s.invalidMove).
+		OnStart(s.lobbyStarted).
+		OnMove(s.moved)
+	if s.webhooks != nil {
+		l.OnGameOver(s.webhooks.gameOver)
+	}
+	if s.transcripts != nil {
+		l.OnStart(s.transcripts.start).
+			OnLine(s.transcripts.line).
+			OnInvalidMove(s.transcripts.invalidMove).
+			OnGameOver(s.transcripts.gameOver)
 	}
+	return l
+}
+
+// trackConnLocked records c so it can be listed and kicked, until it is closed.
+// It must be called with s.mux held.
+func (s *Server) trackConnLocked(c Conn, ip string) *connInfo {
+	info := &connInfo{id: s.nextConnID, conn: c, ip: ip, accepted: s.clock.Now(), state: connHandshake, lobbyID: -1}
+	info.logger = s.logger.With("conn", info.id, "transport", c.Transport(), "remote", remoteAddr(c))
+	s.nextConnID++
+	s.conns[info.id] = info
+	if ip != "" {
+		s.connsPerIP[ip]++
+	}
+
+	go func() {
+		<-c.Done()
+		info.logger.Info("connection closed", "reason", c.Err())
+		s.untrackConn(info)
+		if s.transcripts != nil {
+			s.transcripts.forget(info)
+		}
+		s.disconnected(info)
+	}()
+	return info
 }
 
-func (s *Server) nextAvailableLobby() (openLobby *lobby.Lobby) {
+// orNoOp returns l, or a Logger that writes nothing if l is nil.
+func orNoOp(l logger.Logger) logger.Logger {
+	if l == nil {
+		return logger.NoOpLogger()
+	}
+	return l
+}
+
+// remoteAddr returns the address of c's peer, or "-" if it has none.
+func remoteAddr(c Conn) string {
+	if addr := c.RemoteAddr(); addr != nil {
+		return addr.String()
+	}
+	return "-"
+}
+
+func (s *Server) untrackConn(info *connInfo) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	delete(s.conns, info.id)
+	if info.ip == "" {
+		return
+	}
+	s.connsPerIP[info.ip]--
+	if s.connsPerIP[info.ip] <= 0 {
+		delete(s.connsPerIP, info.ip)
+		if b, ok := s.handshakes[info.ip]; ok && b.full(s.clock.Now()) {
+			delete(s.handshakes, info.ip)
+		}
+	}
+}
+
+// identify records the identity the client presented with its TLS
+// certificate, if the Conn has one.
+func (s *Server) identify(info *connInfo) {
+	id, ok := info.conn.(interface{ Identity() string })
+	if !ok {
+		return
+	}
+	identity := id.Identity()
+	if identity == "" {
+		return
+	}
+	s.mux.Lock()
+	info.identity = identity
+	s.mux.Unlock()
+	info.logger.Info("client identified", "identity", identity)
+}
+
+func (s *Server) setConnState(info *connInfo, state string, lobbyID int) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	info.state, info.lobbyID = state, lobbyID
+}
+
+// joinLobby adds p to the next available lobby in the pool, returning
+// the lobby or nil if none are available. The pool is locked while adding
+// so the lobby can not be discarded by SetNumLobbies in the meantime.
+func (s *Server) joinLobby(info *connInfo, p *player.Player) (*lobby.Lobby, error) {
 	s.mux.Lock()
 	defer s.mux.Unlock()
 
 	for _, l := range s.lobbies {
 		if l.IsAvailable() {
-			openLobby = l
-			return
+			info.state, info.lobbyID = connLobby, l.ID()
+			return l, l.AddPlayer(p)
 		}
 	}
-	return nil
+	return nil, nil
 }
@@ -1,16 +1,34 @@
 package server
 
 import (
-	"github.com/jeremyt135/tictactoe/pkg/protocol"
+	"net"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/jeremyt135/tictactoe/pkg/clock"
+	"github.com/jeremyt135/tictactoe/pkg/protocol"
 )
 
 type fakeConn struct {
+	*closer
 	send    chan string
 	receive chan string
 	poll    func(fakeConn)
 }
 
+func newFakeConn(send, receive chan string, poll func(fakeConn)) fakeConn {
+	return fakeConn{closer: newCloser(nil), send: send, receive: receive, poll: poll}
+}
+
+func (c fakeConn) RemoteAddr() net.Addr {
+	return nil
+}
+
+func (c fakeConn) Transport() string {
+	return "fake"
+}
+
 func (c fakeConn) Send() chan<- string {
 	return c.send
 }
@@ -39,58 +57,101 @@ func (l fakeListener) Connections() <-chan Conn {
 	return l.ch
 }
 
-func TestServerConnectionGreeting(t *testing.T) {
-	// Create server with a fake listener that gives one fake connection
-	s, _ := NewServer(nil)
-	l := fakeListener{
-		ch: make(chan Conn),
+// echoGreeting dials l and answers the server's greeting.
+func echoGreeting(t *testing.T, l *PipeListener) *PipeClient {
+	t.Helper()
+	c, err := l.Dial()
+	if err != nil {
+		t.Fatalf("Dial returned error: %v", err)
+	}
+	msg, err := c.Read()
+	if err != nil {
+		t.Fatalf("Read returned error: %v", err)
+	}
+	if msg != protocol.Greeting {
+		t.Errorf("server sent %s, expected %s", msg, protocol.Greeting)
 	}
+	c.Write(msg)
+	return c
+}
 
-	var msg string
-	c := fakeConn{
-		send:    make(chan string),
-		receive: make(chan string),
-		poll: func(conn fakeConn) {
-			// Echo the greeting message
-			msg = <-conn.send
-			conn.receive <- msg
-		},
+// waitFull waits for the server's first lobby to fill up.
+func waitFull(t *testing.T, s *Server) {
+	t.Helper()
+	lobby := s.lobbies[0]
+	deadline := time.Now().Add(5 * time.Second)
+	for !lobby.IsFull() {
+		if time.Now().After(deadline) {
+			t.Fatalf("server lobby %d was not full, expected to be full", lobby.ID())
+		}
+		time.Sleep(time.Millisecond)
 	}
-	l.conns = append(l.conns, c)
+}
 
-	// Run one connection and check what was sent
-	s.Serve(l)
+func TestServerConnectionGreeting(t *testing.T) {
+	s, _ := NewServer(nil)
+	defer s.Close()
+	l := NewPipeListener()
+	defer l.Close()
+	go s.Serve(l)
 
-	if msg != protocol.Greeting {
+	echoGreeting(t, l)
+}
+
+func TestServerHandshakeTimeout(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	s, _ := NewServer(&Options{NumLobbies: 1, HandshakeTimeout: time.Minute, Clock: clk})
+	defer s.Close()
+	l := NewPipeListener()
+	defer l.Close()
+	go s.Serve(l)
+
+	// Read the greeting but never answer it
+	c, _ := l.Dial()
+	if msg, _ := c.Read(); msg != protocol.Greeting {
 		t.Errorf("server sent %s, expected %s", msg, protocol.Greeting)
 	}
+	clk.BlockUntil(1)
+	clk.Advance(time.Minute)
+
+	if _, err := c.Read(); err == nil {
+		t.Error("Read returned nil after the handshake timed out, expected the connection to close")
+	}
+	if n := atomic.LoadInt64(&s.stats.handshakesFailed); n != 1 {
+		t.Errorf("server counted %d failed handshakes, expected 1", n)
+	}
 }
 
 func TestServerAddToLobby(t *testing.T) {
-	// Create server with 2 fake listeners that can be added to lobby
 	s, _ := NewServer(nil)
-	l := fakeListener{
-		ch: make(chan Conn),
-	}
+	defer s.Close()
+	l := NewPipeListener()
+	defer l.Close()
+	go s.Serve(l)
+
 	for i := 0; i < 2; i++ {
-		c := fakeConn{
-			send:    make(chan string),
-			receive: make(chan string),
-			poll: func(conn fakeConn) {
-				// Echo the greeting message
-				msg := <-conn.send
-				conn.receive <- msg
-			},
-		}
-		l.conns = append(l.conns, c)
+		echoGreeting(t, l)
 	}
 
-	// Run server
-	s.Serve(l)
-
 	// One lobby should be full
-	lobby := s.lobbies[0]
-	if !lobby.IsFull() {
-		t.Errorf("server lobby %d was not full, expected to be full", lobby.ID())
+	waitFull(t, s)
+}
+
+func TestServerSharesLobbiesAcrossListeners(t *testing.T) {
+	s, _ := NewServer(nil)
+	defer s.Close()
+	var listeners []Listener
+	for i := 0; i < 2; i++ {
+		l := NewPipeListener()
+		defer l.Close()
+		listeners = append(listeners, l)
 	}
+	go s.Serve(listeners...)
+
+	for _, l := range listeners {
+		echoGreeting(t, l.(*PipeListener))
+	}
+
+	// Both clients should be seated in the same lobby
+	waitFull(t, s)
 }
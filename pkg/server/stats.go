@@ -0,0 +1,63 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/jeremyt135/tictactoe/pkg/server/internal/lobby"
+	"github.com/jeremyt135/tictactoe/pkg/tokens"
+)
+
+// maxRecentGames is the number of finished lobby results kept for reporting.
+const maxRecentGames = 50
+
+// stats aggregates counters about a Server's activity.
+type stats struct {
+	connectionsAccepted int64
+	handshakesFailed    int64
+	xWins               int64
+	oWins               int64
+	draws               int64
+	unfinished          int64
+
+	mux    sync.Mutex
+	recent []lobby.Result // oldest first
+}
+
+// record counts the games in a lobby Result and keeps it as a recent result.
+func (st *stats) record(r lobby.Result) {
+	for _, g := range r.Games {
+		switch {
+		case !g.Finished:
+			atomic.AddInt64(&st.unfinished, 1)
+		case g.Winner == tokens.X:
+			atomic.AddInt64(&st.xWins, 1)
+		case g.Winner == tokens.O:
+			atomic.AddInt64(&st.oWins, 1)
+		default:
+			atomic.AddInt64(&st.draws, 1)
+		}
+	}
+	if len(r.Games) == 0 {
+		return
+	}
+
+	st.mux.Lock()
+	defer st.mux.Unlock()
+	st.recent = append(st.recent, r)
+	if len(st.recent) > maxRecentGames {
+		st.recent = st.recent[len(st.recent)-maxRecentGames:]
+	}
+}
+
+// recentResults returns the most recent lobby results, newest first.
+func (st *stats) recentResults() []lobby.Result {
+	st.mux.Lock()
+	defer st.mux.Unlock()
+
+	results := make([]lobby.Result, len(st.recent))
+	for i, r := range st.recent {
+		results[len(results)-1-i] = r
+	}
+	return results
+}
@@ -0,0 +1,210 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jeremyt135/tictactoe/pkg/server/internal/lobby"
+)
+
+// StatusHandler returns an http.Handler serving JSON reports about the Server:
+//
+//	/status   uptime and the number of lobbies and connections
+//	/lobbies  every lobby with its board and players
+//	/games    recently finished games, newest first
+//	/stats    counters of connections, handshakes and game results
+//	/metrics  metrics in the Prometheus text format
+func (s *Server) StatusHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.jsonHandler(s.statusReport))
+	mux.HandleFunc("/lobbies", s.jsonHandler(s.lobbiesReport))
+	mux.HandleFunc("/games", s.jsonHandler(s.gamesReport))
+	mux.HandleFunc("/stats", s.jsonHandler(s.statsReport))
+	mux.Handle("/metrics", s.metrics.registry.Handler())
+	return mux
+}
+
+// ServeStatus serves StatusHandler over HTTP on l until it is closed.
+func (s *Server) ServeStatus(l net.Listener) error {
+	s.logger.Info("status API listening", "address", l.Addr().String())
+	srv := &http.Server{
+		Handler:      s.StatusHandler(),
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+	if err := srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("status API error: %w", err)
+	}
+	return nil
+}
+
+func (s *Server) jsonHandler(report func() interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(report()); err != nil {
+			s.logger.Error("could not write status report", "error", err)
+		}
+	}
+}
+
+type statusJSON struct {
+	Started       time.Time `json:"started"`
+	UptimeSeconds int64     `json:"uptime_seconds"`
+	Lobbies       int       `json:"lobbies"`
+	Playing       int       `json:"playing"`
+	Connections   int       `json:"connections"`
+	Tournament    bool      `json:"tournament"`
+}
+
+func (s *Server) statusReport() interface{} {
+	report := statusJSON{
+		Started:       s.started,
+		UptimeSeconds: int64(s.clock.Now().Sub(s.started).Seconds()),
+		Connections:   len(s.connections()),
+		Tournament:    s.tournament != nil,
+	}
+	for _, l := range s.allLobbies() {
+		report.Lobbies++
+		if l.IsPlaying() {
+			report.Playing++
+		}
+	}
+	return report
+}
+
+type playerJSON struct {
+	ID        int     `json:"id"`
+	ConnID    int     `json:"conn_id"`
+	Token     string  `json:"token"`
+	Name      string  `json:"name,omitempty"`
+	RTTMillis float64 `json:"rtt_ms,omitempty"`
+	Identity  string  `json:"identity,omitempty"`
+}
+
+type lobbyJSON struct {
+	ID      int          `json:"id"`
+	State   string       `json:"state"`
+	Playing bool         `json:"playing"`
+	Full    bool         `json:"full"`
+	Board   []string     `json:"board"`
+	Players []playerJSON `json:"players"`
+}
+
+func boardRows(board [3][3]string) []string {
+	rows := make([]string, len(board))
+	for i, row := range board {
+		rows[i] = strings.Join(row[:], "")
+	}
+	return rows
+}
+
+func playersJSON(players []lobby.PlayerInfo) []playerJSON {
+	out := make([]playerJSON, 0, len(players))
+	for _, p := range players {
+		out = append(out, playerJSON{
+			ID:        p.ID,
+			ConnID:    p.ConnID,
+			Token:     p.Token,
+			Name:      p.Name,
+			RTTMillis: p.RTT.Seconds() * 1000,
+			Identity:  p.Identity,
+		})
+	}
+	return out
+}
+
+func (s *Server) lobbiesReport() interface{} {
+	lobbies := s.allLobbies()
+	report := make([]lobbyJSON, 0, len(lobbies))
+	for _, l := range lobbies {
+		snap := l.Snapshot()
+		report = append(report, lobbyJSON{
+			ID:      snap.ID,
+			State:   snap.State,
+			Playing: snap.Playing,
+			Full:    snap.Full,
+			Board:   boardRows(snap.Board),
+			Players: playersJSON(snap.Players),
+		})
+	}
+	return report
+}
+
+type gameJSON struct {
+	Winner   string   `json:"winner"`
+	Finished bool     `json:"finished"`
+	Moves    int      `json:"moves"`
+	Board    []string `json:"board"`
+	Seconds  float64  `json:"seconds"`
+}
+
+type resultJSON struct {
+	LobbyID int          `json:"lobby_id"`
+	Players []playerJSON `json:"players"`
+	// Winner is the ID of the player that won, or -1.
+	Winner  int        `json:"winner"`
+	Forfeit int        `json:"forfeit"`
+	Wins    []int      `json:"wins"`
+	Draws   int        `json:"draws"`
+	Games   []gameJSON `json:"games"`
+	Started time.Time  `json:"started"`
+	Ended   time.Time  `json:"ended"`
+}
+
+func (s *Server) gamesReport() interface{} {
+	results := s.stats.recentResults()
+	report := make([]resultJSON, 0, len(results))
+	for _, r := range results {
+		res := resultJSON{
+			LobbyID: r.LobbyID,
+			Players: playersJSON(r.Seats[:]),
+			Winner:  r.Winner,
+			Forfeit: r.Forfeit,
+			Wins:    r.Wins[:],
+			Draws:   r.Draws,
+			Started: r.Started,
+			Ended:   r.Ended,
+		}
+		for _, g := range r.Games {
+			res.Games = append(res.Games, gameJSON{
+				Winner:   g.Winner,
+				Finished: g.Finished,
+				Moves:    len(g.Moves),
+				Board:    boardRows(g.Board),
+				Seconds:  g.Ended.Sub(g.Started).Seconds(),
+			})
+		}
+		report = append(report, res)
+	}
+	return report
+}
+
+type statsJSON struct {
+	ConnectionsAccepted int64            `json:"connections_accepted"`
+	HandshakesFailed    int64            `json:"handshakes_failed"`
+	GamesFinished       map[string]int64 `json:"games_finished"`
+}
+
+func (s *Server) statsReport() interface{} {
+	return statsJSON{
+		ConnectionsAccepted: atomic.LoadInt64(&s.stats.connectionsAccepted),
+		HandshakesFailed:    atomic.LoadInt64(&s.stats.handshakesFailed),
+		GamesFinished: map[string]int64{
+			"x_wins":     atomic.LoadInt64(&s.stats.xWins),
+			"o_wins":     atomic.LoadInt64(&s.stats.oWins),
+			"draws":      atomic.LoadInt64(&s.stats.draws),
+			"unfinished": atomic.LoadInt64(&s.stats.unfinished),
+		},
+	}
+}
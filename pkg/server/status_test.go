@@ -0,0 +1,92 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jeremyt135/tictactoe/pkg/tokens"
+)
+
+func TestStatusLobbies(t *testing.T) {
+	s, _ := NewServer(nil)
+	h := s.StatusHandler()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/lobbies", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /lobbies returned %d, expected %d", rec.Code, http.StatusOK)
+	}
+
+	var lobbies []lobbyJSON
+	if err := json.NewDecoder(rec.Body).Decode(&lobbies); err != nil {
+		t.Fatalf("could not decode /lobbies: %v", err)
+	}
+	if len(lobbies) != DefaultOptions().NumLobbies {
+		t.Errorf("GET /lobbies listed %d lobbies, expected %d", len(lobbies), DefaultOptions().NumLobbies)
+	}
+	if len(lobbies) > 0 && lobbies[0].Board[0] != "___" {
+		t.Errorf("empty lobby had board row %q, expected ___", lobbies[0].Board[0])
+	}
+}
+
+func TestStatusRejectsPost(t *testing.T) {
+	s, _ := NewServer(nil)
+
+	rec := httptest.NewRecorder()
+	s.StatusHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/stats", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /stats returned %d, expected %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+// getStatus decodes the response of h to a GET of path into v.
+func getStatus(t *testing.T, h http.Handler, path string, v interface{}) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s returned %d, expected %d", path, rec.Code, http.StatusOK)
+	}
+	if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+		t.Fatalf("could not decode %s: %v", path, err)
+	}
+}
+
+func TestStatusGamesAndStats(t *testing.T) {
+	s, _ := NewServer(&Options{NumLobbies: 1})
+	defer s.Close()
+	h := s.StatusHandler()
+	playPipeGame(t, s)
+
+	var games []resultJSON
+	deadline := time.Now().Add(5 * time.Second)
+	for len(games) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("GET /games listed no games after a game was played")
+		}
+		time.Sleep(time.Millisecond)
+		getStatus(t, h, "/games", &games)
+	}
+	r := games[0]
+	if len(games) != 1 || len(r.Games) != 1 || len(r.Players) != 2 {
+		t.Fatalf("GET /games returned %+v, expected one game between two players", games)
+	}
+	if g := r.Games[0]; g.Winner != tokens.X || !g.Finished || g.Moves != 5 || g.Board[0] != "XXX" {
+		t.Errorf("GET /games reported game %+v, expected X to win in 5 moves with the top row", g)
+	}
+	if r.Winner != 0 || r.Forfeit != -1 || r.Wins[0] != 1 || r.Draws != 0 {
+		t.Errorf("GET /games reported winner %d, forfeit %d, wins %v, draws %d, expected player 0 to win",
+			r.Winner, r.Forfeit, r.Wins, r.Draws)
+	}
+
+	var stats statsJSON
+	getStatus(t, h, "/stats", &stats)
+	want := map[string]int64{"x_wins": 1, "o_wins": 0, "draws": 0, "unfinished": 0}
+	if stats.ConnectionsAccepted != 2 || stats.HandshakesFailed != 0 || !reflect.DeepEqual(stats.GamesFinished, want) {
+		t.Errorf("GET /stats returned %+v, expected 2 connections and games %v", stats, want)
+	}
+}
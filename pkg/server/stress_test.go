@@ -0,0 +1,94 @@
+package server
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jeremyt135/tictactoe/pkg/protocol"
+)
+
+// playClient answers the greeting, then answers every request for a move
+// with the next cell in order until the server closes the connection.
+func playClient(c fakeConn) {
+	next := 0
+	var token string
+	move := func() {
+		c.receive <- protocol.TurnInfo{Token: token, Row: next / 3, Col: next % 3}.String()
+		next++
+	}
+	for msg := range c.send {
+		switch {
+		case msg == protocol.Greeting:
+			c.receive <- msg
+		case strings.HasPrefix(msg, "PLAYER "):
+			next = 0
+		case strings.HasPrefix(msg, "MOVE "):
+			token = strings.TrimSpace(strings.TrimPrefix(msg, "MOVE "))
+			move()
+		case msg == protocol.SpaceTakenError.Error():
+			move()
+		}
+	}
+}
+
+func TestServerManyClients(t *testing.T) {
+	const clients = 400
+	s, _ := NewServer(&Options{NumLobbies: 50, MaxTurnAttempts: 9, SeriesLength: 2})
+	defer s.Close()
+
+	var played sync.WaitGroup
+	played.Add(clients)
+	l := fakeListener{ch: make(chan Conn)}
+	for i := 0; i < clients; i++ {
+		l.conns = append(l.conns, newFakeConn(make(chan string, 10), make(chan string, 10), func(c fakeConn) {
+			playClient(c)
+			played.Done()
+		}))
+	}
+
+	// Change and inspect lobbies while games are played
+	stop := make(chan struct{})
+	var admin sync.WaitGroup
+	admin.Add(1)
+	go func() {
+		defer admin.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			s.runAdminCommand("LOBBIES", "")
+			s.statusReport()
+			if _, err := s.Reconfigure(&Options{NumLobbies: 40 + i%20, MaxTurnAttempts: 9, SeriesLength: 1 + i%2}); err != nil {
+				t.Errorf("Reconfigure returned error: %v", err)
+			}
+			if i%10 == 0 {
+				s.runAdminCommand("BROADCAST", "hello")
+			}
+			if lobbies := s.allLobbies(); i%25 == 0 && len(lobbies) > 0 {
+				lobbies[i%len(lobbies)].End("ended by test")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	if err := s.Serve(l); err != nil {
+		t.Fatalf("Serve returned error: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		played.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Error("clients were still connected after 30s")
+	}
+	close(stop)
+	admin.Wait()
+}
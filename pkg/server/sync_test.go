@@ -0,0 +1,76 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jeremyt135/tictactoe/pkg/clock"
+	"github.com/jeremyt135/tictactoe/pkg/game"
+	"github.com/jeremyt135/tictactoe/pkg/protocol"
+	"github.com/jeremyt135/tictactoe/pkg/tokens"
+)
+
+func TestStateSentOnJoinAndSync(t *testing.T) {
+	s, _ := NewServer(&Options{NumLobbies: 1, Clock: clock.NewFake(time.Now()), Sync: true})
+	defer s.Close()
+	l := NewPipeListener()
+	defer l.Close()
+	go s.Serve(l)
+
+	// Each player is sent the board as they join, and again as the game starts
+	board := game.New()
+	caps := protocol.Capabilities{Names: []string{protocol.CapabilitySync}}.String()
+	x := echoGreeting(t, l)
+	expectMessage(t, x, caps)
+	expectMessage(t, x, protocol.NewState(board).String())
+	o := echoGreeting(t, l)
+	expectMessage(t, o, caps)
+	expectMessage(t, o, protocol.NewState(board).String())
+	expectMessage(t, x, protocol.PlayerToken{Token: tokens.X}.String())
+	expectMessage(t, o, protocol.PlayerToken{Token: tokens.O}.String())
+
+	expectMessage(t, x, protocol.NewState(board).String())
+	expectMessage(t, o, protocol.NewState(board).String())
+	expectMessage(t, x, protocol.TurnNotif{Token: tokens.X}.String())
+
+	move := protocol.TurnInfo{Token: tokens.X, Row: 1, Col: 1}.String()
+	x.Write(move)
+	expectMessage(t, o, move)
+	expectMessage(t, o, protocol.TurnNotif{Token: tokens.O}.String())
+
+	// X syncs while O is to move
+	board.Put(tokens.X, 1, 1)
+	x.Write(protocol.SyncRequest)
+	select {
+	case msg := <-x.Receive():
+		state, err := protocol.ParseState(msg)
+		if err != nil {
+			t.Fatalf("could not parse %q: %v", msg, err)
+		}
+		want := protocol.State{
+			Board:  [3][3]string{{"_", "_", "_"}, {"_", tokens.X, "_"}, {"_", "_", "_"}},
+			ToMove: tokens.O,
+			Moves:  1,
+			Result: protocol.ResultPlaying,
+		}
+		if state != want || state != protocol.NewState(board) {
+			t.Errorf("got state %+v, expected %+v", state, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not receive a STATE within 5s")
+	}
+}
+
+func TestSyncAnsweredWhileWaiting(t *testing.T) {
+	s, _ := NewServer(&Options{NumLobbies: 1, Clock: clock.NewFake(time.Now()), Sync: true})
+	defer s.Close()
+	l := NewPipeListener()
+	defer l.Close()
+	go s.Serve(l)
+
+	x := echoGreeting(t, l)
+	expectMessage(t, x, protocol.Capabilities{Names: []string{protocol.CapabilitySync}}.String())
+	expectMessage(t, x, protocol.NewState(game.New()).String())
+	x.Write(protocol.SyncRequest)
+	expectMessage(t, x, protocol.NewState(game.New()).String())
+}
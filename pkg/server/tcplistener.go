@@ -1,15 +1,18 @@
 package server
 
 import (
-	"bufio"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
 	"net"
-	"sync/atomic"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/jeremyt135/tictactoe/pkg/clock"
 	"github.com/jeremyt135/tictactoe/pkg/logger"
+	"github.com/jeremyt135/tictactoe/pkg/protocol"
 )
 
 // TcpListener listens for incoming TCP connections.
@@ -17,26 +20,45 @@ type TcpListener struct {
 	listener    net.Listener
 	connections chan Conn
 	logger      logger.Logger
-	port        int
+	tls         *tlsReloader
+	access      *AccessList
+	maxLine     int
+	clock       clock.Clock
+	closeOnce   sync.Once
 }
 
 // TcpConn wraps an incoming connection and forwards data
 // from it to channels.
 type TcpConn struct {
-	conn    net.Conn
-	logger  logger.Logger
-	send    chan string // channel for server to send messages to connected client
-	receive chan string // channel for server to receive messages from client
-	closed  int32
+	*closer
+	conn     net.Conn
+	logger   logger.Logger
+	send     chan string // channel for server to send messages to connected client
+	receive  chan string // channel for server to receive messages from client
+	identity string
+	shook    chan struct{} // closed once identity is known
+	maxLine  int
+	readDL   *readDeadline
+	writeDL  *deadline
 }
 
-func newTcpConn(conn net.Conn, logger logger.Logger) *TcpConn {
-	return &TcpConn{
+func newTcpConn(conn net.Conn, maxLine int, clk clock.Clock, logger logger.Logger) *TcpConn {
+	c := &TcpConn{
 		conn:    conn,
+		maxLine: maxLine,
 		send:    make(chan string, 10),
 		receive: make(chan string, 10),
-		logger:  logger,
+		shook:   make(chan struct{}),
+		logger:  logger.With("transport", "tcp", "remote", conn.RemoteAddr().String()),
+		readDL:  newReadDeadline(clk, conn.SetReadDeadline),
+		writeDL: newDeadline(clk, conn.SetWriteDeadline),
 	}
+	c.closer = newCloser(func() error {
+		c.readDL.disarm()
+		c.writeDL.disarm()
+		return conn.Close()
+	})
+	return c
 }
 
 func (c *TcpConn) Send() chan<- string {
@@ -47,52 +69,91 @@ func (c *TcpConn) Receive() <-chan string {
 	return c.receive
 }
 
-func (c *TcpConn) Close() error {
-	if atomic.LoadInt32(&c.closed) != 0 {
-		return errors.New("repeated call to Close")
-	}
-	atomic.StoreInt32(&c.closed, 1)
+// RemoteAddr returns the address of the connected client.
+func (c *TcpConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// Transport returns "tcp".
+func (c *TcpConn) Transport() string {
+	return "tcp"
+}
 
-	c.receive <- "DISCONNECT"
-	close(c.receive)
-	return c.conn.Close()
+// Identity returns the common name of the client's verified TLS
+// certificate, or "" if the client did not present one. It waits for the
+// TLS handshake to finish.
+func (c *TcpConn) Identity() string {
+	<-c.shook
+	return c.identity
 }
 
-const connDeadlineMinutes = 1
+// setReadTimeout changes how long the client may go without sending a line
+// before it is disconnected. Zero lets it stay silent.
+func (c *TcpConn) setReadTimeout(d time.Duration) {
+	c.readDL.setTimeout(d)
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
 
+// isTemporary reports whether err is a network error that may go away if
+// the operation is tried again. Other errors are not expected to.
 func isTemporary(err error) bool {
 	var netErr net.Error
 	if errors.As(err, &netErr) {
 		return netErr.Temporary()
 	}
-	return true
+	return false
 }
 
 func (c *TcpConn) pollSocket() {
-	defer c.Close()
+	// pollSocket is the only sender on c.receive, so it closes it
+	defer close(c.receive)
 
-	r := bufio.NewReader(c.conn)
+	r := newLineReader(c.conn, c.maxLine)
 
 	for {
-		err := c.conn.SetReadDeadline(minutesFromNow(connDeadlineMinutes))
-		if err != nil {
-			c.logger.Error("error setting TCP read deadline: ", err)
-			if !isTemporary(err) {
-				break
-			}
+		if err := c.readDL.rearm(); err != nil {
+			// A closed connection is reported by the read
+			c.logger.Debug("could not set read deadline", "error", err)
 		}
 
 		// Read from the socket
-		msg, err := r.ReadString('\n')
+		msg, err := r.readLine()
+		if c.Err() != nil {
+			return
+		}
+		if errors.Is(err, errMalformed) {
+			c.logger.Info("dropped malformed line")
+			c.writeError(protocol.MalformedMessageError)
+			continue
+		}
+		if errors.Is(err, errLineTooLong) {
+			c.logger.Info("disconnecting", "error", err)
+			c.writeError(protocol.MessageTooLongError)
+			c.Close(ReasonLineTooLong)
+			return
+		}
+		if errors.Is(err, io.EOF) {
+			c.logger.Debug("client closed the connection")
+			c.Close(ReasonClientClosed)
+			return
+		}
+		if isTimeout(err) {
+			c.logger.Info("disconnecting", "error", err)
+			c.closeWith(&DisconnectError{Reason: ReasonReadTimeout, Err: err})
+			return
+		}
 		if err != nil {
 			if !isTemporary(err) {
-				c.logger.Error("error reading from TCP socket: ", err)
-				break
+				c.logger.Error("could not read from socket", "error", err)
+				c.closeWith(&DisconnectError{Reason: ReasonReadError, Err: err})
+				return
 			}
-		}
-
-		if atomic.LoadInt32(&c.closed) != 0 {
-			break
+			// The rest of the line is read on the next call
+			continue
 		}
 
 		// Forward to server
@@ -100,65 +161,113 @@ func (c *TcpConn) pollSocket() {
 	}
 }
 
-func (c *TcpConn) pollMessages() {
-	defer c.Close()
+// writeError writes a protocol error straight to the socket. Writes on a
+// net.Conn are atomic, so it can't be interleaved with a message from pollMessages.
+func (c *TcpConn) writeError(err error) {
+	c.writeDL.arm(connTimeout)
+	if _, werr := c.conn.Write([]byte(err.Error())); werr != nil {
+		c.logger.Error("could not write to socket", "error", werr)
+	}
+}
 
+func (c *TcpConn) pollMessages() {
 	for {
 		// Read server message
 		msg, ok := <-c.send
 		if !ok {
-			c.logger.Info("could not receive from c.send: closed")
-			break
+			c.Close(ReasonServerClosed)
+			return
 		}
 
-		err := c.conn.SetWriteDeadline(minutesFromNow(connDeadlineMinutes))
-		if err != nil {
-			c.logger.Error("error setting TCP write deadline: ", err)
-			if !isTemporary(err) {
-				break
-			}
+		if err := c.writeDL.arm(connTimeout); err != nil {
+			// A closed connection is reported by the write
+			c.logger.Debug("could not set write deadline", "error", err)
 		}
 
 		// Forward to client
-		_, err = c.conn.Write([]byte(msg))
+		_, err := c.conn.Write([]byte(msg))
 		if err != nil {
-			c.logger.Error("error writing to TCP socket: ", err)
+			if c.Err() == nil {
+				c.logger.Error("could not write to socket", "error", err)
+			}
 			if !isTemporary(err) {
-				break
+				c.closeWith(&DisconnectError{Reason: ReasonWriteError, Err: err})
+				// Let the server finish with the Conn
+				drain(c.send)
+				return
 			}
 		}
-
-		// Server removed client for some reason
-		if msg == "REMOVED\n" {
-			break
-		}
 	}
 }
 
 func (c *TcpConn) poll() {
+	if tlsConn, ok := c.conn.(*tls.Conn); ok {
+		// Finish the TLS handshake before the server sends its greeting so
+		// failures are reported here instead of on the first read or write.
+		c.readDL.arm(connTimeout)
+		c.writeDL.arm(connTimeout)
+		err := tlsConn.Handshake()
+		c.readDL.disarm()
+		c.writeDL.disarm()
+		if err != nil {
+			// Every later read would fail with the same error
+			c.logger.Info("disconnecting", "error", fmt.Errorf("TLS handshake failed: %w", err))
+			c.closeWith(&DisconnectError{Reason: ReasonReadError, Err: err})
+			close(c.shook)
+			close(c.receive)
+			// Let the server finish with the Conn
+			go drain(c.send)
+			return
+		}
+		c.identity = clientIdentity(tlsConn.ConnectionState())
+	}
+	close(c.shook)
 	go c.pollSocket()
 	go c.pollMessages()
 }
 
-// ListenTcp creates a new TcpListener listening on the given port.
-// Logger may be nil in which case no log output will be generated.
-func ListenTcp(port int, logger logger.Logger) (*TcpListener, error) {
-	l, err := net.Listen("tcp4", fmt.Sprint(":", port))
+// ListenTcp creates a new TcpListener listening on the given address, which is
+// a "host:port" pair such as ":42000" (every interface, IPv4 and IPv6) or
+// "[::1]:42000", or "unix:" followed by the path of a Unix domain socket.
+// Prefix a "host:port" pair with "tcp4:" or "tcp6:" to use only one IP version.
+// Logger may be nil in which case no log output will be generated. Each
+// connection logs with its transport and remote address.
+func ListenTcp(address string, logger logger.Logger) (*TcpListener, error) {
+	l, err := listenAddress(address)
 	if err != nil {
 		return nil, fmt.Errorf("could not create TcpListener: %w", err)
 	}
 	return &TcpListener{
 		listener:    l,
-		logger:      logger,
+		logger:      orNoOp(logger),
 		connections: make(chan Conn, 100),
-		port:        port,
 	}, nil
 }
 
+// ListenTcpTLS creates a new TcpListener that accepts TLS connections on the given address.
+func ListenTcpTLS(address string, opt *TLSOptions, logger logger.Logger) (*TcpListener, error) {
+	if opt == nil {
+		return nil, errors.New("could not create TcpListener: nil TLSOptions")
+	}
+	logger = orNoOp(logger)
+	reloader, err := newTLSReloader(opt, logger)
+	if err != nil {
+		return nil, fmt.Errorf("could not create TcpListener: %w", err)
+	}
+	l, err := ListenTcp(address, logger)
+	if err != nil {
+		reloader.stop()
+		return nil, err
+	}
+	l.tls = reloader
+	l.listener = tls.NewListener(l.listener, reloader.config())
+	return l, nil
+}
+
 func (l *TcpListener) PollAccept() error {
 	defer l.Close()
 
-	l.logger.Info("waiting for TCP connections on port ", l.port)
+	l.logger.Info("waiting for TCP connections", "address", l.Addr().String())
 
 	for {
 		conn, err := l.listener.Accept()
@@ -170,28 +279,61 @@ func (l *TcpListener) PollAccept() error {
 			if errors.As(err, &ne) && !ne.Temporary() {
 				return fmt.Errorf("accept error (unrecoverable): %w", err)
 			}
-			if l.logger != nil {
-				l.logger.Error("accept error (recovered):", err)
-			}
+			l.logger.Error("accept error (recovered)", "error", err)
+			continue
 		}
-		tcpConn := newTcpConn(conn, l.logger)
+		if err := l.access.checkAddr(conn.RemoteAddr()); err != nil {
+			l.logger.Info("rejected connection", "remote", conn.RemoteAddr().String(), "error", err)
+			conn.Close()
+			continue
+		}
+		tcpConn := newTcpConn(conn, l.maxLine, l.clock, l.logger)
 		go tcpConn.poll()
 		l.connections <- tcpConn
 	}
 }
 
+// UseAccessList makes the TcpListener close connections from addresses
+// rejected by a before they are passed to the Server.
+func (l *TcpListener) UseAccessList(a *AccessList) *TcpListener {
+	l.access = a
+	return l
+}
+
+// UseMaxLineLength sets the longest line a client may send. Clients that
+// send a longer line are disconnected. If n is zero, DefaultMaxLineLength is used.
+func (l *TcpListener) UseMaxLineLength(n int) *TcpListener {
+	l.maxLine = n
+	return l
+}
+
+// UseClock sets the Clock that times read and write deadlines on the
+// TcpListener's connections. It should be the Server's Clock.
+func (l *TcpListener) UseClock(c clock.Clock) *TcpListener {
+	l.clock = c
+	return l
+}
+
+// Addr returns the address the TcpListener accepts connections on.
+func (l *TcpListener) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
 func (l *TcpListener) Connections() <-chan Conn {
 	return l.connections
 }
 
 func (l *TcpListener) Close() error {
-	close(l.connections)
-	if err := l.listener.Close(); err != nil {
+	var err error
+	l.closeOnce.Do(func() {
+		if l.tls != nil {
+			l.tls.stop()
+		}
+		err = l.listener.Close()
+		close(l.connections)
+	})
+	if err != nil {
 		return fmt.Errorf("error closing: %w", err)
 	}
 	return nil
 }
-
-func minutesFromNow(minutes int) time.Time {
-	return time.Now().Add(time.Duration(minutes) * time.Minute)
-}
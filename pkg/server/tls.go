@@ -0,0 +1,210 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/jeremyt135/tictactoe/pkg/logger"
+)
+
+// TLSOptions configure TLS for a listener.
+//
+// The certificate, key and client CA files are read again whenever the
+// process receives SIGHUP, so certificates can be renewed without a restart.
+type TLSOptions struct {
+	// CertFile and KeyFile are paths to a PEM encoded certificate chain and private key.
+	CertFile, KeyFile string
+
+	// ClientCAFile is an optional path to PEM encoded CA certificates used to
+	// verify client certificates. Clients that present a certificate signed
+	// by one of these CAs are identified by its common name.
+	ClientCAFile string
+
+	// RequireClientCert rejects clients that do not present a certificate
+	// signed by a CA in ClientCAFile, so only trusted bots can connect.
+	RequireClientCert bool
+}
+
+func validateTLSOptions(opt *TLSOptions) error {
+	if opt.CertFile == "" || opt.KeyFile == "" {
+		return errors.New("TLS certificate and key files are required")
+	}
+	if opt.RequireClientCert && opt.ClientCAFile == "" {
+		return errors.New("TLS client CA file is required to require client certificates")
+	}
+	return nil
+}
+
+// tlsReloader holds a tls.Config that is rebuilt from TLSOptions on SIGHUP.
+type tlsReloader struct {
+	opt     TLSOptions
+	logger  logger.Logger
+	current atomic.Value // *tls.Config
+	signals chan os.Signal
+	done    chan struct{}
+}
+
+func newTLSReloader(opt *TLSOptions, logger logger.Logger) (*tlsReloader, error) {
+	if err := validateTLSOptions(opt); err != nil {
+		return nil, err
+	}
+	r := &tlsReloader{
+		opt:     *opt,
+		logger:  logger,
+		signals: make(chan os.Signal, 1),
+		done:    make(chan struct{}),
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	signal.Notify(r.signals, syscall.SIGHUP)
+	go r.watch()
+	return r, nil
+}
+
+func (r *tlsReloader) watch() {
+	for {
+		select {
+		case <-r.signals:
+			if err := r.reload(); err != nil {
+				r.logger.Error("could not reload TLS certificates, keeping the old ones", "error", err)
+			} else {
+				r.logger.Info("reloaded TLS certificates", "cert_file", r.opt.CertFile)
+			}
+		case <-r.done:
+			return
+		}
+	}
+}
+
+func (r *tlsReloader) stop() {
+	signal.Stop(r.signals)
+	close(r.done)
+}
+
+// reload reads the certificate, key and client CA files and replaces the current config.
+func (r *tlsReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.opt.CertFile, r.opt.KeyFile)
+	if err != nil {
+		return fmt.Errorf("could not load TLS key pair: %w", err)
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if r.opt.ClientCAFile != "" {
+		caPEM, err := ioutil.ReadFile(r.opt.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("could not read TLS client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return fmt.Errorf("no certificates found in %s", r.opt.ClientCAFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+		if r.opt.RequireClientCert {
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	r.current.Store(cfg)
+	return nil
+}
+
+// config returns a tls.Config for a listener that always uses the most recently loaded config.
+func (r *tlsReloader) config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.current.Load().(*tls.Config), nil
+		},
+	}
+}
+
+// clientIdentity returns the common name of a verified client certificate, or "".
+func clientIdentity(state tls.ConnectionState) string {
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return state.VerifiedChains[0][0].Subject.CommonName
+}
+
+// GenerateSelfSigned writes a new self-signed certificate and private key to
+// certFile and keyFile, valid for a year for the given host names and IP
+// addresses. It is meant for local development only.
+func GenerateSelfSigned(certFile, keyFile string, hosts []string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("could not generate key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return fmt.Errorf("could not generate serial number: %w", err)
+	}
+
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"tictactoe development"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+	if len(hosts) > 0 {
+		template.Subject.CommonName = hosts[0]
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("could not create certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("could not marshal key: %w", err)
+	}
+
+	if err := writePEM(certFile, "CERTIFICATE", der, 0644); err != nil {
+		return err
+	}
+	return writePEM(keyFile, "EC PRIVATE KEY", keyDER, 0600)
+}
+
+func writePEM(path, kind string, der []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return fmt.Errorf("could not create %s: %w", path, err)
+	}
+	if err := pem.Encode(f, &pem.Block{Type: kind, Bytes: der}); err != nil {
+		f.Close()
+		return fmt.Errorf("could not write %s: %w", path, err)
+	}
+	return f.Close()
+}
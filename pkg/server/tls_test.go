@@ -0,0 +1,240 @@
+package server
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jeremyt135/tictactoe/pkg/logger"
+	"github.com/jeremyt135/tictactoe/pkg/protocol"
+)
+
+func TestListenTcpTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tictactoe-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cert, key := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := GenerateSelfSigned(cert, key, []string{"127.0.0.1"}); err != nil {
+		t.Fatalf("GenerateSelfSigned returned error: %v", err)
+	}
+
+	l, err := ListenTcpTLS("127.0.0.1:0", &TLSOptions{CertFile: cert, KeyFile: key}, logger.NoOpLogger())
+	if err != nil {
+		t.Fatalf("ListenTcpTLS returned error: %v", err)
+	}
+	go l.PollAccept()
+	defer l.Close()
+
+	certPEM, err := ioutil.ReadFile(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(certPEM)
+
+	client, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{RootCAs: roots})
+	if err != nil {
+		t.Fatalf("could not connect with TLS: %v", err)
+	}
+	defer client.Close()
+
+	conn := <-l.Connections()
+	conn.Send() <- "HELLO\n"
+	line, err := bufio.NewReader(client).ReadString('\n')
+	if err != nil || line != "HELLO\n" {
+		t.Errorf("client read %q (err %v), expected %q", line, err, "HELLO\n")
+	}
+
+	client.Write([]byte("HI\n"))
+	if msg := <-conn.Receive(); msg != "HI\n" {
+		t.Errorf("server received %q, expected %q", msg, "HI\n")
+	}
+}
+
+func TestTLSOptionsRequireClientCA(t *testing.T) {
+	opt := &TLSOptions{CertFile: "cert.pem", KeyFile: "key.pem", RequireClientCert: true}
+	if err := validateTLSOptions(opt); err == nil {
+		t.Errorf("validateTLSOptions accepted RequireClientCert without a client CA")
+	}
+}
+
+// writeClientCert writes a self-signed client certificate with the given
+// common name to dir, returning its certificate and key files. The
+// certificate is its own CA.
+func writeClientCert(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, name+".pem"), filepath.Join(dir, name+"-key.pem")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestRequireClientCert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tictactoe-mtls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cert, key := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := GenerateSelfSigned(cert, key, []string{"127.0.0.1"}); err != nil {
+		t.Fatalf("GenerateSelfSigned returned error: %v", err)
+	}
+	clientCert, clientKey := writeClientCert(t, dir, "bot-1")
+
+	l, err := ListenTcpTLS("127.0.0.1:0", &TLSOptions{
+		CertFile:          cert,
+		KeyFile:           key,
+		ClientCAFile:      clientCert,
+		RequireClientCert: true,
+	}, logger.NoOpLogger())
+	if err != nil {
+		t.Fatalf("ListenTcpTLS returned error: %v", err)
+	}
+	go l.PollAccept()
+	defer l.Close()
+
+	handshakes := make(chan HandshakeEvent, 10)
+	s, _ := NewServer(&Options{NumLobbies: 1, Events: &Events{OnHandshake: func(e HandshakeEvent) { handshakes <- e }}})
+	defer s.Close()
+	go s.Serve(l)
+
+	certPEM, err := ioutil.ReadFile(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(certPEM)
+
+	// Without a certificate the server ends the TLS handshake, which with
+	// TLS 1.3 the client only sees on its first read
+	if client, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{RootCAs: roots}); err == nil {
+		client.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if line, err := bufio.NewReader(client).ReadString('\n'); err == nil {
+			t.Errorf("client without a certificate read %q, expected it to be rejected", line)
+		}
+		client.Close()
+	}
+
+	pair, err := tls.LoadX509KeyPair(clientCert, clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{pair}})
+	if err != nil {
+		t.Fatalf("could not connect with a client certificate: %v", err)
+	}
+	defer client.Close()
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if line, err := bufio.NewReader(client).ReadString('\n'); err != nil || line != protocol.Greeting {
+		t.Fatalf("client read %q (err %v), expected %q", line, err, protocol.Greeting)
+	}
+	client.Write([]byte(protocol.Greeting))
+
+	for e := range handshakes {
+		if e.Kind != HandshakePlay {
+			continue
+		}
+		if e.Identity != "bot-1" {
+			t.Errorf("handshake has identity %q, expected bot-1", e.Identity)
+		}
+		break
+	}
+	// The rejected connection may not be untracked yet
+	var identities []string
+	found := false
+	for _, c := range s.connections() {
+		identities = append(identities, c.identity)
+		found = found || c.identity == "bot-1"
+	}
+	if !found {
+		t.Errorf("connections have identities %q, expected one to be bot-1", identities)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		players := s.allLobbies()[0].Snapshot().Players
+		if len(players) == 1 && players[0].Identity == "bot-1" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("lobby has players %+v, expected one with identity bot-1", players)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestTcpConnPlaintextOnTLSPort(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tictactoe-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cert, key := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := GenerateSelfSigned(cert, key, []string{"127.0.0.1"}); err != nil {
+		t.Fatalf("GenerateSelfSigned returned error: %v", err)
+	}
+	l, err := ListenTcpTLS("127.0.0.1:0", &TLSOptions{CertFile: cert, KeyFile: key}, logger.NoOpLogger())
+	if err != nil {
+		t.Fatalf("ListenTcpTLS returned error: %v", err)
+	}
+	go l.PollAccept()
+	defer l.Close()
+
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("could not connect: %v", err)
+	}
+	defer client.Close()
+	client.Write([]byte(protocol.Greeting))
+
+	conn := <-l.Connections()
+	if err := waitDone(t, conn); err.Reason != ReasonReadError {
+		t.Errorf("Conn closed with reason %q, expected %q", err.Reason, ReasonReadError)
+	}
+	if _, ok := <-conn.Receive(); ok {
+		t.Error("Receive was not closed after the TLS handshake failed")
+	}
+	close(conn.Send())
+}
@@ -0,0 +1,255 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/jeremyt135/tictactoe/pkg/logger"
+	"github.com/jeremyt135/tictactoe/pkg/protocol"
+	"github.com/jeremyt135/tictactoe/pkg/server/internal/lobby"
+	"github.com/jeremyt135/tictactoe/pkg/server/internal/player"
+	"github.com/jeremyt135/tictactoe/pkg/tournament"
+)
+
+// TournamentOptions configure a tournament run by a Server.
+//
+// Clients enter the tournament by answering the greeting with a REGISTER
+// command instead of echoing it. Once enough clients have registered the
+// tournament starts, and every round is played in its own lobbies.
+type TournamentOptions struct {
+	Format tournament.Format
+
+	// Participants is the number of registrations needed to start the tournament.
+	Participants int
+
+	// Rounds is the number of rounds in a Swiss tournament. If it is not
+	// positive, the number of rounds is chosen from the number of participants.
+	Rounds int
+}
+
+func validateTournamentOptions(opt *TournamentOptions) error {
+	if opt.Participants < 2 {
+		return errors.New("tournament participants must be at least 2")
+	}
+	switch opt.Format {
+	case tournament.RoundRobin, tournament.Swiss, tournament.SingleElimination:
+	default:
+		return fmt.Errorf("unknown tournament format %v", opt.Format)
+	}
+	return nil
+}
+
+// tournamentManager registers participants and plays out a tournament.
+type tournamentManager struct {
+	opt          TournamentOptions
+	newLobby     func() *lobby.Lobby
+	logger       logger.Logger
+	mux          sync.Mutex
+	participants map[string]*player.Player
+	names        []string
+	t            *tournament.Tournament
+	active       map[int]*lobby.Lobby // lobbies with a game in progress
+}
+
+func newTournamentManager(opt TournamentOptions, newLobby func() *lobby.Lobby, logger logger.Logger) *tournamentManager {
+	return &tournamentManager{
+		opt:          opt,
+		newLobby:     newLobby,
+		logger:       logger.With("tournament", opt.Format.String()),
+		participants: make(map[string]*player.Player, opt.Participants),
+		active:       make(map[int]*lobby.Lobby),
+	}
+}
+
+// register adds a participant, starting the tournament if it is now full.
+func (m *tournamentManager) register(p *player.Player) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if m.t != nil || len(m.names) == m.opt.Participants {
+		return protocol.RegistrationClosedError
+	}
+	if _, ok := m.participants[p.Name]; ok {
+		return protocol.NameTakenError
+	}
+
+	m.participants[p.Name] = p
+	m.names = append(m.names, p.Name)
+	m.logger.Info("registered participant", "name", p.Name, "registered", len(m.names), "participants", m.opt.Participants)
+
+	if len(m.names) == m.opt.Participants {
+		t, err := tournament.New(m.opt.Format, m.names, m.opt.Rounds)
+		if err != nil {
+			// Leave registration open rather than stuck full without a tournament
+			delete(m.participants, p.Name)
+			m.names = m.names[:len(m.names)-1]
+			return fmt.Errorf("could not start tournament: %w", err)
+		}
+		m.t = t
+		go m.run()
+	}
+	return nil
+}
+
+// standings returns the current Standings, or nil if the tournament has not started.
+func (m *tournamentManager) standings() *protocol.Standings {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if m.t == nil {
+		return nil
+	}
+	s := &protocol.Standings{Round: m.t.Round(), Rounds: m.t.NumRounds()}
+	for _, st := range m.t.Standings() {
+		s.Entries = append(s.Entries, protocol.Standing{
+			Rank:            st.Rank,
+			Name:            st.Name,
+			Points:          st.Points,
+			Buchholz:        st.Buchholz,
+			SonnebornBerger: st.SonnebornBerger,
+		})
+	}
+	return s
+}
+
+func (m *tournamentManager) run() {
+	m.logger.Info("tournament starting", "participants", len(m.names))
+
+	for {
+		m.mux.Lock()
+		pairings, err := m.t.NextRound()
+		round := m.t.Round()
+		m.mux.Unlock()
+		if errors.Is(err, tournament.ErrFinished) {
+			break
+		}
+		if err != nil {
+			m.logger.Error("could not start next round", "error", err)
+			break
+		}
+
+		m.logger.Info("round starting", "round", round, "games", len(pairings))
+		var wg sync.WaitGroup
+		for _, pairing := range pairings {
+			wg.Add(1)
+			go func(pairing tournament.Pairing) {
+				m.playPairing(pairing)
+				wg.Done()
+			}(pairing)
+		}
+		wg.Wait()
+	}
+
+	m.finish()
+}
+
+// playPairing plays games between the participants of a pairing until the
+// tournament has a result for it.
+func (m *tournamentManager) playPairing(pairing tournament.Pairing) {
+	first, second := pairing.First, pairing.Second
+	for {
+		m.mux.Lock()
+		players := [2]*player.Player{m.participants[first], m.participants[second]}
+		m.mux.Unlock()
+
+		winner := ""
+		switch {
+		case players[0] == nil && players[1] == nil:
+			// Nobody is left to play, so neither can advance
+			m.mux.Lock()
+			err := m.t.Forfeit(pairing.Table)
+			m.mux.Unlock()
+			if err != nil {
+				m.logger.Error("could not record forfeit", "round", pairing.Round, "table", pairing.Table, "error", err)
+			}
+			return
+		case players[0] == nil:
+			winner = second
+		case players[1] == nil:
+			winner = first
+		default:
+			winner = m.playGame(pairing.Round, players)
+		}
+
+		m.mux.Lock()
+		replay, err := m.t.Record(pairing.Table, winner)
+		m.mux.Unlock()
+		if err != nil {
+			m.logger.Error("could not record result", "round", pairing.Round, "table", pairing.Table, "error", err)
+			return
+		}
+		if !replay {
+			return
+		}
+		// swap who moves first for the replay
+		first, second = second, first
+	}
+}
+
+// playGame plays one game, or a series of games, in a new lobby and returns
+// the name of the winner, or "" for a draw. Participants that leave during
+// the game are withdrawn.
+func (m *tournamentManager) playGame(round int, players [2]*player.Player) string {
+	results := make(chan lobby.Result, 1)
+	l := m.newLobby().UseLogger(m.logger).KeepPlayers().OnGameOver(func(r lobby.Result) {
+		results <- r
+	})
+
+	for i, p := range players {
+		opp := players[1-i]
+		msg := protocol.RoundNotif{Round: round, Opponent: opp.Name}
+		p.Deliver(msg.String())
+	}
+	m.mux.Lock()
+	m.active[l.ID()] = l
+	m.mux.Unlock()
+	for _, p := range players {
+		if err := l.AddPlayer(p); err != nil {
+			m.logger.Error("could not add participant to lobby", "name", p.Name, "lobby", l.ID(), "error", err)
+		}
+	}
+
+	r := <-results
+	l.Close()
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	delete(m.active, l.ID())
+	for _, p := range players {
+		if r.Players[p.ID] == nil {
+			m.logger.Info("withdrawing participant", "name", p.Name)
+			delete(m.participants, p.Name)
+			m.t.Withdraw(p.Name)
+		}
+	}
+	if r.Winner < 0 {
+		return ""
+	}
+	return players[r.Winner].Name
+}
+
+// lobbies returns the lobbies with a tournament game in progress.
+func (m *tournamentManager) lobbies() []*lobby.Lobby {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	lobbies := make([]*lobby.Lobby, 0, len(m.active))
+	for _, l := range m.active {
+		lobbies = append(lobbies, l)
+	}
+	return lobbies
+}
+
+// finish sends the final standings to every remaining participant and disconnects them.
+func (m *tournamentManager) finish() {
+	standings := m.standings()
+	m.logger.Info("tournament finished")
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	for name, p := range m.participants {
+		p.Deliver(standings.String())
+		close(p.Send)
+		delete(m.participants, name)
+	}
+}
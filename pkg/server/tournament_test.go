@@ -0,0 +1,192 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeremyt135/tictactoe/pkg/logger"
+	"github.com/jeremyt135/tictactoe/pkg/protocol"
+	"github.com/jeremyt135/tictactoe/pkg/server/internal/player"
+	"github.com/jeremyt135/tictactoe/pkg/tokens"
+	"github.com/jeremyt135/tictactoe/pkg/tournament"
+)
+
+// dialTournament dials l and answers the server's greeting with resp.
+func dialTournament(t *testing.T, l *PipeListener, resp string) *PipeClient {
+	t.Helper()
+	c, err := l.Dial()
+	if err != nil {
+		t.Fatalf("Dial returned error: %v", err)
+	}
+	if msg, err := c.Read(); err != nil || msg != protocol.Greeting {
+		t.Fatalf("server sent %q, %v, expected %q", msg, err, protocol.Greeting)
+	}
+	c.Write(resp)
+	return c
+}
+
+// lastMessage returns the last message c receives before it is closed.
+func lastMessage(c *PipeClient) string {
+	var last string
+	for msg := range c.Receive() {
+		last = msg
+	}
+	return last
+}
+
+// waitRegistered waits until name is registered for the tournament of s.
+func waitRegistered(t *testing.T, s *Server, name string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.tournament.mux.Lock()
+		_, ok := s.tournament.participants[name]
+		s.tournament.mux.Unlock()
+		if ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s was not registered", name)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func newTournamentServer(t *testing.T) (*Server, *PipeListener) {
+	s, err := NewServer(&Options{
+		NumLobbies: 1,
+		Tournament: &TournamentOptions{Format: tournament.RoundRobin, Participants: 2},
+	})
+	if err != nil {
+		t.Fatalf("NewServer returned error: %v", err)
+	}
+	l := NewPipeListener()
+	go s.Serve(l)
+	return s, l
+}
+
+func TestTournamentRegistrationAndStandings(t *testing.T) {
+	s, l := newTournamentServer(t)
+	defer s.Close()
+	defer l.Close()
+
+	before := dialTournament(t, l, protocol.StandingsRequest)
+	if msg := lastMessage(before); msg != (protocol.Standings{}).String() {
+		t.Errorf("STANDINGS before the start returned %q, expected empty standings", msg)
+	}
+
+	alice := dialTournament(t, l, protocol.Register{Name: "alice"}.String())
+	waitRegistered(t, s, "alice")
+	taken := dialTournament(t, l, protocol.Register{Name: "alice"}.String())
+	if msg := lastMessage(taken); msg != protocol.NameTakenError.Error() {
+		t.Errorf("registering a taken name returned %q, expected %q", msg, protocol.NameTakenError)
+	}
+
+	// X takes the top row while O plays the middle row
+	cells := map[string][]int{tokens.X: {0, 1, 2}, tokens.O: {3, 4}}
+	type outcome struct {
+		name string
+		msgs []string
+	}
+	outcomes := make(chan outcome, 2)
+	go func() {
+		msgs, _ := playPipe(alice, cells)
+		outcomes <- outcome{"alice", msgs}
+	}()
+	bob := dialTournament(t, l, protocol.Register{Name: "bob"}.String())
+	go func() {
+		msgs, _ := playPipe(bob, cells)
+		outcomes <- outcome{"bob", msgs}
+	}()
+
+	var winner, loser string
+	var final [2]string
+	for i := 0; i < 2; i++ {
+		o := <-outcomes
+		if len(o.msgs) == 0 {
+			t.Fatalf("%s received nothing", o.name)
+		}
+		final[i] = o.msgs[len(o.msgs)-1]
+		for _, msg := range o.msgs {
+			if msg == (protocol.PlayerToken{Token: tokens.X}).String() {
+				winner = o.name
+			} else if msg == (protocol.PlayerToken{Token: tokens.O}).String() {
+				loser = o.name
+			}
+		}
+	}
+	if winner == "" || loser == "" {
+		t.Fatal("participants were not given both tokens")
+	}
+
+	want := protocol.Standings{Round: 1, Rounds: 1, Entries: []protocol.Standing{
+		{Rank: 1, Name: winner, Points: 1},
+		{Rank: 2, Name: loser, Buchholz: 1},
+	}}.String()
+	for _, msg := range final {
+		if msg != want {
+			t.Errorf("participant was sent final standings %q, expected %q", msg, want)
+		}
+	}
+
+	late := dialTournament(t, l, protocol.Register{Name: "carol"}.String())
+	if msg := lastMessage(late); msg != protocol.RegistrationClosedError.Error() {
+		t.Errorf("registering after the start returned %q, expected %q", msg, protocol.RegistrationClosedError)
+	}
+	after := dialTournament(t, l, protocol.StandingsRequest)
+	if msg := lastMessage(after); msg != want {
+		t.Errorf("STANDINGS after the tournament returned %q, expected %q", msg, want)
+	}
+}
+
+func TestTournamentWithdrawsDisconnectedParticipant(t *testing.T) {
+	s, l := newTournamentServer(t)
+	defer s.Close()
+	defer l.Close()
+
+	alice := dialTournament(t, l, protocol.Register{Name: "alice"}.String())
+	bob := dialTournament(t, l, protocol.Register{Name: "bob"}.String())
+
+	// Alice leaves as soon as her game starts
+	for msg := range alice.Receive() {
+		if strings.HasPrefix(msg, "PLAYER ") {
+			alice.Close()
+		}
+	}
+	cells := map[string][]int{tokens.X: {0, 1, 2}, tokens.O: {3, 4, 5}}
+	msgs, _ := playPipe(bob, cells)
+	if len(msgs) == 0 {
+		t.Fatal("bob received nothing")
+	}
+
+	want := protocol.Standings{Round: 1, Rounds: 1, Entries: []protocol.Standing{
+		{Rank: 1, Name: "bob", Points: 1},
+		{Rank: 2, Name: "alice", Buchholz: 1},
+	}}.String()
+	if msg := msgs[len(msgs)-1]; msg != want {
+		t.Errorf("bob was sent final standings %q, expected %q", msg, want)
+	}
+	s.tournament.mux.Lock()
+	_, ok := s.tournament.participants["alice"]
+	s.tournament.mux.Unlock()
+	if ok {
+		t.Error("alice is still a participant after disconnecting")
+	}
+}
+
+func TestTournamentRegistrationReopensWhenStartFails(t *testing.T) {
+	if _, err := NewServer(&Options{NumLobbies: 1, Tournament: &TournamentOptions{Format: 99, Participants: 2}}); err == nil {
+		t.Error("NewServer accepted an unknown tournament format")
+	}
+
+	m := newTournamentManager(TournamentOptions{Format: 99, Participants: 2}, nil, logger.NoOpLogger())
+	for _, name := range []string{"alice", "bob"} {
+		p := player.New(make(chan string, 1), nil)
+		p.Name = name
+		m.register(p)
+	}
+	if len(m.names) != 1 || m.participants["bob"] != nil {
+		t.Errorf("registered %v after the tournament failed to start, expected only alice", m.names)
+	}
+}
@@ -0,0 +1,285 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeremyt135/tictactoe/pkg/clock"
+	"github.com/jeremyt135/tictactoe/pkg/logger"
+	"github.com/jeremyt135/tictactoe/pkg/protocol"
+	"github.com/jeremyt135/tictactoe/pkg/server/internal/lobby"
+)
+
+// Defaults for TranscriptOptions.
+const (
+	DefaultTranscriptMaxBytes = 1 << 20
+	DefaultTranscriptMaxFiles = 1000
+)
+
+// TranscriptOptions configure the transcripts a Server writes of its games.
+//
+// Each game, or series of games, gets a file of JSON lines in Dir named
+// "game-<id>.jsonl" after the game's ID. It records the handshake of each
+// player, every protocol line sent to and received from them with the time,
+// the moves that were rejected, and the result with the final board of
+// each game.
+type TranscriptOptions struct {
+	// Dir is the directory transcripts are written to. It is created if it doesn't exist.
+	Dir string
+
+	// MaxBytes caps the size of a transcript. Lines past the cap are left
+	// out, but the result is always written. If zero, DefaultTranscriptMaxBytes is used.
+	MaxBytes int64
+
+	// MaxFiles is the number of transcripts kept in Dir. When a game ends,
+	// the oldest transcripts beyond it are removed. If zero,
+	// DefaultTranscriptMaxFiles is used.
+	MaxFiles int
+}
+
+func validateTranscriptOptions(opt *TranscriptOptions) error {
+	if opt.Dir == "" {
+		return errors.New("transcripts need a directory")
+	}
+	if opt.MaxBytes < 0 || opt.MaxFiles < 0 {
+		return errors.New("transcript size and file limits must not be negative")
+	}
+	return nil
+}
+
+// Events written to a transcript.
+const (
+	transcriptStart             = "start"
+	transcriptHandshakeSent     = "handshake_sent"
+	transcriptHandshakeReceived = "handshake_received"
+	transcriptSent              = "sent"
+	transcriptReceived          = "received"
+	transcriptInvalidMove       = "invalid_move"
+	transcriptTruncated         = "truncated"
+	transcriptGameOver          = "game_over"
+)
+
+type transcriptEntry struct {
+	Time    time.Time    `json:"time"`
+	Event   string       `json:"event"`
+	GameID  string       `json:"game_id,omitempty"`
+	LobbyID *int         `json:"lobby_id,omitempty"`
+	Players []playerJSON `json:"players,omitempty"`
+	Player  *int         `json:"player,omitempty"`
+	Token   string       `json:"token,omitempty"`
+	Line    string       `json:"line,omitempty"`
+	Error   string       `json:"error,omitempty"`
+	Result  *webhookJSON `json:"result,omitempty"`
+}
+
+// transcript is the file of a game in progress. It is only written by the
+// goroutine of the game's Lobby.
+type transcript struct {
+	name      string
+	f         *os.File
+	w         *bufio.Writer
+	size      int64
+	truncated bool
+}
+
+// transcripts writes a transcript of every game played on a Server.
+type transcripts struct {
+	given      TranscriptOptions // as passed to the Server, for Reconfigure to compare
+	opt        TranscriptOptions // with defaults filled in
+	clock      clock.Clock
+	logger     logger.Logger
+	mux        sync.Mutex
+	open       map[int]*transcript       // by lobby ID
+	handshakes map[int][]transcriptEntry // by conn ID
+}
+
+func newTranscripts(opt TranscriptOptions, clk clock.Clock, logger logger.Logger) (*transcripts, error) {
+	given := opt
+	if opt.MaxBytes == 0 {
+		opt.MaxBytes = DefaultTranscriptMaxBytes
+	}
+	if opt.MaxFiles == 0 {
+		opt.MaxFiles = DefaultTranscriptMaxFiles
+	}
+	if err := os.MkdirAll(opt.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("could not create transcript directory: %w", err)
+	}
+	return &transcripts{
+		given:      given,
+		opt:        opt,
+		clock:      clk,
+		logger:     logger,
+		open:       make(map[int]*transcript),
+		handshakes: make(map[int][]transcriptEntry),
+	}, nil
+}
+
+// handshake keeps the handshake of a connection for the transcripts of its games.
+func (t *transcripts) handshake(info *connInfo, greeted time.Time, response string, answered time.Time) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.handshakes[info.id] = []transcriptEntry{
+		{Time: greeted, Event: transcriptHandshakeSent, Line: protocol.Greeting},
+		{Time: answered, Event: transcriptHandshakeReceived, Line: response},
+	}
+}
+
+// forget drops the handshake of a connection once it is closed.
+func (t *transcripts) forget(info *connInfo) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	delete(t.handshakes, info.id)
+}
+
+// start opens the transcript of a game and writes the players' handshakes.
+func (t *transcripts) start(r lobby.Result) {
+	name := "game-" + r.GameID + ".jsonl"
+	f, err := os.OpenFile(filepath.Join(t.opt.Dir, name), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		t.logger.Error("could not create transcript", "lobby", r.LobbyID, "game", r.GameID, "error", err)
+		return
+	}
+	tr := &transcript{name: name, f: f, w: bufio.NewWriter(f)}
+	lobbyID := r.LobbyID
+	t.write(tr, transcriptEntry{
+		Time:    r.Started,
+		Event:   transcriptStart,
+		GameID:  r.GameID,
+		LobbyID: &lobbyID,
+		Players: playersJSON(r.Seats[:]),
+	})
+
+	t.mux.Lock()
+	t.open[r.LobbyID] = tr
+	var handshakes []transcriptEntry
+	for i := range r.Seats {
+		for _, e := range t.handshakes[r.Seats[i].ConnID] {
+			e.Player = &r.Seats[i].ID
+			handshakes = append(handshakes, e)
+		}
+	}
+	t.mux.Unlock()
+	for _, e := range handshakes {
+		t.write(tr, e)
+	}
+}
+
+// get returns the open transcript of a Lobby, or nil.
+func (t *transcripts) get(lobbyID int) *transcript {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	return t.open[lobbyID]
+}
+
+func (t *transcripts) line(l lobby.Line) {
+	tr := t.get(l.LobbyID)
+	if tr == nil {
+		return
+	}
+	e := transcriptEntry{Time: l.Time, Event: transcriptReceived, Player: &l.Player.ID, Token: l.Player.Token, Line: l.Text}
+	if l.Sent {
+		e.Event = transcriptSent
+	}
+	t.write(tr, e)
+}
+
+func (t *transcripts) invalidMove(m lobby.InvalidMove) {
+	tr := t.get(m.LobbyID)
+	if tr == nil {
+		return
+	}
+	t.write(tr, transcriptEntry{
+		Time:   t.clock.Now(),
+		Event:  transcriptInvalidMove,
+		Player: &m.Player.ID,
+		Token:  m.Player.Token,
+		Error:  strings.TrimSpace(m.Err.Error()),
+	})
+}
+
+// gameOver writes the result and closes the transcript of a game.
+func (t *transcripts) gameOver(r lobby.Result) {
+	t.mux.Lock()
+	tr := t.open[r.LobbyID]
+	delete(t.open, r.LobbyID)
+	t.mux.Unlock()
+	if tr == nil {
+		return
+	}
+
+	result := webhookPayload(r)
+	t.write(tr, transcriptEntry{Time: r.Ended, Event: transcriptGameOver, GameID: r.GameID, Result: &result})
+	err := tr.w.Flush()
+	if cerr := tr.f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		t.logger.Error("could not write transcript", "lobby", r.LobbyID, "game", r.GameID, "error", err)
+	}
+	t.prune()
+}
+
+// write appends e to tr, unless tr has reached its size cap. The result
+// of the game is written regardless.
+func (t *transcripts) write(tr *transcript, e transcriptEntry) {
+	if tr.truncated && e.Event != transcriptGameOver {
+		return
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		t.logger.Error("could not encode transcript entry", "event", e.Event, "error", err)
+		return
+	}
+	line = append(line, '\n')
+	if e.Event != transcriptGameOver && tr.size+int64(len(line)) > t.opt.MaxBytes {
+		tr.truncated = true
+		line, _ = json.Marshal(transcriptEntry{Time: e.Time, Event: transcriptTruncated})
+		line = append(line, '\n')
+	}
+	n, _ := tr.w.Write(line)
+	tr.size += int64(n)
+}
+
+// prune removes the oldest finished transcripts beyond MaxFiles.
+func (t *transcripts) prune() {
+	t.mux.Lock()
+	open := make(map[string]bool, len(t.open))
+	for _, tr := range t.open {
+		open[tr.name] = true
+	}
+	t.mux.Unlock()
+
+	infos, err := ioutil.ReadDir(t.opt.Dir)
+	if err != nil {
+		t.logger.Error("could not list transcripts", "dir", t.opt.Dir, "error", err)
+		return
+	}
+	var files []os.FileInfo
+	for _, info := range infos {
+		if info.Mode().IsRegular() && !open[info.Name()] && strings.HasPrefix(info.Name(), "game-") && strings.HasSuffix(info.Name(), ".jsonl") {
+			files = append(files, info)
+		}
+	}
+	keep := t.opt.MaxFiles - len(open)
+	if keep < 0 {
+		keep = 0
+	}
+	if len(files) <= keep {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+	for _, info := range files[:len(files)-keep] {
+		if err := os.Remove(filepath.Join(t.opt.Dir, info.Name())); err != nil {
+			t.logger.Error("could not remove old transcript", "file", info.Name(), "error", err)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeremyt135/tictactoe/pkg/clock"
+	"github.com/jeremyt135/tictactoe/pkg/logger"
+	"github.com/jeremyt135/tictactoe/pkg/protocol"
+	"github.com/jeremyt135/tictactoe/pkg/server/internal/lobby"
+	"github.com/jeremyt135/tictactoe/pkg/tokens"
+)
+
+// readTranscript returns the entries of the only transcript in dir once its
+// game is over.
+func readTranscript(t *testing.T, dir string) []transcriptEntry {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		files, _ := filepath.Glob(filepath.Join(dir, "game-*.jsonl"))
+		if len(files) == 1 {
+			data, _ := ioutil.ReadFile(files[0])
+			var entries []transcriptEntry
+			scanner := bufio.NewScanner(strings.NewReader(string(data)))
+			for scanner.Scan() {
+				var e transcriptEntry
+				if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+					t.Fatalf("could not decode transcript line %q: %v", scanner.Text(), err)
+				}
+				entries = append(entries, e)
+			}
+			if n := len(entries); n > 0 && entries[n-1].Event == transcriptGameOver {
+				return entries
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("transcript was not finished within 5s")
+	return nil
+}
+
+func TestTranscriptRecordsGame(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transcripts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewServer(&Options{NumLobbies: 1, Transcripts: &TranscriptOptions{Dir: dir}})
+	if err != nil {
+		t.Fatalf("NewServer returned error: %v", err)
+	}
+	defer s.Close()
+	l := NewPipeListener()
+	defer l.Close()
+	go s.Serve(l)
+
+	// O tries X's first cell before playing the middle row
+	cells := map[string][]int{tokens.X: {0, 1, 2}, tokens.O: {0, 3, 4}}
+	for i := 0; i < 2; i++ {
+		c, err := l.Dial()
+		if err != nil {
+			t.Fatalf("Dial returned error: %v", err)
+		}
+		go playPipe(c, cells)
+	}
+	entries := readTranscript(t, dir)
+
+	counts := make(map[string]int)
+	var received []string
+	for _, e := range entries {
+		counts[e.Event]++
+		if e.Event == transcriptReceived {
+			received = append(received, e.Line)
+		}
+	}
+	if entries[0].Event != transcriptStart || len(entries[0].Players) != 2 {
+		t.Errorf("transcript starts with %+v, expected the start with 2 players", entries[0])
+	}
+	if counts[transcriptHandshakeSent] != 2 || counts[transcriptHandshakeReceived] != 2 {
+		t.Errorf("transcript has %d greetings and %d answers, expected 2 of each",
+			counts[transcriptHandshakeSent], counts[transcriptHandshakeReceived])
+	}
+	if len(received) != 6 || received[1] != (protocol.TurnInfo{Token: tokens.O, Row: 0, Col: 0}).String() {
+		t.Errorf("transcript received %q, expected 6 moves with O's second line taking a full cell", received)
+	}
+	if counts[transcriptInvalidMove] != 1 || counts[transcriptSent] == 0 {
+		t.Errorf("transcript has %d invalid moves and %d sent lines, expected 1 and some", counts[transcriptInvalidMove], counts[transcriptSent])
+	}
+	result := entries[len(entries)-1].Result
+	if result == nil || result.GameID == "" || len(result.Games) != 1 || result.Games[0].Board[0] != "XXX" {
+		t.Errorf("transcript ends with %+v, expected the game with X holding the top row", result)
+	}
+	if name := "game-" + entries[0].GameID + ".jsonl"; !fileExists(filepath.Join(dir, name)) {
+		t.Errorf("transcript is not named %s", name)
+	}
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestTranscriptsCappedAndPruned(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transcripts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tr, err := newTranscripts(TranscriptOptions{Dir: dir, MaxBytes: 300, MaxFiles: 2}, clock.NewFake(time.Now()), logger.NoOpLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, id := range []string{"a", "b", "c"} {
+		r := lobby.Result{LobbyID: 1, GameID: id, Winner: -1, Forfeit: -1}
+		tr.start(r)
+		for j := 0; j < 10; j++ {
+			tr.line(lobby.Line{LobbyID: 1, Sent: true, Text: protocol.Greeting})
+		}
+		tr.gameOver(r)
+		// Make each transcript older than the next
+		old := time.Now().Add(time.Duration(i-3) * time.Hour)
+		os.Chtimes(filepath.Join(dir, "game-"+id+".jsonl"), old, old)
+	}
+
+	if fileExists(filepath.Join(dir, "game-a.jsonl")) {
+		t.Error("oldest transcript was not removed")
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "game-c.jsonl"))
+	if err != nil {
+		t.Fatalf("newest transcript was removed: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 4 || !strings.Contains(lines[2], `"event":"truncated"`) || !strings.Contains(lines[3], `"event":"game_over"`) {
+		t.Errorf("transcript %s is not truncated before its result", data)
+	}
+}
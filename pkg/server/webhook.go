@@ -0,0 +1,297 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jeremyt135/tictactoe/pkg/clock"
+	"github.com/jeremyt135/tictactoe/pkg/logger"
+	"github.com/jeremyt135/tictactoe/pkg/server/internal/lobby"
+)
+
+// Defaults for WebhookOptions.
+const (
+	DefaultWebhookAttempts = 5
+	DefaultWebhookBackoff  = time.Second
+	DefaultWebhookTimeout  = 10 * time.Second
+)
+
+// Headers sent with each webhook.
+const (
+	WebhookEventHeader     = "X-Tictactoe-Event"
+	WebhookSignatureHeader = "X-Tictactoe-Signature"
+)
+
+// maxWebhookDeliveries is the number of deliveries that can be in progress
+// at once. Deliveries beyond it go straight to the dead-letter file.
+const maxWebhookDeliveries = 64
+
+// WebhookOptions configure the webhooks a Server posts when a game ends.
+//
+// Each URL receives a POST with a JSON body describing the players, moves
+// and result. Failed deliveries are retried, waiting Backoff before the
+// second attempt and twice as long before each one after that.
+type WebhookOptions struct {
+	URLs []string
+
+	// Secret, if not empty, is used to sign each body with HMAC-SHA256.
+	// The signature is sent in the X-Tictactoe-Signature header as
+	// "sha256=" followed by the hex digest, and can be checked with VerifyWebhook.
+	Secret string
+
+	// MaxAttempts is the number of times a delivery is tried. If zero,
+	// DefaultWebhookAttempts is used.
+	MaxAttempts int
+
+	// Backoff is how long to wait before retrying. If zero, DefaultWebhookBackoff is used.
+	Backoff time.Duration
+
+	// Timeout limits each request. If zero, DefaultWebhookTimeout is used.
+	Timeout time.Duration
+
+	// DeadLetterFile, if not empty, is appended a line of JSON for every
+	// delivery that failed all of its attempts.
+	DeadLetterFile string
+}
+
+func validateWebhookOptions(opt *WebhookOptions) error {
+	if len(opt.URLs) == 0 {
+		return errors.New("webhooks need at least one URL")
+	}
+	for _, u := range opt.URLs {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			return fmt.Errorf("invalid webhook URL: %w", err)
+		}
+		if parsed.Scheme != "http" && parsed.Scheme != "https" {
+			return fmt.Errorf("webhook URL %s must be http or https", u)
+		}
+	}
+	if opt.MaxAttempts < 0 || opt.Backoff < 0 || opt.Timeout < 0 {
+		return errors.New("webhook attempts, backoff and timeout must not be negative")
+	}
+	return nil
+}
+
+// signWebhook returns the signature header value for body.
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook reports whether signature, the value of the
+// X-Tictactoe-Signature header, is the signature of body made with secret.
+func VerifyWebhook(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(signature), []byte(signWebhook(secret, body)))
+}
+
+type moveJSON struct {
+	Token string `json:"token"`
+	Row   int    `json:"row"`
+	Col   int    `json:"col"`
+}
+
+type webhookGameJSON struct {
+	Winner   string     `json:"winner"`
+	Finished bool       `json:"finished"`
+	Moves    []moveJSON `json:"moves"`
+	Board    []string   `json:"board"`
+	Seconds  float64    `json:"seconds"`
+}
+
+type webhookJSON struct {
+	Event   string       `json:"event"`
+	LobbyID int          `json:"lobby_id"`
+	GameID  string       `json:"game_id"`
+	Players []playerJSON `json:"players"`
+	// Winner is the ID of the player that won, or -1.
+	Winner  int               `json:"winner"`
+	Forfeit int               `json:"forfeit"`
+	Wins    []int             `json:"wins"`
+	Draws   int               `json:"draws"`
+	Games   []webhookGameJSON `json:"games"`
+	Started time.Time         `json:"started"`
+	Ended   time.Time         `json:"ended"`
+	Seconds float64           `json:"seconds"`
+}
+
+// webhookEventGameOver is the event of a webhook sent when a game ends.
+const webhookEventGameOver = "game_over"
+
+func webhookPayload(r lobby.Result) webhookJSON {
+	payload := webhookJSON{
+		Event:   webhookEventGameOver,
+		LobbyID: r.LobbyID,
+		GameID:  r.GameID,
+		Players: playersJSON(r.Seats[:]),
+		Winner:  r.Winner,
+		Forfeit: r.Forfeit,
+		Wins:    r.Wins[:],
+		Draws:   r.Draws,
+		Started: r.Started,
+		Ended:   r.Ended,
+		Seconds: r.Ended.Sub(r.Started).Seconds(),
+	}
+	for _, g := range r.Games {
+		game := webhookGameJSON{
+			Winner:   g.Winner,
+			Finished: g.Finished,
+			Moves:    make([]moveJSON, 0, len(g.Moves)),
+			Board:    boardRows(g.Board),
+			Seconds:  g.Ended.Sub(g.Started).Seconds(),
+		}
+		for _, m := range g.Moves {
+			game.Moves = append(game.Moves, moveJSON{Token: m.Token, Row: m.Row, Col: m.Col})
+		}
+		payload.Games = append(payload.Games, game)
+	}
+	return payload
+}
+
+// webhooks posts game results to the configured URLs.
+type webhooks struct {
+	given    WebhookOptions // as passed to the Server, for Reconfigure to compare
+	opt      WebhookOptions // with defaults filled in
+	client   *http.Client
+	clock    clock.Clock
+	logger   logger.Logger
+	inflight chan struct{} // holds a value for each delivery in progress
+	mux      sync.Mutex    // guards writes to the dead-letter file
+}
+
+func newWebhooks(opt WebhookOptions, clk clock.Clock, logger logger.Logger) *webhooks {
+	given := opt
+	given.URLs = append([]string(nil), opt.URLs...)
+	if opt.MaxAttempts == 0 {
+		opt.MaxAttempts = DefaultWebhookAttempts
+	}
+	if opt.Backoff == 0 {
+		opt.Backoff = DefaultWebhookBackoff
+	}
+	if opt.Timeout == 0 {
+		opt.Timeout = DefaultWebhookTimeout
+	}
+	return &webhooks{
+		given:    given,
+		opt:      opt,
+		client:   &http.Client{Timeout: opt.Timeout},
+		clock:    clk,
+		logger:   logger,
+		inflight: make(chan struct{}, maxWebhookDeliveries),
+	}
+}
+
+// gameOver starts delivering r to every URL. It doesn't wait for the deliveries.
+func (w *webhooks) gameOver(r lobby.Result) {
+	if len(r.Games) == 0 {
+		return
+	}
+	body, err := json.Marshal(webhookPayload(r))
+	if err != nil {
+		w.logger.Error("could not encode webhook", "lobby", r.LobbyID, "error", err)
+		return
+	}
+	for _, u := range w.opt.URLs {
+		select {
+		case w.inflight <- struct{}{}:
+			go func(u string) {
+				defer func() { <-w.inflight }()
+				w.deliver(u, body)
+			}(u)
+		default:
+			w.deadLetter(u, body, 0, errors.New("too many webhook deliveries in progress"))
+		}
+	}
+}
+
+// deliver posts body to u until it succeeds or runs out of attempts.
+func (w *webhooks) deliver(u string, body []byte) {
+	backoff := w.opt.Backoff
+	for attempt := 1; ; attempt++ {
+		err := w.post(u, body)
+		if err == nil {
+			return
+		}
+		w.logger.Warn("webhook failed", "url", u, "attempt", attempt, "max_attempts", w.opt.MaxAttempts, "error", err)
+		if attempt == w.opt.MaxAttempts {
+			w.deadLetter(u, body, attempt, err)
+			return
+		}
+		<-w.clock.NewTimer(backoff).C()
+		backoff *= 2
+	}
+}
+
+func (w *webhooks) post(u string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, webhookEventGameOver)
+	if w.opt.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, signWebhook(w.opt.Secret, body))
+	}
+	res, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("receiver responded %s", res.Status)
+	}
+	return nil
+}
+
+type deadLetterJSON struct {
+	Time     time.Time       `json:"time"`
+	URL      string          `json:"url"`
+	Attempts int             `json:"attempts"`
+	Error    string          `json:"error"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+// deadLetter records a delivery that could not be made.
+func (w *webhooks) deadLetter(u string, body []byte, attempts int, cause error) {
+	w.logger.Error("giving up on webhook", "url", u, "error", cause)
+	if w.opt.DeadLetterFile == "" {
+		return
+	}
+	line, err := json.Marshal(deadLetterJSON{
+		Time:     w.clock.Now(),
+		URL:      u,
+		Attempts: attempts,
+		Error:    cause.Error(),
+		Payload:  body,
+	})
+	if err != nil {
+		w.logger.Error("could not encode dead letter", "error", err)
+		return
+	}
+
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	f, err := os.OpenFile(w.opt.DeadLetterFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		w.logger.Error("could not open dead-letter file", "path", w.opt.DeadLetterFile, "error", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		w.logger.Error("could not write dead-letter file", "path", w.opt.DeadLetterFile, "error", err)
+	}
+}
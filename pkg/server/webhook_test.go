@@ -0,0 +1,137 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jeremyt135/tictactoe/pkg/clock"
+	"github.com/jeremyt135/tictactoe/pkg/logger"
+	"github.com/jeremyt135/tictactoe/pkg/protocol"
+	"github.com/jeremyt135/tictactoe/pkg/server/internal/lobby"
+	"github.com/jeremyt135/tictactoe/pkg/tokens"
+)
+
+type webhookRequest struct {
+	header http.Header
+	body   []byte
+}
+
+func TestWebhookPostsSignedResult(t *testing.T) {
+	received := make(chan webhookRequest, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- webhookRequest{r.Header, body}
+	}))
+	defer receiver.Close()
+
+	s, err := NewServer(&Options{NumLobbies: 1, Webhooks: &WebhookOptions{URLs: []string{receiver.URL}, Secret: "secret"}})
+	if err != nil {
+		t.Fatalf("NewServer returned error: %v", err)
+	}
+	defer s.Close()
+	playPipeGame(t, s)
+
+	var req webhookRequest
+	select {
+	case req = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not posted within 5s")
+	}
+	if !VerifyWebhook("secret", req.body, req.header.Get(WebhookSignatureHeader)) {
+		t.Errorf("signature %q does not match the body", req.header.Get(WebhookSignatureHeader))
+	}
+	if event := req.header.Get(WebhookEventHeader); event != "game_over" {
+		t.Errorf("event header is %q, expected game_over", event)
+	}
+
+	var payload webhookJSON
+	if err := json.Unmarshal(req.body, &payload); err != nil {
+		t.Fatalf("could not decode webhook: %v", err)
+	}
+	if len(payload.Players) != 2 || len(payload.Games) != 1 {
+		t.Fatalf("webhook has %d players and %d games, expected 2 and 1", len(payload.Players), len(payload.Games))
+	}
+	if payload.Winner < 0 || payload.Players[payload.Winner].Token != tokens.X {
+		t.Errorf("webhook has winner %d of %+v, expected X", payload.Winner, payload.Players)
+	}
+	if game := payload.Games[0]; len(game.Moves) != 5 || game.Board[0] != "XXX" {
+		t.Errorf("webhook game has moves %+v and board %v, expected X to take the top row in 5 moves", game.Moves, game.Board)
+	}
+}
+
+func TestWebhookRetriesThenDeadLetters(t *testing.T) {
+	var attempts int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	dir, err := ioutil.TempDir("", "webhook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	deadLetters := filepath.Join(dir, "dead.jsonl")
+
+	clk := clock.NewFake(time.Now())
+	w := newWebhooks(WebhookOptions{
+		URLs:           []string{receiver.URL},
+		MaxAttempts:    3,
+		Backoff:        time.Second,
+		DeadLetterFile: deadLetters,
+	}, clk, logger.NoOpLogger())
+
+	r := lobby.Result{Winner: -1, Forfeit: -1, Games: []lobby.Game{{
+		Winner: tokens.Empty,
+		Moves:  []protocol.TurnInfo{{Token: tokens.X, Row: 1, Col: 1}},
+	}}}
+	w.gameOver(r)
+
+	// Wait 1s after the first attempt and 2s after the second
+	for _, backoff := range []time.Duration{time.Second, 2 * time.Second} {
+		clk.BlockUntil(1)
+		clk.Advance(backoff)
+	}
+
+	var line []byte
+	deadline := time.Now().Add(5 * time.Second)
+	for len(line) == 0 && time.Now().Before(deadline) {
+		line, _ = ioutil.ReadFile(deadLetters)
+		time.Sleep(time.Millisecond)
+	}
+	var letter deadLetterJSON
+	if err := json.Unmarshal(line, &letter); err != nil {
+		t.Fatalf("could not decode dead letter %q: %v", line, err)
+	}
+	if letter.URL != receiver.URL || letter.Attempts != 3 {
+		t.Errorf("dead letter for %s after %d attempts, expected %s after 3", letter.URL, letter.Attempts, receiver.URL)
+	}
+	if n := atomic.LoadInt32(&attempts); n != 3 {
+		t.Errorf("receiver got %d attempts, expected 3", n)
+	}
+	var payload webhookJSON
+	if err := json.Unmarshal(letter.Payload, &payload); err != nil || len(payload.Games) != 1 {
+		t.Errorf("dead letter payload %s is not the game result", letter.Payload)
+	}
+}
+
+func TestWebhookOptionsValidated(t *testing.T) {
+	for _, opt := range []WebhookOptions{
+		{},
+		{URLs: []string{"ftp://example.com"}},
+		{URLs: []string{"http://example.com"}, MaxAttempts: -1},
+	} {
+		opt := opt
+		if _, err := NewServer(&Options{NumLobbies: 1, Webhooks: &opt}); err == nil {
+			t.Errorf("NewServer with webhooks %+v returned nil, expected an error", opt)
+		}
+	}
+}
@@ -1,26 +1,389 @@
 package server
 
-import "github.com/jeremyt135/tictactoe/pkg/logger"
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/jeremyt135/tictactoe/pkg/clock"
+	"github.com/jeremyt135/tictactoe/pkg/logger"
+	"github.com/jeremyt135/tictactoe/pkg/protocol"
+)
 
 // WSListener listens for incoming WebSocket connections.
 type WSListener struct {
+	listener    net.Listener
+	server      *http.Server
+	upgrader    websocket.Upgrader
+	connections chan Conn
+	logger      logger.Logger
+	tls         *tlsReloader
+	access      *AccessList
+	maxLine     int
+	clock       clock.Clock
+	handshakes  *handshakeListener // nil without TLS
+	mux         sync.RWMutex       // held for writing when closing connections
+	closed      bool
+	done        chan struct{} // closed when Close is called, before mux is locked
+	closeOnce   sync.Once
+}
+
+// WSConn wraps an incoming WebSocket connection and forwards data
+// from it to channels. Each WebSocket text message is one line of the protocol.
+type WSConn struct {
+	*closer
+	ws       *websocket.Conn
+	logger   logger.Logger
+	send     chan string // channel for server to send messages to connected client
+	receive  chan string // channel for server to receive messages from client
+	errors   chan string // protocol errors for pollMessages to send to the client
+	identity string
+	readDL   *readDeadline
+	writeDL  *deadline
+}
+
+func newWSConn(ws *websocket.Conn, maxLine int, clk clock.Clock, logger logger.Logger) *WSConn {
+	if maxLine <= 0 {
+		maxLine = DefaultMaxLineLength
+	}
+	// Allow room for a "\r\n" line ending
+	ws.SetReadLimit(int64(maxLine + 2))
+	c := &WSConn{
+		ws:      ws,
+		send:    make(chan string, 10),
+		receive: make(chan string, 10),
+		errors:  make(chan string, 1),
+		logger:  logger.With("transport", "ws", "remote", ws.RemoteAddr().String()),
+		readDL:  newReadDeadline(clk, ws.SetReadDeadline),
+		writeDL: newDeadline(clk, func(t time.Time) error {
+			// The WebSocket only applies its write deadline when a write
+			// starts, so set it on the socket too to interrupt a blocked write
+			ws.SetWriteDeadline(t)
+			return ws.UnderlyingConn().SetWriteDeadline(t)
+		}),
+	}
+	c.closer = newCloser(func() error {
+		c.readDL.disarm()
+		c.writeDL.disarm()
+		return ws.Close()
+	})
+	return c
+}
+
+func (c *WSConn) Send() chan<- string {
+	return c.send
+}
+
+func (c *WSConn) Receive() <-chan string {
+	return c.receive
+}
+
+// RemoteAddr returns the address of the connected client.
+func (c *WSConn) RemoteAddr() net.Addr {
+	return c.ws.RemoteAddr()
+}
+
+// Transport returns "ws".
+func (c *WSConn) Transport() string {
+	return "ws"
+}
+
+// Identity returns the common name of the client's verified TLS
+// certificate, or "" if the client did not present one.
+func (c *WSConn) Identity() string {
+	return c.identity
+}
+
+// setReadTimeout changes how long the client may go without sending a
+// message before it is disconnected. Zero lets it stay silent.
+func (c *WSConn) setReadTimeout(d time.Duration) {
+	c.readDL.setTimeout(d)
+}
+
+func (c *WSConn) pollSocket() {
+	// pollSocket is the only sender on c.receive, so it closes it
+	defer close(c.receive)
+
+	for {
+		err := c.readDL.rearm()
+		if err != nil {
+			c.logger.Error("could not set read deadline", "error", err)
+			c.closeWith(&DisconnectError{Reason: ReasonReadError, Err: err})
+			return
+		}
+
+		kind, data, err := c.ws.ReadMessage()
+		if c.Err() != nil {
+			return
+		}
+		if errors.Is(err, websocket.ErrReadLimit) {
+			// The WebSocket library closes the connection with a "message too big" status
+			c.logger.Info("disconnecting", "error", errLineTooLong)
+			c.Close(ReasonLineTooLong)
+			return
+		}
+		if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+			c.Close(ReasonClientClosed)
+			return
+		}
+		if isTimeout(err) {
+			c.logger.Info("disconnecting", "error", err)
+			c.closeWith(&DisconnectError{Reason: ReasonReadTimeout, Err: err})
+			return
+		}
+		if err != nil {
+			c.logger.Error("could not read from WebSocket", "error", err)
+			c.closeWith(&DisconnectError{Reason: ReasonReadError, Err: err})
+			return
+		}
+		if kind != websocket.TextMessage {
+			continue
+		}
+
+		msg, err := normalizeLine(data)
+		if err != nil {
+			c.logger.Info("dropped malformed message")
+			select {
+			case c.errors <- protocol.MalformedMessageError.Error():
+			default:
+				// an error is already waiting to be sent
+			}
+			continue
+		}
+
+		// Forward to server
+		c.receive <- msg
+	}
+}
+
+func (c *WSConn) pollMessages() {
+	for {
+		// Read server message, or a protocol error from pollSocket
+		var msg string
+		var ok bool
+		select {
+		case msg, ok = <-c.send:
+		case msg = <-c.errors:
+			ok = true
+		}
+		if !ok {
+			c.ws.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+				time.Now().Add(time.Second))
+			c.Close(ReasonServerClosed)
+			return
+		}
+
+		err := c.writeDL.arm(connTimeout)
+		if err == nil {
+			// Forward to client
+			err = c.ws.WriteMessage(websocket.TextMessage, []byte(msg))
+		}
+		if err != nil {
+			if c.Err() == nil {
+				c.logger.Error("could not write to WebSocket", "error", err)
+			}
+			c.closeWith(&DisconnectError{Reason: ReasonWriteError, Err: err})
+			// Let the server finish with the Conn
+			drain(c.send)
+			return
+		}
+	}
+}
 
+func (c *WSConn) poll() {
+	go c.pollSocket()
+	go c.pollMessages()
 }
 
-// ListenWS creates a new WSListener listening for HTTP connections at the server root on the given port.
-func ListenWS(port int, logger logger.Logger) (*TcpListener,error){
-	return nil, nil
+// ListenWS creates a new WSListener listening for HTTP connections at the server root
+// on the given address. Addresses have the same form as for ListenTcp.
+func ListenWS(address string, logger logger.Logger) (*WSListener, error) {
+	return listenWS(address, nil, logger)
+}
+
+// ListenWSTLS creates a new WSListener serving secure WebSockets (wss://) on the given address.
+func ListenWSTLS(address string, opt *TLSOptions, logger logger.Logger) (*WSListener, error) {
+	if opt == nil {
+		return nil, errors.New("could not create WSListener: nil TLSOptions")
+	}
+	return listenWS(address, opt, logger)
+}
+
+func listenWS(address string, opt *TLSOptions, logger logger.Logger) (*WSListener, error) {
+	l, err := listenAddress(address)
+	if err != nil {
+		return nil, fmt.Errorf("could not create WSListener: %w", err)
+	}
+	logger = orNoOp(logger)
+
+	ws := &WSListener{
+		listener:    l,
+		logger:      logger,
+		connections: make(chan Conn, 100),
+		done:        make(chan struct{}),
+		upgrader: websocket.Upgrader{
+			// Clients are not necessarily browsers served from this host
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+	}
+	ws.server = &http.Server{
+		Handler:           http.HandlerFunc(ws.upgrade),
+		ReadHeaderTimeout: connTimeout,
+	}
+
+	if opt != nil {
+		reloader, err := newTLSReloader(opt, logger)
+		if err != nil {
+			l.Close()
+			return nil, fmt.Errorf("could not create WSListener: %w", err)
+		}
+		ws.tls = reloader
+		ws.handshakes = &handshakeListener{
+			Listener: tls.NewListener(l, reloader.config()),
+			pending:  make(map[net.Conn]*deadline),
+		}
+		ws.listener = ws.handshakes
+		ws.server.ConnState = ws.handshakes.connState
+	}
+	return ws, nil
+}
+
+// handshakeListener arms a deadline on each connection it accepts, as
+// TcpConn.poll does, so a client that never finishes its TLS handshake is
+// disconnected. The deadline is removed once the client's request is read.
+type handshakeListener struct {
+	net.Listener
+	clock   clock.Clock
+	mux     sync.Mutex
+	pending map[net.Conn]*deadline
+}
+
+func (l *handshakeListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	dl := newDeadline(l.clock, conn.SetDeadline)
+	dl.arm(connTimeout)
+	l.mux.Lock()
+	l.pending[conn] = dl
+	l.mux.Unlock()
+	return conn, nil
+}
+
+// connState is the http.Server's ConnState hook.
+func (l *handshakeListener) connState(conn net.Conn, state http.ConnState) {
+	if state == http.StateNew {
+		return
+	}
+	l.mux.Lock()
+	dl, ok := l.pending[conn]
+	delete(l.pending, conn)
+	l.mux.Unlock()
+	if ok {
+		// The http.Server sets its own deadlines from here on
+		dl.disarm()
+	}
+}
+
+func (ws *WSListener) upgrade(w http.ResponseWriter, r *http.Request) {
+	ws.mux.RLock()
+	defer ws.mux.RUnlock()
+
+	if ws.closed {
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	if err := ws.access.checkHostPort(r.RemoteAddr); err != nil {
+		ws.logger.Info("rejected WebSocket connection", "remote", r.RemoteAddr, "error", err)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	conn, err := ws.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		ws.logger.Error("could not upgrade to WebSocket", "remote", r.RemoteAddr, "error", err)
+		return
+	}
+	wsConn := newWSConn(conn, ws.maxLine, ws.clock, ws.logger)
+	if r.TLS != nil {
+		wsConn.identity = clientIdentity(*r.TLS)
+	}
+	go wsConn.poll()
+	select {
+	case ws.connections <- wsConn:
+	case <-ws.done:
+		// Nobody is accepting connections, and Close is waiting for mux
+		wsConn.Close(ReasonServerClosed)
+	}
 }
 
 func (ws *WSListener) PollAccept() error {
-	panic("implement me")
+	defer ws.Close()
+
+	ws.logger.Info("waiting for WebSocket connections", "address", ws.Addr().String())
+
+	if err := ws.server.Serve(ws.listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("accept error (unrecoverable): %w", err)
+	}
+	return nil
+}
+
+// UseAccessList makes the WSListener refuse WebSocket upgrades from
+// addresses rejected by a.
+func (ws *WSListener) UseAccessList(a *AccessList) *WSListener {
+	ws.access = a
+	return ws
+}
+
+// UseMaxLineLength sets the longest message a client may send. Clients that
+// send a longer message are disconnected. If n is zero, DefaultMaxLineLength is used.
+func (ws *WSListener) UseMaxLineLength(n int) *WSListener {
+	ws.maxLine = n
+	return ws
+}
+
+// UseClock sets the Clock that times read and write deadlines on the
+// WSListener's connections. It should be the Server's Clock.
+func (ws *WSListener) UseClock(c clock.Clock) *WSListener {
+	ws.clock = c
+	if ws.handshakes != nil {
+		ws.handshakes.clock = c
+	}
+	return ws
+}
+
+// Addr returns the address the WSListener accepts connections on.
+func (ws *WSListener) Addr() net.Addr {
+	return ws.listener.Addr()
 }
 
 func (ws *WSListener) Connections() <-chan Conn {
-	panic("implement me")
+	return ws.connections
 }
 
 func (ws *WSListener) Close() error {
-	panic("implement me")
-}
+	ws.closeOnce.Do(func() { close(ws.done) })
+	err := ws.server.Close()
 
+	ws.mux.Lock()
+	defer ws.mux.Unlock()
+	if ws.closed {
+		return nil
+	}
+	ws.closed = true
+	if ws.tls != nil {
+		ws.tls.stop()
+	}
+	close(ws.connections)
+	if err != nil {
+		return fmt.Errorf("error closing: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/jeremyt135/tictactoe/pkg/clock"
+	"github.com/jeremyt135/tictactoe/pkg/logger"
+)
+
+// dialWS connects a WebSocket client to l and returns both ends.
+func dialWS(t *testing.T, l *WSListener, scheme string, dialer *websocket.Dialer) (*websocket.Conn, Conn) {
+	t.Helper()
+	client, _, err := dialer.Dial(scheme+"://"+l.Addr().String()+"/", nil)
+	if err != nil {
+		t.Fatalf("could not connect with WebSocket: %v", err)
+	}
+	select {
+	case c := <-l.Connections():
+		return client, c
+	case <-time.After(5 * time.Second):
+		t.Fatal("WSListener did not accept the connection")
+	}
+	return nil, nil
+}
+
+func listenWSTest(t *testing.T) *WSListener {
+	t.Helper()
+	l, err := ListenWS("127.0.0.1:0", logger.NoOpLogger())
+	if err != nil {
+		t.Fatalf("ListenWS returned error: %v", err)
+	}
+	go l.PollAccept()
+	return l
+}
+
+// expectClose reads from client until the server closes the WebSocket, and
+// checks the close code.
+func expectClose(t *testing.T, client *websocket.Conn, code int) {
+	t.Helper()
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, _, err := client.ReadMessage()
+		if err == nil {
+			continue
+		}
+		if !websocket.IsCloseError(err, code) {
+			t.Errorf("client read error %v, expected close code %d", err, code)
+		}
+		return
+	}
+}
+
+func TestWSListenerUpgrade(t *testing.T) {
+	l := listenWSTest(t)
+	defer l.Close()
+
+	client, c := dialWS(t, l, "ws", websocket.DefaultDialer)
+	defer client.Close()
+	if c.Transport() != "ws" {
+		t.Errorf("Conn has transport %q, expected ws", c.Transport())
+	}
+
+	c.Send() <- "HELLO\n"
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if kind, data, err := client.ReadMessage(); err != nil || kind != websocket.TextMessage || string(data) != "HELLO\n" {
+		t.Errorf("client read %q (kind %d, err %v), expected text %q", data, kind, err, "HELLO\n")
+	}
+
+	// Each text message is a line, with or without its line ending
+	client.WriteMessage(websocket.TextMessage, []byte("HI"))
+	client.WriteMessage(websocket.TextMessage, []byte("THERE\r\n"))
+	for _, want := range []string{"HI\n", "THERE\n"} {
+		if msg := <-c.Receive(); msg != want {
+			t.Errorf("server received %q, expected %q", msg, want)
+		}
+	}
+}
+
+func TestWSConnLineTooLong(t *testing.T) {
+	l := listenWSTest(t)
+	l.UseMaxLineLength(8)
+	defer l.Close()
+
+	client, c := dialWS(t, l, "ws", websocket.DefaultDialer)
+	defer client.Close()
+	client.WriteMessage(websocket.TextMessage, []byte(strings.Repeat("x", 20)))
+
+	if err := waitDone(t, c); err.Reason != ReasonLineTooLong {
+		t.Errorf("Conn closed with reason %q, expected %q", err.Reason, ReasonLineTooLong)
+	}
+	expectClose(t, client, websocket.CloseMessageTooBig)
+}
+
+func TestWSConnDisconnectReasons(t *testing.T) {
+	l := listenWSTest(t)
+	defer l.Close()
+
+	// The client says goodbye
+	client, c := dialWS(t, l, "ws", websocket.DefaultDialer)
+	client.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	if err := waitDone(t, c); err.Reason != ReasonClientClosed {
+		t.Errorf("Conn closed with reason %q, expected %q", err.Reason, ReasonClientClosed)
+	}
+	if _, ok := <-c.Receive(); ok {
+		t.Error("Receive was not closed after the Conn was closed")
+	}
+	close(c.Send())
+	client.Close()
+
+	// The server finishes with the connection
+	client, c = dialWS(t, l, "ws", websocket.DefaultDialer)
+	defer client.Close()
+	close(c.Send())
+	if err := waitDone(t, c); err.Reason != ReasonServerClosed {
+		t.Errorf("Conn closed with reason %q, expected %q", err.Reason, ReasonServerClosed)
+	}
+	expectClose(t, client, websocket.CloseNormalClosure)
+}
+
+func TestWSListenerCloseWhileUpgrading(t *testing.T) {
+	l, err := ListenWS("127.0.0.1:0", logger.NoOpLogger())
+	if err != nil {
+		t.Fatalf("ListenWS returned error: %v", err)
+	}
+	// Nobody accepts connections, so the upgrade can't hand one over
+	l.connections = make(chan Conn)
+	go l.PollAccept()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws://"+l.Addr().String()+"/", nil)
+	if err != nil {
+		t.Fatalf("could not connect with WebSocket: %v", err)
+	}
+	defer client.Close()
+	time.Sleep(10 * time.Millisecond) // for the upgrade to block
+
+	closed := make(chan struct{})
+	go func() {
+		l.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked on a connection nobody accepted")
+	}
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := client.ReadMessage(); err == nil {
+		t.Error("client read a message, expected the connection to be closed")
+	}
+}
+
+func TestListenWSTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tictactoe-wss")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cert, key := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := GenerateSelfSigned(cert, key, []string{"127.0.0.1"}); err != nil {
+		t.Fatalf("GenerateSelfSigned returned error: %v", err)
+	}
+	l, err := ListenWSTLS("127.0.0.1:0", &TLSOptions{CertFile: cert, KeyFile: key}, logger.NoOpLogger())
+	if err != nil {
+		t.Fatalf("ListenWSTLS returned error: %v", err)
+	}
+	go l.PollAccept()
+	defer l.Close()
+
+	certPEM, err := ioutil.ReadFile(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(certPEM)
+
+	if _, _, err := websocket.DefaultDialer.Dial("wss://"+l.Addr().String()+"/", nil); err == nil {
+		t.Error("client connected without trusting the certificate, expected a TLS error")
+	}
+	dialer := &websocket.Dialer{TLSClientConfig: &tls.Config{RootCAs: roots}}
+	client, c := dialWS(t, l, "wss", dialer)
+	defer client.Close()
+
+	c.Send() <- "HELLO\n"
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, data, err := client.ReadMessage(); err != nil || string(data) != "HELLO\n" {
+		t.Errorf("client read %q (err %v), expected %q", data, err, "HELLO\n")
+	}
+	client.WriteMessage(websocket.TextMessage, []byte("HI\n"))
+	if msg := <-c.Receive(); msg != "HI\n" {
+		t.Errorf("server received %q, expected %q", msg, "HI\n")
+	}
+}
+
+func TestListenWSTLSHandshakeTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tictactoe-wss")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cert, key := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := GenerateSelfSigned(cert, key, []string{"127.0.0.1"}); err != nil {
+		t.Fatalf("GenerateSelfSigned returned error: %v", err)
+	}
+	l, err := ListenWSTLS("127.0.0.1:0", &TLSOptions{CertFile: cert, KeyFile: key}, logger.NoOpLogger())
+	if err != nil {
+		t.Fatalf("ListenWSTLS returned error: %v", err)
+	}
+	clk := clock.NewFake(time.Now())
+	l.UseClock(clk)
+	go l.PollAccept()
+	defer l.Close()
+
+	// The client connects but never starts the handshake
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("could not connect: %v", err)
+	}
+	defer client.Close()
+	clk.BlockUntil(1)
+	clk.Advance(connTimeout)
+
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := client.Read(make([]byte, 1)); isTimeout(err) {
+		t.Error("connection was still open after the handshake timed out")
+	}
+}
@@ -0,0 +1,575 @@
+// Package tournament provides pairings and standings for competitions made up of
+// many Tic-tac-toe games, such as round-robin, Swiss and single elimination tournaments.
+package tournament
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Format is the pairing system used by a Tournament.
+type Format int
+
+const (
+	// RoundRobin pairs every participant with every other participant once.
+	RoundRobin Format = iota
+	// Swiss pairs participants with similar scores for a fixed number of rounds.
+	Swiss
+	// SingleElimination is a knockout bracket where losers leave the Tournament.
+	SingleElimination
+)
+
+func (f Format) String() string {
+	switch f {
+	case RoundRobin:
+		return "roundrobin"
+	case Swiss:
+		return "swiss"
+	case SingleElimination:
+		return "knockout"
+	default:
+		return fmt.Sprint("Format(", int(f), ")")
+	}
+}
+
+// ParseFormat returns the Format named by s.
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(s) {
+	case "roundrobin", "round-robin":
+		return RoundRobin, nil
+	case "swiss":
+		return Swiss, nil
+	case "knockout", "single-elimination", "singleelimination":
+		return SingleElimination, nil
+	}
+	return 0, fmt.Errorf("unknown tournament format %q", s)
+}
+
+// MaxReplays is the number of drawn games a SingleElimination pairing may have
+// before the higher seed advances.
+const MaxReplays = 2
+
+// ErrRoundInProgress is returned when the next round is requested before every
+// pairing of the current round has a result.
+var ErrRoundInProgress = errors.New("current round is not finished")
+
+// ErrFinished is returned when the next round is requested after the last round.
+var ErrFinished = errors.New("tournament is finished")
+
+// Pairing is a game to be played between two participants. First plays
+// as X. Table identifies the Pairing within its round.
+type Pairing struct {
+	Round, Table  int
+	First, Second string
+}
+
+type match struct {
+	Pairing
+	winner  string // participant that won, or "" for a draw
+	draws   int
+	done    bool
+	forfeit bool // neither participant played, so both lost
+}
+
+// Tournament keeps track of the pairings and results of a competition.
+type Tournament struct {
+	format    Format
+	players   []string // in seed order
+	seeds     map[string]int
+	numRounds int
+	round     int
+	current   []*match
+	history   []*match
+	byes      map[string]bool
+	withdrawn map[string]bool
+	exitRound map[string]int // round in which a participant was knocked out
+}
+
+// New creates a Tournament for the given participants, listed in seed order.
+//
+// Rounds is only used by Swiss tournaments; if it is not positive, enough rounds
+// are played to separate a single winner.
+func New(format Format, players []string, rounds int) (*Tournament, error) {
+	if len(players) < 2 {
+		return nil, errors.New("tournament needs at least 2 players")
+	}
+	t := &Tournament{
+		format:    format,
+		players:   append([]string(nil), players...),
+		seeds:     make(map[string]int, len(players)),
+		byes:      make(map[string]bool),
+		withdrawn: make(map[string]bool),
+		exitRound: make(map[string]int),
+	}
+	for i, p := range players {
+		if p == "" {
+			return nil, errors.New("player name must not be empty")
+		}
+		if _, ok := t.seeds[p]; ok {
+			return nil, fmt.Errorf("duplicate player %q", p)
+		}
+		t.seeds[p] = i
+	}
+
+	switch format {
+	case RoundRobin:
+		t.numRounds = len(players) - 1
+		if len(players)%2 == 1 {
+			t.numRounds++
+		}
+	case Swiss:
+		t.numRounds = rounds
+		if t.numRounds <= 0 {
+			t.numRounds = log2Ceil(len(players))
+		}
+	case SingleElimination:
+		t.numRounds = log2Ceil(len(players))
+	default:
+		return nil, fmt.Errorf("unknown tournament format %v", format)
+	}
+	return t, nil
+}
+
+func log2Ceil(n int) (rounds int) {
+	for size := 1; size < n; size *= 2 {
+		rounds++
+	}
+	return
+}
+
+// Format returns the pairing system of the Tournament.
+func (t *Tournament) Format() Format {
+	return t.format
+}
+
+// Round returns the current round, starting at 1. It is 0 before the first round.
+func (t *Tournament) Round() int {
+	return t.round
+}
+
+// NumRounds returns the number of rounds the Tournament will play.
+func (t *Tournament) NumRounds() int {
+	return t.numRounds
+}
+
+// Players returns the participants in seed order.
+func (t *Tournament) Players() []string {
+	return append([]string(nil), t.players...)
+}
+
+func (t *Tournament) roundDone() bool {
+	for _, m := range t.current {
+		if !m.done {
+			return false
+		}
+	}
+	return true
+}
+
+// IsFinished returns true when every round has been played.
+func (t *Tournament) IsFinished() bool {
+	if !t.roundDone() {
+		return false
+	}
+	if t.format == SingleElimination && t.round > 0 {
+		return len(t.advancing()) <= 1
+	}
+	return t.round >= t.numRounds
+}
+
+// Withdraw removes a participant from future rounds. Their remaining
+// games are forfeited to their opponents.
+func (t *Tournament) Withdraw(name string) {
+	if _, ok := t.seeds[name]; !ok {
+		return
+	}
+	t.withdrawn[name] = true
+}
+
+// NextRound advances the Tournament and returns the pairings that must be played
+// in the new round. Byes and games against withdrawn participants are decided
+// without being returned, so the result may be empty.
+func (t *Tournament) NextRound() ([]Pairing, error) {
+	if !t.roundDone() {
+		return nil, ErrRoundInProgress
+	}
+	if t.IsFinished() {
+		return nil, ErrFinished
+	}
+	t.round++
+
+	var pairs [][2]string
+	switch t.format {
+	case RoundRobin:
+		pairs = t.roundRobinPairs()
+	case Swiss:
+		pairs = t.swissPairs()
+	case SingleElimination:
+		pairs = t.knockoutPairs()
+	}
+
+	t.current = t.current[:0]
+	var toPlay []Pairing
+	for i, pair := range pairs {
+		m := &match{Pairing: Pairing{Round: t.round, Table: i + 1, First: pair[0], Second: pair[1]}}
+		t.current = append(t.current, m)
+		t.history = append(t.history, m)
+
+		first, second := m.First != "" && !t.withdrawn[m.First], m.Second != "" && !t.withdrawn[m.Second]
+		switch {
+		case first && second:
+			toPlay = append(toPlay, m.Pairing)
+			continue
+		case first:
+			m.winner = m.First
+		case second:
+			m.winner = m.Second
+		case m.Second != "":
+			m.forfeit = true
+		}
+		m.done = true
+		if m.Second == "" {
+			t.byes[m.First] = true
+		}
+		t.knockOut(m)
+	}
+	return toPlay, nil
+}
+
+// Record stores the result of a pairing in the current round. Winner is the name
+// of the winning participant, or "" for a draw.
+//
+// In a SingleElimination Tournament a drawn pairing must be replayed, which is
+// reported by returning true. After MaxReplays draws the higher seed advances.
+func (t *Tournament) Record(table int, winner string) (replay bool, err error) {
+	m, err := t.open(table)
+	if err != nil {
+		return false, err
+	}
+	if winner != "" && winner != m.First && winner != m.Second {
+		return false, fmt.Errorf("%q is not playing at table %d", winner, table)
+	}
+
+	if winner == "" && t.format == SingleElimination {
+		m.draws++
+		if m.draws <= MaxReplays {
+			return true, nil
+		}
+		winner = m.First
+		if t.seeds[m.Second] < t.seeds[m.First] {
+			winner = m.Second
+		}
+	}
+	m.winner = winner
+	m.done = true
+	t.knockOut(m)
+	return false, nil
+}
+
+// Forfeit ends a pairing in the current round without playing it, as when
+// both participants have withdrawn. Both are scored with a loss, and in a
+// SingleElimination Tournament neither advances.
+func (t *Tournament) Forfeit(table int) error {
+	m, err := t.open(table)
+	if err != nil {
+		return err
+	}
+	m.winner = ""
+	m.forfeit = true
+	m.done = true
+	t.knockOut(m)
+	return nil
+}
+
+// open returns the match at table in the current round, if it has no result yet.
+func (t *Tournament) open(table int) (*match, error) {
+	if table < 1 || table > len(t.current) {
+		return nil, fmt.Errorf("no table %d in round %d", table, t.round)
+	}
+	m := t.current[table-1]
+	if m.done {
+		return nil, fmt.Errorf("table %d already has a result", table)
+	}
+	return m, nil
+}
+
+func (t *Tournament) knockOut(m *match) {
+	if t.format != SingleElimination {
+		return
+	}
+	for _, p := range []string{m.First, m.Second} {
+		if p != "" && p != m.winner {
+			t.exitRound[p] = m.Round
+		}
+	}
+}
+
+func (t *Tournament) roundRobinPairs() (pairs [][2]string) {
+	// Circle method: the first entry is fixed and the others rotate each round.
+	circle := append([]string(nil), t.players...)
+	if len(circle)%2 == 1 {
+		circle = append(circle, "")
+	}
+	n := len(circle)
+	r := t.round - 1
+	rotated := make([]string, n)
+	rotated[0] = circle[0]
+	for i := 1; i < n; i++ {
+		rotated[i] = circle[1+(i-1+r)%(n-1)]
+	}
+
+	for i := 0; i < n/2; i++ {
+		first, second := rotated[i], rotated[n-1-i]
+		// alternate who plays X so nobody always moves first
+		if (i == 0 && r%2 == 1) || (i > 0 && i%2 == 1) {
+			first, second = second, first
+		}
+		if first == "" {
+			first, second = second, first
+		}
+		pairs = append(pairs, [2]string{first, second})
+	}
+	return
+}
+
+func (t *Tournament) swissPairs() (pairs [][2]string) {
+	scores := t.scores()
+	var active []string
+	for _, p := range t.players {
+		if !t.withdrawn[p] {
+			active = append(active, p)
+		}
+	}
+	sort.SliceStable(active, func(i, j int) bool {
+		return scores[active[i]].Points > scores[active[j]].Points
+	})
+
+	var bye []string
+	if len(active)%2 == 1 {
+		// lowest ranked participant without a bye sits out
+		i := len(active) - 1
+		for j := len(active) - 1; j >= 0; j-- {
+			if !t.byes[active[j]] {
+				i = j
+				break
+			}
+		}
+		bye = []string{active[i]}
+		active = append(active[:i:i], active[i+1:]...)
+	}
+
+	played := t.opponents()
+	paired := make(map[string]bool, len(active))
+	for i, p := range active {
+		if paired[p] {
+			continue
+		}
+		opp := ""
+		for _, q := range active[i+1:] {
+			if paired[q] {
+				continue
+			}
+			if opp == "" {
+				opp = q // fall back to a rematch if there is no one else
+			}
+			if !played[p][q] {
+				opp = q
+				break
+			}
+		}
+		paired[p], paired[opp] = true, true
+		first, second := p, opp
+		if scores[second].FirstMoves < scores[first].FirstMoves {
+			first, second = second, first
+		}
+		pairs = append(pairs, [2]string{first, second})
+	}
+	for _, p := range bye {
+		pairs = append(pairs, [2]string{p, ""})
+	}
+	return
+}
+
+func (t *Tournament) knockoutPairs() (pairs [][2]string) {
+	var entrants []string
+	if t.round == 1 {
+		size := 1 << uint(t.numRounds)
+		for _, seed := range bracketOrder(size) {
+			if seed <= len(t.players) {
+				entrants = append(entrants, t.players[seed-1])
+			} else {
+				entrants = append(entrants, "")
+			}
+		}
+	} else {
+		entrants = t.advancing()
+		if len(entrants)%2 == 1 {
+			// A table without a winner leaves one entrant without an opponent
+			entrants = append(entrants, "")
+		}
+	}
+	for i := 0; i+1 < len(entrants); i += 2 {
+		first, second := entrants[i], entrants[i+1]
+		if first == "" {
+			first, second = second, first
+		}
+		pairs = append(pairs, [2]string{first, second})
+	}
+	return
+}
+
+// advancing returns the winners of the current round in table order.
+func (t *Tournament) advancing() (winners []string) {
+	for _, m := range t.current {
+		if m.winner != "" {
+			winners = append(winners, m.winner)
+		}
+	}
+	return
+}
+
+// bracketOrder returns seeds 1..size ordered so that adjacent pairs make up
+// a standard bracket, where the top two seeds can only meet in the final.
+func bracketOrder(size int) []int {
+	order := []int{1}
+	for n := 1; n < size; n *= 2 {
+		next := make([]int, 0, 2*n)
+		for _, s := range order {
+			next = append(next, s, 2*n+1-s)
+		}
+		order = next
+	}
+	return order
+}
+
+func (t *Tournament) opponents() map[string]map[string]bool {
+	played := make(map[string]map[string]bool, len(t.players))
+	for _, p := range t.players {
+		played[p] = make(map[string]bool)
+	}
+	for _, m := range t.history {
+		if m.First != "" && m.Second != "" {
+			played[m.First][m.Second] = true
+			played[m.Second][m.First] = true
+		}
+	}
+	return played
+}
+
+// Standing is a participant's record and rank in the Tournament.
+type Standing struct {
+	Rank                        int
+	Name                        string
+	Played, Wins, Draws, Losses int
+	// FirstMoves is the number of games played as X.
+	FirstMoves int
+	Points     float64
+	// Buchholz is the sum of the points of every opponent faced.
+	Buchholz float64
+	// SonnebornBerger is the sum of the points of beaten opponents and half the
+	// points of drawn opponents.
+	SonnebornBerger float64
+	Withdrawn       bool
+}
+
+func (t *Tournament) scores() map[string]*Standing {
+	scores := make(map[string]*Standing, len(t.players))
+	for _, p := range t.players {
+		scores[p] = &Standing{Name: p, Withdrawn: t.withdrawn[p]}
+	}
+	for _, m := range t.history {
+		if !m.done {
+			continue
+		}
+		if m.Second == "" {
+			scores[m.First].Points++
+			continue
+		}
+		first, second := scores[m.First], scores[m.Second]
+		first.Played++
+		second.Played++
+		first.FirstMoves++
+		switch {
+		case m.forfeit:
+			first.Losses++
+			second.Losses++
+		case m.winner == m.First:
+			first.Wins++
+			first.Points++
+			second.Losses++
+		case m.winner == m.Second:
+			second.Wins++
+			second.Points++
+			first.Losses++
+		default:
+			first.Draws++
+			second.Draws++
+			first.Points += 0.5
+			second.Points += 0.5
+		}
+	}
+	for _, m := range t.history {
+		if !m.done || m.Second == "" {
+			continue
+		}
+		first, second := scores[m.First], scores[m.Second]
+		first.Buchholz += second.Points
+		second.Buchholz += first.Points
+		switch {
+		case m.forfeit:
+		case m.winner == m.First:
+			first.SonnebornBerger += second.Points
+		case m.winner == m.Second:
+			second.SonnebornBerger += first.Points
+		default:
+			first.SonnebornBerger += second.Points / 2
+			second.SonnebornBerger += first.Points / 2
+		}
+	}
+	return scores
+}
+
+// Standings returns every participant ordered by rank.
+//
+// Participants are ranked by points, then Buchholz, then Sonneborn-Berger, then
+// wins, and finally by seed. In a SingleElimination Tournament participants that
+// went further in the bracket always rank higher.
+func (t *Tournament) Standings() []Standing {
+	scores := t.scores()
+	standings := make([]Standing, 0, len(t.players))
+	for _, p := range t.players {
+		standings = append(standings, *scores[p])
+	}
+
+	exit := func(name string) int {
+		if r, ok := t.exitRound[name]; ok {
+			return r
+		}
+		return t.round + 1
+	}
+	sort.SliceStable(standings, func(i, j int) bool {
+		a, b := standings[i], standings[j]
+		if t.format == SingleElimination && exit(a.Name) != exit(b.Name) {
+			return exit(a.Name) > exit(b.Name)
+		}
+		if a.Points != b.Points {
+			return a.Points > b.Points
+		}
+		if a.Buchholz != b.Buchholz {
+			return a.Buchholz > b.Buchholz
+		}
+		if a.SonnebornBerger != b.SonnebornBerger {
+			return a.SonnebornBerger > b.SonnebornBerger
+		}
+		if a.Wins != b.Wins {
+			return a.Wins > b.Wins
+		}
+		return t.seeds[a.Name] < t.seeds[b.Name]
+	})
+	for i := range standings {
+		standings[i].Rank = i + 1
+	}
+	return standings
+}
@@ -0,0 +1,175 @@
+package tournament
+
+import (
+	"testing"
+)
+
+// playAll plays every round of tour, deciding each game with winner.
+func playAll(t *testing.T, tour *Tournament, winner func(Pairing) string) [][]Pairing {
+	var rounds [][]Pairing
+	for !tour.IsFinished() {
+		pairings, err := tour.NextRound()
+		if err != nil {
+			t.Fatalf("NextRound returned error: %v", err)
+		}
+		for _, p := range pairings {
+			if _, err := tour.Record(p.Table, winner(p)); err != nil {
+				t.Fatalf("Record returned error: %v", err)
+			}
+		}
+		rounds = append(rounds, pairings)
+	}
+	return rounds
+}
+
+func TestRoundRobinPlaysEveryPairOnce(t *testing.T) {
+	players := []string{"a", "b", "c", "d", "e"}
+	tour, _ := New(RoundRobin, players, 0)
+
+	seen := make(map[[2]string]int)
+	rounds := playAll(t, tour, func(p Pairing) string { return "" })
+	for _, pairings := range rounds {
+		for _, p := range pairings {
+			a, b := p.First, p.Second
+			if b < a {
+				a, b = b, a
+			}
+			seen[[2]string{a, b}]++
+		}
+	}
+
+	if len(rounds) != 5 {
+		t.Errorf("round robin of 5 played %d rounds, expected 5", len(rounds))
+	}
+	if len(seen) != 10 {
+		t.Errorf("round robin of 5 played %d distinct games, expected 10", len(seen))
+	}
+	for pair, n := range seen {
+		if n != 1 {
+			t.Errorf("pair %v played %d times, expected once", pair, n)
+		}
+	}
+}
+
+func TestSwissAvoidsRematches(t *testing.T) {
+	players := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	tour, _ := New(Swiss, players, 3)
+
+	seen := make(map[[2]string]bool)
+	rounds := playAll(t, tour, func(p Pairing) string { return p.First })
+	for _, pairings := range rounds {
+		for _, p := range pairings {
+			a, b := p.First, p.Second
+			if b < a {
+				a, b = b, a
+			}
+			if seen[[2]string{a, b}] {
+				t.Errorf("pair %v, %v played more than once", a, b)
+			}
+			seen[[2]string{a, b}] = true
+		}
+	}
+	if len(rounds) != 3 {
+		t.Errorf("swiss played %d rounds, expected 3", len(rounds))
+	}
+}
+
+func TestKnockoutTopSeedWins(t *testing.T) {
+	players := []string{"a", "b", "c", "d", "e", "f"}
+	tour, _ := New(SingleElimination, players, 0)
+
+	seeds := make(map[string]int)
+	for i, p := range players {
+		seeds[p] = i
+	}
+	playAll(t, tour, func(p Pairing) string {
+		if seeds[p.First] < seeds[p.Second] {
+			return p.First
+		}
+		return p.Second
+	})
+
+	standings := tour.Standings()
+	if standings[0].Name != "a" {
+		t.Errorf("knockout winner was %s, expected a", standings[0].Name)
+	}
+	if standings[1].Name != "b" {
+		t.Errorf("knockout runner-up was %s, expected b", standings[1].Name)
+	}
+}
+
+func TestKnockoutDrawIsReplayed(t *testing.T) {
+	tour, _ := New(SingleElimination, []string{"a", "b"}, 0)
+	pairings, _ := tour.NextRound()
+
+	for i := 0; i < MaxReplays; i++ {
+		replay, err := tour.Record(pairings[0].Table, "")
+		if err != nil || !replay {
+			t.Fatalf("draw %d: got replay %v, err %v, expected replay", i+1, replay, err)
+		}
+	}
+	replay, _ := tour.Record(pairings[0].Table, "")
+	if replay {
+		t.Errorf("draw after MaxReplays was replayed, expected higher seed to advance")
+	}
+	if !tour.IsFinished() || tour.Standings()[0].Name != "a" {
+		t.Errorf("expected tournament to finish with a as winner")
+	}
+}
+
+func TestKnockoutByeAfterTableWithoutWinner(t *testing.T) {
+	players := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	tour, _ := New(SingleElimination, players, 0)
+
+	// d and e meet in the first round, but both withdraw
+	tour.Withdraw("d")
+	tour.Withdraw("e")
+	seeds := make(map[string]int)
+	for i, p := range players {
+		seeds[p] = i
+	}
+	rounds := playAll(t, tour, func(p Pairing) string {
+		if seeds[p.First] < seeds[p.Second] {
+			return p.First
+		}
+		return p.Second
+	})
+
+	// c has a bye in the second round and meets a in the final
+	final := rounds[len(rounds)-1]
+	if len(rounds) != 3 || len(final) != 1 {
+		t.Fatalf("played rounds %v, expected 3 rounds ending in a final", rounds)
+	}
+	if got := [2]string{final[0].First, final[0].Second}; got != [2]string{"a", "c"} && got != [2]string{"c", "a"} {
+		t.Errorf("final was between %s and %s, expected a and c", got[0], got[1])
+	}
+}
+
+func TestKnockoutForfeitAdvancesNeither(t *testing.T) {
+	tour, _ := New(SingleElimination, []string{"a", "b", "c", "d"}, 0)
+	pairings, _ := tour.NextRound()
+	if len(pairings) != 2 || pairings[1].First != "b" || pairings[1].Second != "c" {
+		t.Fatalf("first round pairings %v, expected b to play c at the second table", pairings)
+	}
+
+	if err := tour.Forfeit(pairings[1].Table); err != nil {
+		t.Fatalf("Forfeit returned error: %v", err)
+	}
+	if _, err := tour.Record(pairings[1].Table, ""); err == nil {
+		t.Error("Record accepted a result for a forfeited table")
+	}
+	tour.Record(pairings[0].Table, "a")
+
+	if !tour.IsFinished() {
+		t.Fatal("tournament was not finished with only a left")
+	}
+	for _, s := range tour.Standings() {
+		forfeited := s.Name == "b" || s.Name == "c"
+		if forfeited && (s.Points != 0 || s.Losses != 1) {
+			t.Errorf("%s has %v points and %d losses, expected 0 points and a loss", s.Name, s.Points, s.Losses)
+		}
+	}
+	if winner := tour.Standings()[0].Name; winner != "a" {
+		t.Errorf("winner was %s, expected a", winner)
+	}
+}